@@ -0,0 +1,360 @@
+package cbuf
+
+import (
+	"errors"
+	"github.com/fmstephe/matching_engine/trade"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var EvictedErr = errors.New("Consumer was evicted from cbuf.Broadcast")
+
+type Broadcast = BroadcastRing[trade.Response]
+type Consumer = BroadcastConsumer[trade.Response]
+
+func NewBroadcast(size int, newWait NewWaitStrategy) *Broadcast {
+	return NewBroadcastRing[trade.Response](size, newWait)
+}
+
+// A buffer written by one goroutine and read in full by any number of consumers, each on its own goroutine
+// with its own cursor. The writer is held up by the slowest consumer, a consumer which holds it up for too
+// long can be evicted. With no consumers registered values are discarded.
+type BroadcastRing[T any] struct {
+	_         [cacheLine]byte
+	published Sequence
+	closed    Sequence
+	// Writer's state
+	write     int64
+	pub       int64
+	cachedMin int64
+	_         [cacheLine - 24]byte
+	size      int64
+	sizeMask  int64
+	vals      []T
+	newWait   NewWaitStrategy
+	writeWait WaitStrategy
+	evictFor  time.Duration
+	mu        sync.Mutex   // Guards changes to consumers
+	consumers atomic.Value // []*BroadcastConsumer[T], replaced on each change
+}
+
+type ConsumerStats struct {
+	Name      string
+	Reads     int64         // Values read
+	Lag       int64         // Values published but not yet read
+	MaxLag    int64         // The greatest lag seen when reading
+	Stalls    int64         // The number of times the writer waited for this consumer
+	StallTime time.Duration // The total time the writer waited for this consumer
+	Evicted   bool
+}
+
+type BroadcastConsumer[T any] struct {
+	_         [cacheLine]byte
+	released  Sequence // Values before released have been read
+	reading   Sequence // 1 while the consumer is copying values, see evict
+	evicted   Sequence
+	maxLag    int64 // Written by the consumer
+	stalls    int64 // Written by the writer
+	stallTime int64 // Written by the writer
+	_         [cacheLine - 24]byte
+	// Consumer's state
+	start     int64
+	read      int64
+	cachedPub int64
+	b         *BroadcastRing[T]
+	name      string
+	wait      WaitStrategy
+}
+
+func NewBroadcastRing[T any](size int, newWait NewWaitStrategy) *BroadcastRing[T] {
+	realSize := ringSize(size)
+	b := &BroadcastRing[T]{size: int64(realSize), sizeMask: int64(realSize - 1), vals: make([]T, realSize), newWait: newWait, writeWait: newWait()}
+	b.consumers.Store([]*BroadcastConsumer[T]{})
+	return b
+}
+
+// A consumer which holds the writer up for longer than d is evicted, its reads return EvictedErr.
+// A d of 0, the default, never evicts.
+func (b *BroadcastRing[T]) SetEviction(d time.Duration) {
+	b.evictFor = d
+}
+
+// Adds a consumer which will read every value published from now on. Its cursor is set before the writer
+// can see it. A writer which last looked at the consumers before then may overwrite values up to the one
+// it had published, see slowest, so the consumer starts from the values published once it is visible.
+func (b *BroadcastRing[T]) Register(name string) *BroadcastConsumer[T] {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c := &BroadcastConsumer[T]{b: b, name: name, wait: b.newWait()}
+	c.released.Store(b.published.Load())
+	consumers := b.Consumers()
+	b.consumers.Store(append(consumers[:len(consumers):len(consumers)], c))
+	c.start = b.published.Load()
+	c.read = c.start
+	c.cachedPub = c.read
+	c.released.Store(c.read)
+	return c
+}
+
+// Removes a consumer, it no longer holds the writer up
+func (b *BroadcastRing[T]) Unregister(c *BroadcastConsumer[T]) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	consumers := b.Consumers()
+	remaining := make([]*BroadcastConsumer[T], 0, len(consumers))
+	for _, rc := range consumers {
+		if rc != c {
+			remaining = append(remaining, rc)
+		}
+	}
+	b.consumers.Store(remaining)
+}
+
+// The registered consumers, evicted consumers are no longer registered
+func (b *BroadcastRing[T]) Consumers() []*BroadcastConsumer[T] {
+	return b.consumers.Load().([]*BroadcastConsumer[T])
+}
+
+// Claims the next value for writing, waiting while the slowest consumer has yet to read the value it
+// replaces. Anything already written is published before waiting.
+func (b *BroadcastRing[T]) GetForWrite() (*T, error) {
+	if b.write-b.cachedMin == b.size {
+		b.waitFree(1)
+	}
+	v := &b.vals[b.write&b.sizeMask]
+	b.write++
+	return v, nil
+}
+
+// Claims up to n contiguous values for writing, waiting while the buffer is full. Fewer than n are
+// returned if the slowest consumer leaves less room or the claim would wrap past the end of the buffer.
+func (b *BroadcastRing[T]) GetBatchForWrite(n int) []T {
+	if b.size-(b.write-b.cachedMin) < int64(n) {
+		b.cachedMin, _ = b.slowest()
+	}
+	if b.write-b.cachedMin == b.size {
+		b.waitFree(1)
+	}
+	w := b.write & b.sizeMask
+	k := min(int64(n), b.size-(b.write-b.cachedMin), b.size-w)
+	b.write += k
+	return b.vals[w : w+k]
+}
+
+// The number of values which can be written before the slowest consumer holds the writer up
+func (b *BroadcastRing[T]) Free() int {
+	b.cachedMin, _ = b.slowest()
+	return int(b.size - (b.write - b.cachedMin))
+}
+
+// Waits until n values, or the whole buffer if it is smaller, can be written without waiting
+func (b *BroadcastRing[T]) WaitFree(n int) {
+	if int64(n) > b.size {
+		n = int(b.size)
+	}
+	b.waitFree(int64(n))
+}
+
+func (b *BroadcastRing[T]) waitFree(n int64) {
+	var slowest *BroadcastConsumer[T]
+	b.cachedMin, slowest = b.slowest()
+	if b.write-b.cachedMin > b.size-n {
+		b.Publish()
+		b.cachedMin, slowest = b.slowest()
+	}
+	var start time.Time
+	// Wakes a parked writer once the slowest consumer is due for eviction
+	var deadline *time.Timer
+	for i := 0; b.write-b.cachedMin > b.size-n; i++ {
+		if i == 0 {
+			start = time.Now()
+			atomic.AddInt64(&slowest.stalls, 1)
+			if b.evictFor > 0 {
+				deadline = time.AfterFunc(b.evictFor, b.writeWait.Signal)
+				defer deadline.Stop()
+			}
+		}
+		if b.evictFor > 0 && time.Since(start) >= b.evictFor {
+			b.evict(slowest)
+		} else {
+			b.writeWait.Wait(i)
+		}
+		waited := slowest
+		b.cachedMin, slowest = b.slowest()
+		if slowest != waited {
+			atomic.AddInt64(&waited.stallTime, int64(time.Since(start)))
+			start = time.Now()
+			if deadline != nil {
+				deadline.Reset(b.evictFor)
+			}
+			if slowest != nil {
+				atomic.AddInt64(&slowest.stalls, 1)
+			}
+		}
+	}
+	if slowest != nil && !start.IsZero() {
+		atomic.AddInt64(&slowest.stallTime, int64(time.Since(start)))
+	}
+}
+
+// The least released cursor of any consumer, and the consumer it belongs to. Without consumers only
+// unpublished values hold the writer up, as a consumer registering now starts from the published cursor.
+func (b *BroadcastRing[T]) slowest() (int64, *BroadcastConsumer[T]) {
+	min := b.pub
+	var slowest *BroadcastConsumer[T]
+	for _, c := range b.Consumers() {
+		if rel := c.released.Load(); rel < min {
+			min = rel
+			slowest = c
+		}
+	}
+	return min, slowest
+}
+
+// Nothing the consumer is copying is overwritten, a read either sees it evicted or finishes before the
+// writer carries on
+func (b *BroadcastRing[T]) evict(c *BroadcastConsumer[T]) {
+	c.evicted.Store(1)
+	for c.reading.Load() == 1 {
+		runtime.Gosched()
+	}
+	b.Unregister(c)
+	c.wait.Signal()
+}
+
+// Makes every value written so far visible to consumers
+func (b *BroadcastRing[T]) Publish() {
+	if b.pub == b.write {
+		return
+	}
+	b.pub = b.write
+	b.published.Store(b.pub)
+	for _, c := range b.Consumers() {
+		c.wait.Signal()
+	}
+}
+
+// Publishes anything written and tells consumers nothing more will follow
+func (b *BroadcastRing[T]) Close() {
+	b.Publish()
+	b.closed.Store(1)
+	for _, c := range b.Consumers() {
+		c.wait.Signal()
+	}
+}
+
+func (b *BroadcastRing[T]) Writes() int {
+	return int(b.write)
+}
+
+// Copies the next published value into r, returning ReadErr if there isn't one. Values are copied rather
+// than lent out so the writer never overwrites a value a consumer is still using.
+func (c *BroadcastConsumer[T]) Read(r *T) error {
+	if c.read == c.cachedPub {
+		c.cachedPub = c.b.published.Load()
+		if c.read == c.cachedPub {
+			return c.readErr()
+		}
+	}
+	if !c.beginRead() {
+		return EvictedErr
+	}
+	*r = c.b.vals[c.read&c.b.sizeMask]
+	c.reading.Store(0)
+	c.advance(1)
+	return nil
+}
+
+// Copies up to len(vals) published values into vals, returning how many were copied or ReadErr if there
+// were none
+func (c *BroadcastConsumer[T]) ReadBatch(vals []T) (int, error) {
+	if c.cachedPub-c.read < int64(len(vals)) {
+		c.cachedPub = c.b.published.Load()
+		if c.read == c.cachedPub {
+			return 0, c.readErr()
+		}
+	}
+	if !c.beginRead() {
+		return 0, EvictedErr
+	}
+	n := min(int64(len(vals)), c.cachedPub-c.read)
+	r := c.read & c.b.sizeMask
+	copied := int64(copy(vals[:n], c.b.vals[r:]))
+	copy(vals[copied:n], c.b.vals)
+	c.reading.Store(0)
+	c.advance(n)
+	return int(n), nil
+}
+
+// Marks the consumer as reading, returning false if it has been evicted. The writer marks a consumer
+// evicted before checking whether it is reading, so one of them always sees the other.
+func (c *BroadcastConsumer[T]) beginRead() bool {
+	c.reading.Store(1)
+	if c.evicted.Load() == 1 {
+		c.reading.Store(0)
+		return false
+	}
+	return true
+}
+
+func (c *BroadcastConsumer[T]) readErr() error {
+	if c.evicted.Load() == 1 {
+		return EvictedErr
+	}
+	return ReadErr
+}
+
+func (c *BroadcastConsumer[T]) advance(n int64) {
+	c.read += n
+	c.released.Store(c.read)
+	c.b.writeWait.Signal()
+	if lag := c.cachedPub - c.read; lag > c.maxLag {
+		atomic.StoreInt64(&c.maxLag, lag)
+	}
+}
+
+// As Read, but waits for a value to be published. Returns ReadErr once the buffer is closed and every
+// value has been read.
+func (c *BroadcastConsumer[T]) WaitForRead(r *T) error {
+	for i := 0; ; i++ {
+		err := c.Read(r)
+		if err != ReadErr {
+			return err
+		}
+		if c.b.closed.Load() == 1 {
+			return c.Read(r)
+		}
+		c.wait.Wait(i)
+	}
+}
+
+// As ReadBatch, but waits for a value to be published. Returns ReadErr once the buffer is closed and every
+// value has been read.
+func (c *BroadcastConsumer[T]) WaitForReadBatch(vals []T) (int, error) {
+	for i := 0; ; i++ {
+		n, err := c.ReadBatch(vals)
+		if err != ReadErr {
+			return n, err
+		}
+		if c.b.closed.Load() == 1 {
+			return c.ReadBatch(vals)
+		}
+		c.wait.Wait(i)
+	}
+}
+
+// May be called from any goroutine
+func (c *BroadcastConsumer[T]) Stats() ConsumerStats {
+	released := c.released.Load()
+	return ConsumerStats{
+		Name:      c.name,
+		Reads:     released - c.start,
+		Lag:       c.b.published.Load() - released,
+		MaxLag:    atomic.LoadInt64(&c.maxLag),
+		Stalls:    atomic.LoadInt64(&c.stalls),
+		StallTime: time.Duration(atomic.LoadInt64(&c.stallTime)),
+		Evicted:   c.evicted.Load() == 1,
+	}
+}
@@ -0,0 +1,87 @@
+package cbuf
+
+import (
+	"github.com/fmstephe/matching_engine/trade"
+	"runtime"
+	"testing"
+)
+
+const benchBufSize = 1024
+
+func BenchmarkSPSCSpin(b *testing.B) {
+	if runtime.GOMAXPROCS(0) < 2 {
+		b.Skip("Spinning needs a processor for each side")
+	}
+	benchSPSC(b, SpinWait)
+}
+
+func BenchmarkSPSCYield(b *testing.B) {
+	benchSPSC(b, YieldWait)
+}
+
+func BenchmarkSPSCPark(b *testing.B) {
+	benchSPSC(b, ParkWait)
+}
+
+func BenchmarkChannel(b *testing.B) {
+	c := make(chan trade.Response, benchBufSize)
+	go func() {
+		var r trade.Response
+		for i := 0; i < b.N; i++ {
+			r.Price = int64(i)
+			c <- r
+		}
+		close(c)
+	}()
+	for range c {
+	}
+}
+
+func BenchmarkChannelPointer(b *testing.B) {
+	c := make(chan *trade.Response, benchBufSize)
+	go func() {
+		for i := 0; i < b.N; i++ {
+			c <- &trade.Response{Price: int64(i)}
+		}
+		close(c)
+	}()
+	for range c {
+	}
+}
+
+func benchSPSC(b *testing.B, strategy NewWaitStrategy) {
+	rb := NewSPSC(benchBufSize, strategy)
+	go func() {
+		for i := 0; i < b.N; i++ {
+			w, _ := rb.GetForWrite()
+			w.Price = int64(i)
+			rb.Publish()
+		}
+		rb.Close()
+	}()
+	for {
+		if _, err := rb.WaitForRead(); err != nil {
+			return
+		}
+	}
+}
+
+func BenchmarkSPSCParkBatch(b *testing.B) {
+	rb := NewSPSC(benchBufSize, ParkWait)
+	go func() {
+		for i := 0; i < b.N; {
+			vals := rb.GetBatchForWrite(min(64, b.N-i))
+			for j := range vals {
+				vals[j].Price = int64(i)
+				i++
+			}
+			rb.Publish()
+		}
+		rb.Close()
+	}()
+	for {
+		if _, err := rb.WaitForReadBatch(64); err != nil {
+			return
+		}
+	}
+}
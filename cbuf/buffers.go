@@ -3,58 +3,146 @@ package cbuf
 import (
 	"errors"
 	"github.com/fmstephe/matching_engine/trade"
+	"math"
 )
 
 var WriteErr = errors.New("Cannot write to cbuf.Response")
 var ReadErr = errors.New("Cannot read from cbuf.Response")
 
-type Response struct {
+// Implemented by the buffers a matcher can write its responses to
+type ResponseWriter interface {
+	GetForWrite() (*trade.Response, error)
+	// Makes every response written so far visible to readers
+	Publish()
+	// The number of responses which can be written without waiting or failing
+	Free() int
+}
+
+// Implemented by buffers whose readers run on other goroutines, so a writer can wait for them to make room
+type FreeWaiter interface {
+	// Waits until n responses, or the whole buffer if it is smaller, can be written without waiting
+	WaitFree(n int)
+}
+
+type Response = Ring[trade.Response]
+
+func New(size int) *Response {
+	return newRing[trade.Response](size, WriteErr, ReadErr)
+}
+
+// A ResponseWriter which drops every response, for a matcher whose responses nobody reads. Its two slots
+// are handed out in turn, as a matcher writes both sides of a trade before filling either.
+type Discard struct {
+	scratch [2]trade.Response
+	next    int
+}
+
+func (d *Discard) GetForWrite() (*trade.Response, error) {
+	r := &d.scratch[d.next]
+	d.next ^= 1
+	*r = trade.Response{}
+	return r, nil
+}
+
+func (d *Discard) Publish() {}
+
+func (d *Discard) Free() int {
+	return math.MaxInt
+}
+
+// A ring buffer written and read by the same goroutine
+type Ring[T any] struct {
 	sizeMask    int
 	read, write int
-	responses   []trade.Response
+	vals        []T
+	writeErr    error
+	readErr     error
 }
 
-func New(size int) *Response {
+func NewRing[T any](size int) *Ring[T] {
+	return newRing[T](size, WriteErr, ReadErr)
+}
+
+func newRing[T any](size int, writeErr, readErr error) *Ring[T] {
+	realSize := ringSize(size)
+	return &Ring[T]{sizeMask: realSize - 1, vals: make([]T, realSize, realSize), writeErr: writeErr, readErr: readErr}
+}
+
+// The smallest power of two, and at least 2, which can hold size values
+func ringSize(size int) int {
 	realSize := 2
 	for realSize < size {
 		realSize *= 2
 	}
-	return &Response{sizeMask: realSize - 1, responses: make([]trade.Response, realSize, realSize)}
+	return realSize
 }
 
-func (rb *Response) GetForWrite() (*trade.Response, error) {
+func (rb *Ring[T]) GetForWrite() (*T, error) {
 	w := rb.write & rb.sizeMask
 	r := rb.read & rb.sizeMask
 	if rb.write != rb.read && w == r {
-		return nil, WriteErr
+		return nil, rb.writeErr
 	}
-	resp := &rb.responses[w]
+	v := &rb.vals[w]
 	rb.write++
-	return resp, nil
+	return v, nil
+}
+
+// Claims up to n contiguous values for writing. Fewer are returned if the buffer has less room or the
+// claim would wrap past its end.
+func (rb *Ring[T]) GetBatchForWrite(n int) ([]T, error) {
+	free := rb.Free()
+	if free == 0 {
+		return nil, rb.writeErr
+	}
+	w := rb.write & rb.sizeMask
+	k := min(n, free, len(rb.vals)-w)
+	rb.write += k
+	return rb.vals[w : w+k], nil
 }
 
-func (rb *Response) GetForRead() (*trade.Response, error) {
+// Values are visible as soon as they are written
+func (rb *Ring[T]) Publish() {}
+
+func (rb *Ring[T]) Free() int {
+	return len(rb.vals) - (rb.write - rb.read)
+}
+
+func (rb *Ring[T]) GetForRead() (*T, error) {
 	if rb.read == rb.write {
-		return nil, ReadErr
+		return nil, rb.readErr
 	}
 	r := rb.read & rb.sizeMask
-	resp := &rb.responses[r]
+	v := &rb.vals[r]
 	rb.read++
-	return resp, nil
+	return v, nil
+}
+
+// Returns up to n contiguous values for reading. Fewer are returned if fewer have been written or the
+// read would wrap past the end of the buffer.
+func (rb *Ring[T]) GetBatchForRead(n int) ([]T, error) {
+	if rb.read == rb.write {
+		return nil, rb.readErr
+	}
+	r := rb.read & rb.sizeMask
+	k := min(n, rb.write-rb.read, len(rb.vals)-r)
+	rb.read += k
+	return rb.vals[r : r+k], nil
 }
 
-func (rb *Response) Clear() {
-	for i := 0; i < len(rb.responses); i++ {
-		rb.responses[i] = trade.Response{}
+func (rb *Ring[T]) Clear() {
+	var zero T
+	for i := 0; i < len(rb.vals); i++ {
+		rb.vals[i] = zero
 	}
 	rb.read = 0
 	rb.write = 0
 }
 
-func (rv *Response) Reads() int {
-	return rv.read
+func (rb *Ring[T]) Reads() int {
+	return rb.read
 }
 
-func (rv *Response) Writes() int {
-	return rv.write
+func (rb *Ring[T]) Writes() int {
+	return rb.write
 }
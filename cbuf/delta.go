@@ -0,0 +1,15 @@
+package cbuf
+
+import (
+	"errors"
+	"github.com/fmstephe/matching_engine/trade"
+)
+
+var DeltaWriteErr = errors.New("Cannot write to cbuf.Delta")
+var DeltaReadErr = errors.New("Cannot read from cbuf.Delta")
+
+type Delta = Ring[trade.Delta]
+
+func NewDelta(size int) *Delta {
+	return newRing[trade.Delta](size, DeltaWriteErr, DeltaReadErr)
+}
@@ -0,0 +1,160 @@
+package cbuf
+
+import (
+	"runtime"
+	"sync/atomic"
+)
+
+// A buffer written by any number of goroutines and read by exactly one. Writers claim a run of slots with a
+// single atomic add and publish them when they are written, the reader sees a slot only once it and every
+// slot before it has been published. Only the reader uses the wait strategy, writers yield while the buffer
+// is full as several may be waiting at once.
+type MPSCRing[T any] struct {
+	_        [cacheLine]byte
+	claimed  Sequence // Written by writers, slots before claimed have been handed out
+	released Sequence // Written by the reader, slots before released can be overwritten
+	closed   Sequence
+	// Reader's state
+	read      int64
+	rel       int64
+	_         [cacheLine - 16]byte
+	size      int64
+	sizeMask  int64
+	vals      []T
+	available []int64 // The sequence number, plus one, last published into each slot
+	readWait  WaitStrategy
+}
+
+func NewMPSCRing[T any](size int, newWait NewWaitStrategy) *MPSCRing[T] {
+	realSize := ringSize(size)
+	return &MPSCRing[T]{size: int64(realSize), sizeMask: int64(realSize - 1), vals: make([]T, realSize), available: make([]int64, realSize), readWait: newWait()}
+}
+
+// Claims n slots, waiting until the reader has released the values they replace, and returns the sequence
+// number of the first. Each slot is reached through Slot and must be written before the run is published.
+// n must not exceed the size of the buffer.
+func (rb *MPSCRing[T]) Claim(n int) int64 {
+	if int64(n) > rb.size {
+		panic("Claim larger than cbuf.MPSCRing")
+	}
+	end := rb.claimed.Add(int64(n))
+	for end-rb.released.Load() > rb.size {
+		runtime.Gosched()
+	}
+	return end - int64(n)
+}
+
+// The slot for a claimed sequence number
+func (rb *MPSCRing[T]) Slot(seq int64) *T {
+	return &rb.vals[seq&rb.sizeMask]
+}
+
+// Makes the n claimed slots starting at first visible to the reader
+func (rb *MPSCRing[T]) Publish(first int64, n int) {
+	for seq := first; seq < first+int64(n); seq++ {
+		atomic.StoreInt64(&rb.available[seq&rb.sizeMask], seq+1)
+	}
+	rb.readWait.Signal()
+}
+
+// The number of slots which can be claimed without waiting, other writers may claim them first
+func (rb *MPSCRing[T]) Free() int {
+	return int(rb.size - (rb.claimed.Load() - rb.released.Load()))
+}
+
+// Tells the reader nothing more will be claimed, every writer must have published its claims
+func (rb *MPSCRing[T]) Close() {
+	rb.closed.Store(1)
+	rb.readWait.Signal()
+}
+
+// Returns the next published value, or ReadErr if there isn't one. The previously read value is released.
+func (rb *MPSCRing[T]) GetForRead() (*T, error) {
+	r := rb.read & rb.sizeMask
+	if atomic.LoadInt64(&rb.available[r]) != rb.read+1 {
+		return nil, ReadErr
+	}
+	rb.release(rb.read)
+	rb.read++
+	return &rb.vals[r], nil
+}
+
+// Returns up to n contiguous published values, or ReadErr if there are none. Fewer than n are returned if
+// fewer have been published or the read would wrap past the end of the buffer. The previously read values
+// are released.
+func (rb *MPSCRing[T]) GetBatchForRead(n int) ([]T, error) {
+	r := rb.read & rb.sizeMask
+	k := int64(0)
+	for k < int64(n) && r+k < rb.size && atomic.LoadInt64(&rb.available[r+k]) == rb.read+k+1 {
+		k++
+	}
+	if k == 0 {
+		return nil, ReadErr
+	}
+	rb.release(rb.read)
+	rb.read += k
+	return rb.vals[r : r+k], nil
+}
+
+// As GetForRead, but waits for a value to be published. Returns ReadErr once the buffer is closed and
+// every value has been read.
+func (rb *MPSCRing[T]) WaitForRead() (*T, error) {
+	for i := 0; ; i++ {
+		if v, err := rb.GetForRead(); err == nil {
+			return v, nil
+		}
+		if rb.closed.Load() == 1 {
+			return rb.GetForRead()
+		}
+		if i == 0 {
+			rb.Release()
+		}
+		rb.readWait.Wait(i)
+	}
+}
+
+// As GetBatchForRead, but waits for a value to be published. Returns ReadErr once the buffer is closed
+// and every value has been read.
+func (rb *MPSCRing[T]) WaitForReadBatch(n int) ([]T, error) {
+	for i := 0; ; i++ {
+		if vals, err := rb.GetBatchForRead(n); err == nil {
+			return vals, nil
+		}
+		if rb.closed.Load() == 1 {
+			return rb.GetBatchForRead(n)
+		}
+		if i == 0 {
+			rb.Release()
+		}
+		rb.readWait.Wait(i)
+	}
+}
+
+// Calls f with each value published but not yet read, in order and including those behind a slot which
+// hasn't been published, until f returns false. The values are not read. Only the reader may call this.
+func (rb *MPSCRing[T]) Unread(f func(v *T) bool) {
+	end := min(rb.claimed.Load(), rb.read+rb.size)
+	for seq := rb.read; seq < end; seq++ {
+		r := seq & rb.sizeMask
+		if atomic.LoadInt64(&rb.available[r]) == seq+1 && !f(&rb.vals[r]) {
+			return
+		}
+	}
+}
+
+// Releases every value read so far
+func (rb *MPSCRing[T]) Release() {
+	rb.release(rb.read)
+}
+
+func (rb *MPSCRing[T]) release(rel int64) {
+	if rb.rel == rel {
+		return
+	}
+	rb.rel = rel
+	rb.released.Store(rel)
+}
+
+func (rb *MPSCRing[T]) Reads() int {
+	return int(rb.read)
+}
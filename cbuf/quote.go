@@ -0,0 +1,15 @@
+package cbuf
+
+import (
+	"errors"
+	"github.com/fmstephe/matching_engine/trade"
+)
+
+var QuoteWriteErr = errors.New("Cannot write to cbuf.Quote")
+var QuoteReadErr = errors.New("Cannot read from cbuf.Quote")
+
+type Quote = Ring[trade.Quote]
+
+func NewQuote(size int) *Quote {
+	return newRing[trade.Quote](size, QuoteWriteErr, QuoteReadErr)
+}
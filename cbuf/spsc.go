@@ -0,0 +1,219 @@
+package cbuf
+
+import (
+	"github.com/fmstephe/matching_engine/trade"
+	"sync/atomic"
+)
+
+const cacheLine = 64
+
+// A cursor into a ring shared between goroutines, padded to fill a cache line so cursors written by
+// different goroutines don't share one
+type Sequence struct {
+	val int64
+	_   [cacheLine - 8]byte
+}
+
+func (s *Sequence) Load() int64 {
+	return atomic.LoadInt64(&s.val)
+}
+
+func (s *Sequence) Store(val int64) {
+	atomic.StoreInt64(&s.val, val)
+}
+
+func (s *Sequence) Add(delta int64) int64 {
+	return atomic.AddInt64(&s.val, delta)
+}
+
+type SPSC = SPSCRing[trade.Response]
+
+func NewSPSC(size int, newWait NewWaitStrategy) *SPSC {
+	return NewSPSCRing[trade.Response](size, newWait)
+}
+
+// A buffer shared by exactly one writing goroutine and one reading goroutine. Writes become visible to
+// the reader only when they are published, a value returned by GetForRead remains the reader's until its
+// next read or Release.
+type SPSCRing[T any] struct {
+	_         [cacheLine]byte
+	published Sequence // Written by the writer, values before published can be read
+	released  Sequence // Written by the reader, values before released can be overwritten
+	closed    Sequence
+	// Writer's state
+	write     int64
+	pub       int64
+	cachedRel int64
+	_         [cacheLine - 24]byte
+	// Reader's state
+	read      int64
+	rel       int64
+	cachedPub int64
+	_         [cacheLine - 24]byte
+	size      int64
+	sizeMask  int64
+	vals      []T
+	readWait  WaitStrategy
+	writeWait WaitStrategy
+}
+
+func NewSPSCRing[T any](size int, newWait NewWaitStrategy) *SPSCRing[T] {
+	realSize := ringSize(size)
+	return &SPSCRing[T]{size: int64(realSize), sizeMask: int64(realSize - 1), vals: make([]T, realSize), readWait: newWait(), writeWait: newWait()}
+}
+
+// Claims the next value for writing, waiting while the buffer is full. Anything already written is
+// published before waiting, so the reader can make room.
+func (rb *SPSCRing[T]) GetForWrite() (*T, error) {
+	if rb.write-rb.cachedRel == rb.size {
+		rb.waitFree(1)
+	}
+	v := &rb.vals[rb.write&rb.sizeMask]
+	rb.write++
+	return v, nil
+}
+
+// Claims up to n contiguous values for writing, waiting while the buffer is full. Fewer than n are
+// returned if the buffer has less room or the claim would wrap past its end.
+func (rb *SPSCRing[T]) GetBatchForWrite(n int) []T {
+	if rb.size-(rb.write-rb.cachedRel) < int64(n) {
+		rb.cachedRel = rb.released.Load()
+	}
+	if rb.write-rb.cachedRel == rb.size {
+		rb.waitFree(1)
+	}
+	w := rb.write & rb.sizeMask
+	k := min(int64(n), rb.size-(rb.write-rb.cachedRel), rb.size-w)
+	rb.write += k
+	return rb.vals[w : w+k]
+}
+
+// The number of values which can be written without waiting
+func (rb *SPSCRing[T]) Free() int {
+	rb.cachedRel = rb.released.Load()
+	return int(rb.size - (rb.write - rb.cachedRel))
+}
+
+// Waits until n values, or the whole buffer if it is smaller, can be written without waiting
+func (rb *SPSCRing[T]) WaitFree(n int) {
+	if int64(n) > rb.size {
+		n = int(rb.size)
+	}
+	rb.waitFree(int64(n))
+}
+
+func (rb *SPSCRing[T]) waitFree(n int64) {
+	rb.cachedRel = rb.released.Load()
+	for i := 0; rb.write-rb.cachedRel > rb.size-n; i++ {
+		if i == 0 {
+			rb.Publish()
+		}
+		rb.writeWait.Wait(i)
+		rb.cachedRel = rb.released.Load()
+	}
+}
+
+// Makes every value written so far visible to the reader
+func (rb *SPSCRing[T]) Publish() {
+	if rb.pub == rb.write {
+		return
+	}
+	rb.pub = rb.write
+	rb.published.Store(rb.pub)
+	rb.readWait.Signal()
+}
+
+// Publishes anything written and tells the reader nothing more will follow
+func (rb *SPSCRing[T]) Close() {
+	rb.Publish()
+	rb.closed.Store(1)
+	rb.readWait.Signal()
+}
+
+// Returns the next published value, or ReadErr if there isn't one. The previously read value is
+// released.
+func (rb *SPSCRing[T]) GetForRead() (*T, error) {
+	if rb.read == rb.cachedPub {
+		rb.cachedPub = rb.published.Load()
+		if rb.read == rb.cachedPub {
+			return nil, ReadErr
+		}
+	}
+	rb.release(rb.read)
+	v := &rb.vals[rb.read&rb.sizeMask]
+	rb.read++
+	return v, nil
+}
+
+// Returns up to n contiguous published values, or ReadErr if there are none. Fewer than n are returned if
+// fewer have been published or the read would wrap past the end of the buffer. The previously read values
+// are released.
+func (rb *SPSCRing[T]) GetBatchForRead(n int) ([]T, error) {
+	if rb.cachedPub-rb.read < int64(n) {
+		rb.cachedPub = rb.published.Load()
+		if rb.read == rb.cachedPub {
+			return nil, ReadErr
+		}
+	}
+	rb.release(rb.read)
+	r := rb.read & rb.sizeMask
+	k := min(int64(n), rb.cachedPub-rb.read, rb.size-r)
+	rb.read += k
+	return rb.vals[r : r+k], nil
+}
+
+// As GetForRead, but waits for a value to be published. Returns ReadErr once the buffer is closed and
+// every value has been read.
+func (rb *SPSCRing[T]) WaitForRead() (*T, error) {
+	for i := 0; ; i++ {
+		if v, err := rb.GetForRead(); err == nil {
+			return v, nil
+		}
+		if rb.closed.Load() == 1 {
+			return rb.GetForRead()
+		}
+		if i == 0 {
+			rb.Release()
+		}
+		rb.readWait.Wait(i)
+	}
+}
+
+// As GetBatchForRead, but waits for a value to be published. Returns ReadErr once the buffer is closed
+// and every value has been read.
+func (rb *SPSCRing[T]) WaitForReadBatch(n int) ([]T, error) {
+	for i := 0; ; i++ {
+		if vals, err := rb.GetBatchForRead(n); err == nil {
+			return vals, nil
+		}
+		if rb.closed.Load() == 1 {
+			return rb.GetBatchForRead(n)
+		}
+		if i == 0 {
+			rb.Release()
+		}
+		rb.readWait.Wait(i)
+	}
+}
+
+// Releases every value read so far
+func (rb *SPSCRing[T]) Release() {
+	rb.release(rb.read)
+}
+
+func (rb *SPSCRing[T]) release(rel int64) {
+	if rb.rel == rel {
+		return
+	}
+	rb.rel = rel
+	rb.released.Store(rel)
+	rb.writeWait.Signal()
+}
+
+func (rb *SPSCRing[T]) Reads() int {
+	return int(rb.read)
+}
+
+func (rb *SPSCRing[T]) Writes() int {
+	return int(rb.write)
+}
@@ -0,0 +1,195 @@
+package cbuf
+
+import (
+	"github.com/fmstephe/matching_engine/trade"
+	"sync"
+	"testing"
+	"time"
+)
+
+func writeResponses(b *Broadcast, from, to int) {
+	for i := from; i < to; i++ {
+		w, _ := b.GetForWrite()
+		w.Price = int64(i)
+		b.Publish()
+	}
+}
+
+func readResponses(t *testing.T, c *Consumer, from, to int) {
+	var r trade.Response
+	for i := from; i < to; i++ {
+		if err := c.WaitForRead(&r); err != nil {
+			t.Errorf("Unexpected error %s", err.Error())
+			return
+		}
+		if r.Price != int64(i) {
+			t.Errorf("Expecting %d, got %d instead", i, r.Price)
+		}
+	}
+}
+
+func TestBroadcastFanOut(t *testing.T) {
+	const n = 10000
+	b := NewBroadcast(8, ParkWait)
+	var wg sync.WaitGroup
+	consumers := []*Consumer{b.Register("gateway"), b.Register("dropcopy"), b.Register("risk")}
+	for _, c := range consumers {
+		wg.Add(1)
+		go func(c *Consumer) {
+			defer wg.Done()
+			readResponses(t, c, 0, n)
+			var r trade.Response
+			if err := c.WaitForRead(&r); err != ReadErr {
+				t.Errorf("Expecting %v, got %v instead", ReadErr, err)
+			}
+		}(c)
+	}
+	writeResponses(b, 0, n)
+	b.Close()
+	wg.Wait()
+	for _, c := range consumers {
+		stats := c.Stats()
+		if stats.Reads != n || stats.Lag != 0 || stats.Evicted {
+			t.Errorf("Unexpected stats %v", stats)
+		}
+	}
+}
+
+func TestBroadcastSlowest(t *testing.T) {
+	b := NewBroadcast(4, ParkWait)
+	fast := b.Register("fast")
+	slow := b.Register("slow")
+	writeResponses(b, 0, 4)
+	readResponses(t, fast, 0, 4)
+	written := make(chan struct{})
+	go func() {
+		writeResponses(b, 4, 5)
+		close(written)
+	}()
+	select {
+	case <-written:
+		t.Fatal("Expecting the writer to wait for the slow consumer")
+	case <-time.After(10 * time.Millisecond):
+	}
+	if stats := slow.Stats(); stats.Lag != 4 {
+		t.Errorf("Expecting lag %d, got %d instead", 4, stats.Lag)
+	}
+	readResponses(t, slow, 0, 5)
+	<-written
+	readResponses(t, fast, 4, 5)
+	if stats := slow.Stats(); stats.Stalls == 0 || stats.StallTime == 0 {
+		t.Errorf("Expecting stalls to be recorded, got %v instead", stats)
+	}
+	if stats := fast.Stats(); stats.Stalls != 0 {
+		t.Errorf("Expecting no stalls, got %v instead", stats)
+	}
+}
+
+func TestBroadcastEviction(t *testing.T) {
+	const n = 100
+	b := NewBroadcast(4, ParkWait)
+	b.SetEviction(5 * time.Millisecond)
+	fast := b.Register("fast")
+	stuck := b.Register("stuck")
+	done := make(chan struct{})
+	go func() {
+		readResponses(t, fast, 0, n)
+		close(done)
+	}()
+	writeResponses(b, 0, n)
+	<-done
+	var r trade.Response
+	if err := stuck.Read(&r); err != EvictedErr {
+		t.Errorf("Expecting %v, got %v instead", EvictedErr, err)
+	}
+	if stats := stuck.Stats(); !stats.Evicted || stats.Stalls == 0 {
+		t.Errorf("Expecting an evicted consumer, got %v instead", stats)
+	}
+	if len(b.Consumers()) != 1 {
+		t.Errorf("Expecting %d consumers, got %d instead", 1, len(b.Consumers()))
+	}
+}
+
+func TestBroadcastLateRegister(t *testing.T) {
+	b := NewBroadcast(4, ParkWait)
+	writeResponses(b, 0, 10)
+	c := b.Register("late")
+	writeResponses(b, 10, 12)
+	readResponses(t, c, 10, 12)
+	if stats := c.Stats(); stats.Reads != 2 {
+		t.Errorf("Expecting %d reads, got %d instead", 2, stats.Reads)
+	}
+}
+
+// A consumer registering while writes are unpublished isn't overwritten by them
+func TestBroadcastRegisterUnpublished(t *testing.T) {
+	b := NewBroadcast(4, ParkWait)
+	for i := 0; i < 5; i++ {
+		w, _ := b.GetForWrite()
+		w.Price = int64(i)
+	}
+	c := b.Register("late")
+	for i := 5; i < 8; i++ {
+		w, _ := b.GetForWrite()
+		w.Price = int64(i)
+	}
+	b.Publish()
+	readResponses(t, c, 4, 8)
+	var r trade.Response
+	if err := c.Read(&r); err != ReadErr {
+		t.Errorf("Expecting %v, got %v instead", ReadErr, err)
+	}
+}
+
+// The writer never waits on mu, which only Register and Unregister take
+func TestBroadcastFreeUnlocked(t *testing.T) {
+	b := NewBroadcast(4, ParkWait)
+	b.Register("c")
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	done := make(chan int)
+	go func() { done <- b.Free() }()
+	select {
+	case free := <-done:
+		if free != 4 {
+			t.Errorf("Expecting %d, got %d instead", 4, free)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Expecting Free not to wait on mu")
+	}
+}
+
+// Consumers registering while the writer runs read every value from the one they start at
+func TestBroadcastConcurrentRegister(t *testing.T) {
+	const values = 100000
+	b := NewBroadcast(8, ParkWait)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				c := b.Register("c")
+				var r trade.Response
+				if err := c.WaitForRead(&r); err != nil {
+					b.Unregister(c)
+					return
+				}
+				next := r.Price + 1
+				for k := 0; k < 20; k++ {
+					if err := c.WaitForRead(&r); err != nil {
+						break
+					}
+					if r.Price != next {
+						t.Errorf("Expecting %d, got %d instead", next, r.Price)
+					}
+					next++
+				}
+				b.Unregister(c)
+			}
+		}()
+	}
+	writeResponses(b, 0, values)
+	b.Close()
+	wg.Wait()
+}
@@ -0,0 +1,171 @@
+package cbuf
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestRingBatch(t *testing.T) {
+	rb := NewRing[int](8)
+	// Advance the cursors so that batches wrap
+	for i := 0; i < 6; i++ {
+		w, _ := rb.GetForWrite()
+		*w = -1
+		rb.GetForRead()
+	}
+	vals, err := rb.GetBatchForWrite(5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vals) != 2 {
+		t.Errorf("Expecting %d, got %d instead", 2, len(vals))
+	}
+	for i := range vals {
+		vals[i] = i
+	}
+	vals, _ = rb.GetBatchForWrite(10)
+	if len(vals) != 6 {
+		t.Errorf("Expecting %d, got %d instead", 6, len(vals))
+	}
+	for i := range vals {
+		vals[i] = i + 2
+	}
+	if _, err := rb.GetBatchForWrite(1); err != WriteErr {
+		t.Errorf("Expecting %v, got %v instead", WriteErr, err)
+	}
+	next := 0
+	for {
+		vals, err := rb.GetBatchForRead(3)
+		if err != nil {
+			break
+		}
+		for _, v := range vals {
+			if v != next {
+				t.Errorf("Expecting %d, got %d instead", next, v)
+			}
+			next++
+		}
+	}
+	if next != 8 {
+		t.Errorf("Expecting %d, got %d instead", 8, next)
+	}
+}
+
+func TestSPSCRingBatch(t *testing.T) {
+	const n = 10000
+	rb := NewSPSCRing[int](16, ParkWait)
+	go func() {
+		for i := 0; i < n; {
+			vals := rb.GetBatchForWrite(min(7, n-i))
+			for j := range vals {
+				vals[j] = i
+				i++
+			}
+			rb.Publish()
+		}
+		rb.Close()
+	}()
+	next := 0
+	for {
+		vals, err := rb.WaitForReadBatch(5)
+		if err != nil {
+			break
+		}
+		for _, v := range vals {
+			if v != next {
+				t.Fatalf("Expecting %d, got %d instead", next, v)
+			}
+			next++
+		}
+	}
+	if next != n {
+		t.Errorf("Expecting %d, got %d instead", n, next)
+	}
+}
+
+func TestMPSCRing(t *testing.T) {
+	const writers, n = 4, 5000
+	rb := NewMPSCRing[[2]int](16, ParkWait)
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < n; {
+				k := min(1+i%3, n-i)
+				first := rb.Claim(k)
+				for j := 0; j < k; j++ {
+					*rb.Slot(first + int64(j)) = [2]int{w, i}
+					i++
+				}
+				rb.Publish(first, k)
+			}
+		}(w)
+	}
+	go func() {
+		wg.Wait()
+		rb.Close()
+	}()
+	// Each writer's values arrive in the order it wrote them
+	next := make([]int, writers)
+	for {
+		vals, err := rb.WaitForReadBatch(8)
+		if err != nil {
+			break
+		}
+		for _, v := range vals {
+			if v[1] != next[v[0]] {
+				t.Fatalf("Expecting %d, got %d instead", next[v[0]], v[1])
+			}
+			next[v[0]]++
+		}
+	}
+	for w := range next {
+		if next[w] != n {
+			t.Errorf("Expecting %d, got %d instead", n, next[w])
+		}
+	}
+}
+
+func TestBroadcastRingBatch(t *testing.T) {
+	const n = 10000
+	b := NewBroadcastRing[int](16, ParkWait)
+	consumers := []*BroadcastConsumer[int]{b.Register("a"), b.Register("b")}
+	go func() {
+		for i := 0; i < n; {
+			vals := b.GetBatchForWrite(min(5, n-i))
+			for j := range vals {
+				vals[j] = i
+				i++
+			}
+			b.Publish()
+		}
+		b.Close()
+	}()
+	var wg sync.WaitGroup
+	for ci, c := range consumers {
+		wg.Add(1)
+		go func(c *BroadcastConsumer[int], size int) {
+			defer wg.Done()
+			vals := make([]int, size)
+			next := 0
+			for {
+				k, err := c.WaitForReadBatch(vals)
+				if err != nil {
+					break
+				}
+				for _, v := range vals[:k] {
+					if v != next {
+						t.Errorf("Expecting %d, got %d instead", next, v)
+						return
+					}
+					next++
+				}
+			}
+			if next != n {
+				t.Errorf("Expecting %d, got %d instead", n, next)
+			}
+		}(c, 3+ci*8)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,98 @@
+package cbuf
+
+import (
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestSPSCPublish(t *testing.T) {
+	rb := NewSPSC(4, SpinWait)
+	w, _ := rb.GetForWrite()
+	w.Price = 1
+	if _, err := rb.GetForRead(); err != ReadErr {
+		t.Errorf("Expecting %v, got %v instead", ReadErr, err)
+	}
+	rb.Publish()
+	r, err := rb.GetForRead()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Price != 1 {
+		t.Errorf("Expecting %d, got %d instead", 1, r.Price)
+	}
+	if _, err := rb.GetForRead(); err != ReadErr {
+		t.Errorf("Expecting %v, got %v instead", ReadErr, err)
+	}
+}
+
+func TestSPSCFull(t *testing.T) {
+	rb := NewSPSC(4, ParkWait)
+	for i := 0; i < 4; i++ {
+		w, _ := rb.GetForWrite()
+		w.Price = int64(i)
+	}
+	// The writer waits for the reader to release a response, publishing what it has written first
+	written := make(chan struct{})
+	go func() {
+		w, _ := rb.GetForWrite()
+		w.Price = 4
+		rb.Close()
+		close(written)
+	}()
+	select {
+	case <-written:
+		t.Fatal("Expecting the writer to wait on a full buffer")
+	case <-time.After(10 * time.Millisecond):
+	}
+	for i := 0; i < 5; i++ {
+		r, err := rb.WaitForRead()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Price != int64(i) {
+			t.Errorf("Expecting %d, got %d instead", i, r.Price)
+		}
+	}
+	<-written
+	if _, err := rb.WaitForRead(); err != ReadErr {
+		t.Errorf("Expecting %v, got %v instead", ReadErr, err)
+	}
+}
+
+func TestSPSCStrategies(t *testing.T) {
+	strategies := map[string]NewWaitStrategy{"yield": YieldWait, "park": ParkWait}
+	// Spinning only makes progress when each side has its own processor
+	if runtime.GOMAXPROCS(0) > 1 {
+		strategies["spin"] = SpinWait
+	}
+	for name, strategy := range strategies {
+		testTransfer(t, name, NewSPSC(8, strategy), 10000)
+	}
+}
+
+func testTransfer(t *testing.T, name string, rb *SPSC, n int) {
+	go func() {
+		for i := 0; i < n; i++ {
+			w, _ := rb.GetForWrite()
+			w.Price = int64(i)
+			w.Amount = uint32(i)
+			if i%3 == 0 {
+				rb.Publish()
+			}
+		}
+		rb.Close()
+	}()
+	for i := 0; i < n; i++ {
+		r, err := rb.WaitForRead()
+		if err != nil {
+			t.Fatalf("%s: %s", name, err.Error())
+		}
+		if r.Price != int64(i) || r.Amount != uint32(i) {
+			t.Errorf("%s: Expecting %d, got %d/%d instead", name, i, r.Price, r.Amount)
+		}
+	}
+	if _, err := rb.WaitForRead(); err != ReadErr {
+		t.Errorf("%s: Expecting %v, got %v instead", name, ReadErr, err)
+	}
+}
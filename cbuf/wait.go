@@ -0,0 +1,70 @@
+package cbuf
+
+import (
+	"runtime"
+)
+
+// Decides what one side of a ring does while it can't make progress
+type WaitStrategy interface {
+	// Called repeatedly while waiting, i counts the calls already made during this wait
+	Wait(i int)
+	// Called by the other side each time it makes progress
+	Signal()
+}
+
+// Each side of a ring gets its own WaitStrategy, so strategies are supplied as constructors
+type NewWaitStrategy func() WaitStrategy
+
+type spinWait struct{}
+
+// Burns a core waiting, for the lowest latency when each side has a core to itself
+func SpinWait() WaitStrategy {
+	return spinWait{}
+}
+
+func (spinWait) Wait(i int) {}
+
+func (spinWait) Signal() {}
+
+type yieldWait struct{}
+
+// Yields the processor between checks
+func YieldWait() WaitStrategy {
+	return yieldWait{}
+}
+
+func (yieldWait) Wait(i int) {
+	runtime.Gosched()
+}
+
+func (yieldWait) Signal() {}
+
+const parkSpins = 100
+
+type parkWait struct {
+	wake chan struct{}
+}
+
+// Spins briefly, then yields, then parks the goroutine until the other side signals. Costs a non-blocking
+// channel send on every signal.
+func ParkWait() WaitStrategy {
+	return &parkWait{wake: make(chan struct{}, 1)}
+}
+
+func (w *parkWait) Wait(i int) {
+	switch {
+	case i < parkSpins:
+	case i < 2*parkSpins:
+		runtime.Gosched()
+	default:
+		<-w.wake
+	}
+}
+
+// A signal with no waiter is kept, so a side which checks and then parks can't miss it
+func (w *parkWait) Signal() {
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,213 @@
+package engine
+
+import (
+	"github.com/fmstephe/matching_engine/cbuf"
+	"github.com/fmstephe/matching_engine/trade"
+)
+
+const (
+	CANCEL_LANE = 0
+	ORDER_LANE  = 1
+)
+
+type Lane struct {
+	Size  int // Orders the lane can hold, rounded up to a power of two
+	Burst int // Orders the lane may take in a row while a lower priority lane has orders waiting, 0 for no bound
+}
+
+type lane struct {
+	ring   *cbuf.MPSCRing[trade.OrderData]
+	burst  int
+	run    int               // Orders taken in a row while a lower priority lane had orders waiting
+	queue  []trade.OrderData // Read from ring but not yet scheduled, a circular queue of n orders from head
+	head   int
+	n      int
+	queued map[int64]int // The number of orders, other than cancels, in queue with each guid
+}
+
+// Feeds a Runner from several inbound lanes, lane 0 having the highest priority. Any goroutine may submit
+// to any lane. A single scheduler moves orders from the lanes into the runner's ring, and it is that order,
+// journalled by the runner, which the matcher sees and a replay reproduces. Orders already in the runner's
+// ring can't be overtaken, so the runner's ring should be small and orders left to queue in the lanes. A
+// cancel never overtakes the order it cancels, it waits while that order is queued in another lane.
+type Lanes struct {
+	r        *Runner
+	lanes    []*lane
+	classify func(od *trade.OrderData) int
+	wait     cbuf.WaitStrategy
+	closed   cbuf.Sequence
+	done     chan struct{}
+}
+
+// Puts cancels in CANCEL_LANE and every other order in ORDER_LANE
+func ByCancel(od *trade.OrderData) int {
+	if od.Kind == trade.CANCEL {
+		return CANCEL_LANE
+	}
+	return ORDER_LANE
+}
+
+// r must not be started, the lanes are its only producer. classify picks the lane each submitted order
+// joins.
+func NewLanes(r *Runner, newWait cbuf.NewWaitStrategy, classify func(od *trade.OrderData) int, lanes ...Lane) *Lanes {
+	l := &Lanes{r: r, classify: classify, wait: newWait(), done: make(chan struct{})}
+	// Every lane signals the scheduler's wait strategy
+	shared := func() cbuf.WaitStrategy { return l.wait }
+	for _, cfg := range lanes {
+		ring := cbuf.NewMPSCRing[trade.OrderData](cfg.Size, shared)
+		l.lanes = append(l.lanes, &lane{ring: ring, burst: cfg.Burst, queue: make([]trade.OrderData, cfg.Size), queued: make(map[int64]int)})
+	}
+	return l
+}
+
+func (l *Lanes) Start() {
+	l.r.Start()
+	go l.run()
+}
+
+// Copies od into its lane, waiting while the lane is full. May be called from any goroutine.
+func (l *Lanes) Submit(od *trade.OrderData) {
+	ln := l.lanes[l.classify(od)]
+	seq := ln.ring.Claim(1)
+	*ln.ring.Slot(seq) = *od
+	ln.ring.Publish(seq, 1)
+}
+
+// Waits until every order submitted has been processed, then stops the runner. Every call to Submit must
+// have returned.
+func (l *Lanes) Stop() {
+	l.closed.Store(1)
+	l.wait.Signal()
+	<-l.done
+	l.r.Stop()
+}
+
+func (l *Lanes) run() {
+	defer close(l.done)
+	for idle := 0; ; idle++ {
+		if i := l.pick(); i >= 0 {
+			l.schedule(i)
+			idle = -1
+			continue
+		}
+		if idle == 0 {
+			l.r.Publish()
+		}
+		if l.closed.Load() == 1 && l.pick() < 0 {
+			return
+		}
+		l.wait.Wait(idle)
+	}
+}
+
+// The highest priority lane with an order waiting which has not used up its burst. A lane which has used
+// up its burst yields to the lanes below it, so if every waiting lane has used up its burst the lowest
+// priority one is picked. A lane whose next order is a cancel waiting on another lane is skipped. Returns -1
+// if no lane has an order waiting.
+func (l *Lanes) pick() int {
+	for i, ln := range l.lanes {
+		if !ln.ready() || l.behind(i) {
+			continue
+		}
+		if ln.burst == 0 || ln.run < ln.burst || !l.readyBelow(i) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (l *Lanes) schedule(i int) {
+	ln := l.lanes[i]
+	if l.readyBelow(i) {
+		ln.run++
+	} else {
+		ln.run = 0
+	}
+	for _, above := range l.lanes[:i] {
+		above.run = 0
+	}
+	*l.r.Claim() = *ln.pop()
+}
+
+// Returns true if the next order in lane i is a cancel whose order is still waiting in another lane. An
+// order submitted before the cancel was published before the cancel was, so it is either in that lane's
+// queue or published in its ring, perhaps behind a slot another producer hasn't published yet.
+func (l *Lanes) behind(i int) bool {
+	c := l.lanes[i].peek()
+	if c.Kind != trade.CANCEL {
+		return false
+	}
+	for j, ln := range l.lanes {
+		if j == i {
+			continue
+		}
+		ln.fill()
+		if ln.queued[c.Guid] > 0 || ln.unread(c.Guid) {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *Lanes) readyBelow(i int) bool {
+	for j := i + 1; j < len(l.lanes); j++ {
+		if l.lanes[j].ready() && !l.behind(j) {
+			return true
+		}
+	}
+	return false
+}
+
+func (ln *lane) ready() bool {
+	if ln.n == 0 {
+		ln.fill()
+	}
+	return ln.n > 0
+}
+
+// Copies published orders out of the ring until the queue is full, and lets producers reuse their slots
+func (ln *lane) fill() {
+	read := false
+	for ln.n < len(ln.queue) {
+		od, err := ln.ring.GetForRead()
+		if err != nil {
+			break
+		}
+		ln.queue[(ln.head+ln.n)%len(ln.queue)] = *od
+		ln.n++
+		if od.Kind != trade.CANCEL {
+			ln.queued[od.Guid]++
+		}
+		read = true
+	}
+	if read {
+		ln.ring.Release()
+	}
+}
+
+// Returns true if an order, other than a cancel, with guid is published in the ring but not yet read
+func (ln *lane) unread(guid int64) bool {
+	found := false
+	ln.ring.Unread(func(od *trade.OrderData) bool {
+		found = od.Kind != trade.CANCEL && od.Guid == guid
+		return !found
+	})
+	return found
+}
+
+func (ln *lane) peek() *trade.OrderData {
+	return &ln.queue[ln.head]
+}
+
+// Removes the next order from the queue, it is only valid until the queue is next filled
+func (ln *lane) pop() *trade.OrderData {
+	od := ln.peek()
+	ln.head = (ln.head + 1) % len(ln.queue)
+	ln.n--
+	if od.Kind != trade.CANCEL {
+		if ln.queued[od.Guid]--; ln.queued[od.Guid] == 0 {
+			delete(ln.queued, od.Guid)
+		}
+	}
+	return od
+}
@@ -0,0 +1,223 @@
+package engine
+
+import (
+	"github.com/fmstephe/matching_engine/cbuf"
+	"github.com/fmstephe/matching_engine/journal"
+	"github.com/fmstephe/matching_engine/matcher"
+	"github.com/fmstephe/matching_engine/trade"
+	"sync"
+)
+
+// A pipeline stage consuming the inbound ring. A stage processes an order only after the stage upstream
+// of it has, and the slowest stage gates the producer.
+type stage struct {
+	upstream   *cbuf.Sequence
+	cursor     cbuf.Sequence
+	wait       cbuf.WaitStrategy
+	downstream []cbuf.WaitStrategy // Signalled each time cursor moves
+	process    func(od *trade.OrderData)
+	flush      func() // Optional, called after each run of orders before they are passed downstream
+}
+
+// Takes orders off the caller's goroutine. The caller claims preallocated slots in an inbound ring and
+// publishes them. A journal stage appends them to a journal, a match stage submits them to the matcher,
+// and a publish stage hands each response to a handler, each stage running on its own goroutine.
+type Runner struct {
+	ring      []trade.OrderData
+	size      int64
+	mask      int64
+	published cbuf.Sequence // Written by the producer, orders before published can be processed
+	closed    cbuf.Sequence
+	// Producer's state
+	claimed       int64
+	cachedMatched int64
+	producerWait  cbuf.WaitStrategy
+	// Stages
+	journal *stage // nil when journalling is off
+	match   *stage
+	m       *matcher.M
+	jw      *journal.Writer
+	out     *cbuf.SPSC
+	handler func(r *trade.Response)
+	newWait cbuf.NewWaitStrategy
+	wg      sync.WaitGroup
+}
+
+// m must write its responses to out, must not have a journal of its own and must not reject orders. The
+// inbound ring holds size orders, rounded up to a power of two. handler is called on the publish stage's
+// goroutine.
+func NewRunner(m *matcher.M, out *cbuf.SPSC, size int, newWait cbuf.NewWaitStrategy, handler func(r *trade.Response)) *Runner {
+	r := newRunner(out, size, newWait, handler)
+	r.m = m
+	r.match.process = r.submit
+	return r
+}
+
+// A runner whose match stage calls process. With a nil handler there is no publish stage, out is read
+// by the caller.
+func newRunner(out *cbuf.SPSC, size int, newWait cbuf.NewWaitStrategy, handler func(r *trade.Response)) *Runner {
+	realSize := 2
+	for realSize < size {
+		realSize *= 2
+	}
+	r := &Runner{ring: make([]trade.OrderData, realSize), size: int64(realSize), mask: int64(realSize - 1), producerWait: newWait(), out: out, handler: handler, newWait: newWait}
+	r.match = &stage{upstream: &r.published, wait: newWait(), downstream: []cbuf.WaitStrategy{r.producerWait}}
+	return r
+}
+
+// Every order will be appended to jw before it is matched. Syncs due during a run of orders are put off
+// until the end of the run, so a burst costs one sync. Must be called before Start.
+func (r *Runner) SetJournal(jw *journal.Writer) {
+	r.jw = jw
+	r.journal = &stage{upstream: &r.published, wait: r.newWait(), downstream: []cbuf.WaitStrategy{r.match.wait}, process: r.append, flush: r.sync}
+	jw.HoldSync()
+	r.match.upstream = &r.journal.cursor
+}
+
+func (r *Runner) Start() {
+	if r.journal != nil {
+		r.wg.Add(1)
+		go func() {
+			defer r.wg.Done()
+			r.run(r.journal)
+		}()
+	}
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		r.run(r.match)
+		r.out.Close()
+	}()
+	if r.handler != nil {
+		r.wg.Add(1)
+		go r.publish()
+	}
+}
+
+// Claims the next inbound slot, waiting while the ring is full. The caller writes an order into it, which
+// is processed once published. Claim and Publish must only be called from a single goroutine.
+func (r *Runner) Claim() *trade.OrderData {
+	if r.claimed-r.cachedMatched == r.size {
+		r.cachedMatched = r.match.cursor.Load()
+		for i := 0; r.claimed-r.cachedMatched == r.size; i++ {
+			if i == 0 {
+				r.Publish()
+			}
+			r.producerWait.Wait(i)
+			r.cachedMatched = r.match.cursor.Load()
+		}
+	}
+	od := &r.ring[r.claimed&r.mask]
+	r.claimed++
+	return od
+}
+
+// Makes every claimed slot available to the pipeline
+func (r *Runner) Publish() {
+	if r.published.Load() == r.claimed {
+		return
+	}
+	r.published.Store(r.claimed)
+	r.first().wait.Signal()
+}
+
+// Copies od into the next slot and publishes it
+func (r *Runner) Submit(od *trade.OrderData) {
+	*r.Claim() = *od
+	r.Publish()
+}
+
+// Publishes any claimed slots and waits until every order has been processed and every response handled
+func (r *Runner) Stop() {
+	r.Publish()
+	r.closed.Store(1)
+	if r.journal != nil {
+		r.journal.wait.Signal()
+	}
+	r.match.wait.Signal()
+	r.wg.Wait()
+}
+
+// The number of orders the match stage has processed, every response they caused has been published
+func (r *Runner) Processed() int64 {
+	return r.match.cursor.Load()
+}
+
+func (r *Runner) first() *stage {
+	if r.journal != nil {
+		return r.journal
+	}
+	return r.match
+}
+
+func (r *Runner) run(st *stage) {
+	var next int64
+	for {
+		avail, ok := r.waitFor(st, next)
+		if !ok {
+			return
+		}
+		for ; next < avail; next++ {
+			st.process(&r.ring[next&r.mask])
+		}
+		if st.flush != nil {
+			st.flush()
+		}
+		st.cursor.Store(next)
+		for _, w := range st.downstream {
+			w.Signal()
+		}
+	}
+}
+
+// Waits until the stage upstream of st has processed orders past next. Returns false once the runner is
+// stopped and st has processed every order published.
+func (r *Runner) waitFor(st *stage, next int64) (int64, bool) {
+	for i := 0; ; i++ {
+		if avail := st.upstream.Load(); avail > next {
+			return avail, true
+		}
+		if r.closed.Load() == 1 && r.published.Load() == next {
+			return 0, false
+		}
+		st.wait.Wait(i)
+	}
+}
+
+func (r *Runner) append(od *trade.OrderData) {
+	if err := r.jw.Append(od); err != nil {
+		panic(err.Error())
+	}
+	od.Seq = r.jw.Seq()
+}
+
+// Syncs the journal at most once for each run of orders the journal stage appends
+func (r *Runner) sync() {
+	if err := r.jw.ReleaseSync(); err != nil {
+		panic(err.Error())
+	}
+	r.jw.HoldSync()
+}
+
+func (r *Runner) submit(od *trade.OrderData) {
+	if r.jw == nil {
+		if err := r.m.Submit(od); err != nil {
+			panic(err.Error())
+		}
+		return
+	}
+	if err := r.m.Apply(od.Seq, od); err != nil {
+		panic(err.Error())
+	}
+}
+
+func (r *Runner) publish() {
+	defer r.wg.Done()
+	for {
+		resp, err := r.out.WaitForRead()
+		if err != nil {
+			return
+		}
+		r.handler(resp)
+	}
+}
@@ -0,0 +1,202 @@
+package engine
+
+import (
+	"bytes"
+	"errors"
+	"github.com/fmstephe/matching_engine/cbuf"
+	"github.com/fmstephe/matching_engine/matcher"
+	"github.com/fmstephe/matching_engine/trade"
+)
+
+var (
+	ErrPaused    = errors.New("Trading is paused")
+	ErrNotPaused = errors.New("Instruments can only be moved while trading is paused")
+)
+
+// A shard runs a matcher for each of its instruments on its own goroutine. Its matchers share a slab and
+// a response ring.
+type shard struct {
+	runner *Runner
+	out    *cbuf.SPSC
+	slab   *trade.Slab
+	books  map[uint32]*matcher.M
+	routed int64 // Orders routed to this shard, owned by the front-end
+	// The merger's next response, read from out but belonging to a later order
+	next    trade.Response
+	hasNext bool
+}
+
+func (s *shard) submit(od *trade.OrderData) {
+	m := s.books[od.StockId]
+	if m == nil {
+		m = matcher.NewMatcherFromSlab(s.slab, s.out)
+		s.books[od.StockId] = m
+	}
+	if err := m.Submit(od); err != nil {
+		panic(err.Error())
+	}
+}
+
+// Copies every response in out caused by the order with sequence number seq to w. Returns true once a
+// response for a later order has been seen.
+func (s *shard) drain(seq uint64, w cbuf.ResponseWriter) bool {
+	for {
+		if !s.hasNext {
+			r, err := s.out.GetForRead()
+			if err != nil {
+				return false
+			}
+			s.next = *r
+			s.hasNext = true
+		}
+		if s.next.Seq != seq {
+			return true
+		}
+		mr, err := w.GetForWrite()
+		if err != nil {
+			panic(err.Error())
+		}
+		*mr = s.next
+		s.hasNext = false
+	}
+}
+
+// Identifies the shard which processes an order, and that order's position in the shard's input
+type route struct {
+	shard int
+	n     int64
+	seq   uint64
+}
+
+// Partitions instruments across shards, each on its own goroutine. Orders are sequenced as they are
+// submitted and every response is merged, in sequence order, into a single output buffer. Submit, Pause,
+// Move and Resume must be called from a single goroutine.
+type Sharded struct {
+	shards    []*shard
+	placement map[uint32]int // The shard each instrument has been placed on
+	seq       uint64
+	paused    bool
+	routes    chan route
+	out       cbuf.ResponseWriter
+	merged    cbuf.Sequence // Sequence number of the last order merged
+	mergeWait cbuf.WaitStrategy
+	pauseWait cbuf.WaitStrategy
+	done      chan struct{}
+}
+
+// Each shard's orders are allocated from a slab of slabSize, and its inbound and response rings hold
+// ringSize entries. out must be read on another goroutine, e.g. a cbuf.SPSC or cbuf.Broadcast.
+func NewSharded(shards, slabSize, ringSize int, newWait cbuf.NewWaitStrategy, out cbuf.ResponseWriter) *Sharded {
+	e := &Sharded{placement: make(map[uint32]int), routes: make(chan route, shards*ringSize), out: out, mergeWait: newWait(), pauseWait: newWait(), done: make(chan struct{})}
+	for i := 0; i < shards; i++ {
+		s := &shard{out: cbuf.NewSPSC(ringSize, newWait), slab: trade.NewSlab(slabSize), books: make(map[uint32]*matcher.M)}
+		s.runner = newRunner(s.out, ringSize, newWait, nil)
+		s.runner.match.process = s.submit
+		s.runner.match.downstream = append(s.runner.match.downstream, e.mergeWait)
+		e.shards = append(e.shards, s)
+	}
+	return e
+}
+
+func (e *Sharded) Start() {
+	for _, s := range e.shards {
+		s.runner.Start()
+	}
+	go e.merge()
+}
+
+// The shard trading stockId, instruments are spread by stock id until they are moved
+func (e *Sharded) Shard(stockId uint32) int {
+	if idx, ok := e.placement[stockId]; ok {
+		return idx
+	}
+	return int(stockId % uint32(len(e.shards)))
+}
+
+// Stamps od with the next sequence number and passes it to the shard trading its instrument
+func (e *Sharded) Submit(od *trade.OrderData) error {
+	if e.paused {
+		return ErrPaused
+	}
+	idx := e.Shard(od.StockId)
+	s := e.shards[idx]
+	e.seq++
+	od.Seq = e.seq
+	s.runner.Submit(od)
+	s.routed++
+	e.routes <- route{shard: idx, n: s.routed, seq: e.seq}
+	return nil
+}
+
+// Stops accepting orders and waits until every order submitted has been matched and its responses merged
+func (e *Sharded) Pause() {
+	e.paused = true
+	for i := 0; uint64(e.merged.Load()) != e.seq; i++ {
+		e.pauseWait.Wait(i)
+	}
+}
+
+func (e *Sharded) Resume() {
+	e.paused = false
+}
+
+// Moves an instrument, with its resting orders, to another shard. Trading must be paused.
+func (e *Sharded) Move(stockId uint32, to int) error {
+	if !e.paused {
+		return ErrNotPaused
+	}
+	from := e.Shard(stockId)
+	e.placement[stockId] = to
+	if from == to {
+		return nil
+	}
+	src, dst := e.shards[from], e.shards[to]
+	m := src.books[stockId]
+	if m == nil {
+		return nil
+	}
+	var buf bytes.Buffer
+	if err := m.WriteSnapshot(&buf); err != nil {
+		return err
+	}
+	nm := matcher.NewMatcherFromSlab(dst.slab, dst.out)
+	if err := nm.ReadSnapshot(&buf); err != nil {
+		return err
+	}
+	m.Clear()
+	delete(src.books, stockId)
+	dst.books[stockId] = nm
+	return nil
+}
+
+// Waits for every order submitted to be matched and merged, then stops every shard. If out can be closed
+// it is.
+func (e *Sharded) Stop() {
+	for _, s := range e.shards {
+		s.runner.Stop()
+	}
+	close(e.routes)
+	<-e.done
+	e.out.Publish()
+	if c, ok := e.out.(interface{ Close() }); ok {
+		c.Close()
+	}
+}
+
+func (e *Sharded) merge() {
+	defer close(e.done)
+	for rt := range e.routes {
+		s := e.shards[rt.shard]
+		for i := 0; ; i++ {
+			// Responses are published before the order is counted as processed
+			processed := s.runner.Processed() >= rt.n
+			if s.drain(rt.seq, e.out) || processed {
+				break
+			}
+			e.mergeWait.Wait(i)
+		}
+		e.out.Publish()
+		e.merged.Store(int64(rt.seq))
+		e.pauseWait.Signal()
+	}
+}
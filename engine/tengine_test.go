@@ -0,0 +1,125 @@
+package engine
+
+import (
+	"bytes"
+	"github.com/fmstephe/matching_engine/cbuf"
+	"github.com/fmstephe/matching_engine/journal"
+	"github.com/fmstephe/matching_engine/matcher"
+	"github.com/fmstephe/matching_engine/trade"
+	"testing"
+)
+
+var tengineOrderMaker = trade.NewOrderMaker()
+
+// Runs orders through a matcher on the caller's goroutine, returning every response written
+func syncResponses(orders []trade.OrderData) ([]trade.Response, trade.Digest) {
+	rb := cbuf.New(len(orders) * 4)
+	m := matcher.NewMatcher(len(orders), rb)
+	for i := range orders {
+		od := orders[i]
+		m.Submit(&od)
+	}
+	var responses []trade.Response
+	for {
+		r, err := rb.GetForRead()
+		if err != nil {
+			return responses, m.Digest()
+		}
+		responses = append(responses, *r)
+	}
+}
+
+func runPipeline(t *testing.T, orders []trade.OrderData, ringSize int, newWait cbuf.NewWaitStrategy, jw *journal.Writer) ([]trade.Response, *matcher.M) {
+	out := cbuf.NewSPSC(16, newWait)
+	m := matcher.NewMatcher(len(orders), out)
+	var responses []trade.Response
+	r := NewRunner(m, out, ringSize, newWait, func(resp *trade.Response) {
+		responses = append(responses, *resp)
+	})
+	if jw != nil {
+		r.SetJournal(jw)
+	}
+	r.Start()
+	for i := range orders {
+		r.Submit(&orders[i])
+	}
+	r.Stop()
+	return responses, m
+}
+
+func checkResponses(t *testing.T, expected, responses []trade.Response) {
+	if len(responses) != len(expected) {
+		t.Errorf("Expecting %d responses, got %d instead", len(expected), len(responses))
+		return
+	}
+	for i := range expected {
+		if responses[i] != expected[i] {
+			t.Errorf("Expecting %v, got %v instead", expected[i], responses[i])
+		}
+	}
+}
+
+func TestPipeline(t *testing.T) {
+	orders, err := tengineOrderMaker.RndTradeSet(500, 10, 1, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, digest := syncResponses(orders)
+	strategies := map[string]cbuf.NewWaitStrategy{"yield": cbuf.YieldWait, "park": cbuf.ParkWait}
+	for name, strategy := range strategies {
+		// A small ring keeps the producer waiting on the match stage
+		responses, m := runPipeline(t, orders, 4, strategy, nil)
+		checkResponses(t, expected, responses)
+		if m.Digest() != digest {
+			t.Errorf("%s: Expecting digest %v, got %v instead", name, digest, m.Digest())
+		}
+	}
+}
+
+func TestPipelineJournal(t *testing.T) {
+	orders, err := tengineOrderMaker.RndTradeSet(500, 10, 1, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	jw, _ := journal.NewWriter(&buf, 0, 0)
+	responses, m := runPipeline(t, orders, 64, cbuf.ParkWait, jw)
+	if m.LastSeq() != uint64(len(orders)) {
+		t.Errorf("Expecting sequence %d, got %d instead", len(orders), m.LastSeq())
+	}
+	// Responses carry their journal sequence number
+	expected, _ := syncResponses(orders)
+	for i := range responses {
+		if responses[i].Seq == 0 {
+			t.Errorf("Expecting a sequenced response, got %v instead", responses[i])
+		}
+		responses[i].Seq = 0
+	}
+	checkResponses(t, expected, responses)
+	replayed := matcher.NewMatcher(len(orders), cbuf.New(len(orders)*4))
+	jr, _ := journal.NewReader(&buf)
+	if _, err := replayed.Replay(jr); err != nil {
+		t.Fatal(err)
+	}
+	if replayed.Digest() != m.Digest() {
+		t.Errorf("Expecting digest %v, got %v instead", m.Digest(), replayed.Digest())
+	}
+}
+
+func TestClaimAllocs(t *testing.T) {
+	orders, _ := tengineOrderMaker.RndTradeSet(500, 10, 1, 100)
+	out := cbuf.NewSPSC(1024, cbuf.ParkWait)
+	m := matcher.NewMatcher(len(orders), out)
+	r := NewRunner(m, out, 1024, cbuf.ParkWait, func(resp *trade.Response) {})
+	r.Start()
+	i := 0
+	// AllocsPerRun makes one extra warm up run
+	allocs := testing.AllocsPerRun(len(orders)-1, func() {
+		r.Submit(&orders[i])
+		i++
+	})
+	r.Stop()
+	if allocs != 0 {
+		t.Errorf("Expecting no allocations, got %f instead", allocs)
+	}
+}
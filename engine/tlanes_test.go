@@ -0,0 +1,155 @@
+package engine
+
+import (
+	"bytes"
+	"github.com/fmstephe/matching_engine/cbuf"
+	"github.com/fmstephe/matching_engine/journal"
+	"github.com/fmstephe/matching_engine/matcher"
+	"github.com/fmstephe/matching_engine/trade"
+	"testing"
+	"time"
+)
+
+func newLanes(n int, jw *journal.Writer, burst int) (*Lanes, *matcher.M) {
+	out := cbuf.NewSPSC(16, cbuf.ParkWait)
+	m := matcher.NewMatcher(n, out)
+	r := NewRunner(m, out, 4, cbuf.ParkWait, func(resp *trade.Response) {})
+	r.SetJournal(jw)
+	l := NewLanes(r, cbuf.ParkWait, ByCancel, Lane{Size: n, Burst: burst}, Lane{Size: n})
+	return l, m
+}
+
+// Cancels waiting alongside new orders go first, but only burst of them in a row. None of the cancels are
+// for the queued orders, so none wait on them.
+func TestLanesPriority(t *testing.T) {
+	var buf bytes.Buffer
+	jw, _ := journal.NewWriter(&buf, 0, 0)
+	l, _ := newLanes(16, jw, 3)
+	od := &trade.OrderData{}
+	for i := uint32(1); i <= 10; i++ {
+		od.WriteBuy(trade.CostData{Price: 10, Amount: 1}, trade.TradeData{TraderId: 1, TradeId: i, StockId: 1})
+		l.Submit(od)
+	}
+	for i := uint32(1); i <= 10; i++ {
+		od.WriteCancel(trade.NewBuy(trade.CostData{}, trade.TradeData{TraderId: 2, TradeId: i, StockId: 1}))
+		l.Submit(od)
+	}
+	l.Start()
+	l.Stop()
+	if seen := journalKinds(&buf); seen != "CCCOCCCOCCCOCOOOOOOO" {
+		t.Errorf("Expecting %s, got %s instead", "CCCOCCCOCCCOCOOOOOOO", seen)
+	}
+}
+
+// A cancel never overtakes the order it cancels, so a cancelled order never rests
+func TestLanesCancelOrder(t *testing.T) {
+	var buf bytes.Buffer
+	jw, _ := journal.NewWriter(&buf, 0, 0)
+	l, m := newLanes(16, jw, 3)
+	od := &trade.OrderData{}
+	for i := uint32(1); i <= 5; i++ {
+		od.WriteBuy(trade.CostData{Price: 10, Amount: 1}, trade.TradeData{TraderId: 1, TradeId: i, StockId: 1})
+		l.Submit(od)
+		od.WriteCancel(trade.NewBuy(trade.CostData{}, trade.TradeData{TraderId: 1, TradeId: i, StockId: 1}))
+		l.Submit(od)
+	}
+	l.Start()
+	// Orders and their cancels submitted while the lanes are running
+	for i := uint32(6); i <= 1000; i++ {
+		od.WriteBuy(trade.CostData{Price: 10, Amount: 1}, trade.TradeData{TraderId: 1, TradeId: i, StockId: 1})
+		l.Submit(od)
+		od.WriteCancel(trade.NewBuy(trade.CostData{}, trade.TradeData{TraderId: 1, TradeId: i, StockId: 1}))
+		l.Submit(od)
+	}
+	l.Stop()
+	if seen := journalKinds(&buf); seen[:10] != "OCOCOCOCOC" {
+		t.Errorf("Expecting %s, got %s instead", "OCOCOCOCOC", seen[:10])
+	}
+	if m.Size() != 0 {
+		t.Errorf("Expecting %d resting orders, got %d instead", 0, m.Size())
+	}
+}
+
+// A cancel waits for an order published behind a slot another producer has claimed but not yet published
+func TestLanesStalledProducer(t *testing.T) {
+	var buf bytes.Buffer
+	jw, _ := journal.NewWriter(&buf, 0, 0)
+	l, m := newLanes(16, jw, 3)
+	orders := l.lanes[ORDER_LANE].ring
+	stalled := orders.Claim(1)
+	od := &trade.OrderData{}
+	od.WriteBuy(trade.CostData{Price: 10, Amount: 1}, trade.TradeData{TraderId: 1, TradeId: 1, StockId: 1})
+	l.Submit(od)
+	od.WriteCancel(trade.NewBuy(trade.CostData{}, trade.TradeData{TraderId: 1, TradeId: 1, StockId: 1}))
+	l.Submit(od)
+	l.Start()
+	// Gives the scheduler time to take the cancel before the order it cancels
+	time.Sleep(10 * time.Millisecond)
+	orders.Slot(stalled).WriteBuy(trade.CostData{Price: 5, Amount: 1}, trade.TradeData{TraderId: 2, TradeId: 1, StockId: 1})
+	orders.Publish(stalled, 1)
+	l.Stop()
+	if seen := journalKinds(&buf); seen != "OOC" {
+		t.Errorf("Expecting %s, got %s instead", "OOC", seen)
+	}
+	if m.Size() != 1 {
+		t.Errorf("Expecting %d resting orders, got %d instead", 1, m.Size())
+	}
+}
+
+// The kind of each journalled order, C for a cancel and O otherwise
+func journalKinds(buf *bytes.Buffer) string {
+	od := &trade.OrderData{}
+	seen := ""
+	jr, _ := journal.NewReader(buf)
+	for {
+		if _, err := jr.Next(od); err != nil {
+			break
+		}
+		if od.Kind == trade.CANCEL {
+			seen += "C"
+		} else {
+			seen += "O"
+		}
+	}
+	return seen
+}
+
+// Replaying the journal reproduces the book the matcher built, whatever order the lanes chose
+func TestLanesReplay(t *testing.T) {
+	orders, err := tengineOrderMaker.RndTradeSet(500, 10, 1, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	jw, _ := journal.NewWriter(&buf, 0, 0)
+	l, m := newLanes(len(orders), jw, 2)
+	l.Start()
+	done := make(chan struct{})
+	// Cancels and new orders arrive from different gateways
+	go func() {
+		for i := range orders {
+			if orders[i].Kind == trade.CANCEL {
+				l.Submit(&orders[i])
+			}
+		}
+		close(done)
+	}()
+	for i := range orders {
+		if orders[i].Kind != trade.CANCEL {
+			l.Submit(&orders[i])
+		}
+	}
+	<-done
+	l.Stop()
+	if m.LastSeq() != uint64(len(orders)) {
+		t.Errorf("Expecting sequence %d, got %d instead", len(orders), m.LastSeq())
+	}
+	replayed := matcher.NewMatcher(len(orders), cbuf.New(len(orders)*4))
+	jr, _ := journal.NewReader(&buf)
+	if _, err := replayed.Replay(jr); err != nil {
+		t.Fatal(err)
+	}
+	if replayed.Digest() != m.Digest() {
+		t.Errorf("Expecting digest %v, got %v instead", m.Digest(), replayed.Digest())
+	}
+}
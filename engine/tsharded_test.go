@@ -0,0 +1,113 @@
+package engine
+
+import (
+	"github.com/fmstephe/matching_engine/cbuf"
+	"github.com/fmstephe/matching_engine/matcher"
+	"github.com/fmstephe/matching_engine/trade"
+	"testing"
+)
+
+var tshardedOrderMaker = trade.NewOrderMaker()
+
+// Interleaves a random trade set for each stock
+func mkStockOrders(t *testing.T, stocks int) []trade.OrderData {
+	var sets [][]trade.OrderData
+	for stock := 1; stock <= stocks; stock++ {
+		set, err := tshardedOrderMaker.RndTradeSet(100, 10, 1, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range set {
+			set[i].StockId = uint32(stock)
+		}
+		sets = append(sets, set)
+	}
+	var orders []trade.OrderData
+	for i := 0; len(orders) < len(sets)*len(sets[0]); i++ {
+		for _, set := range sets {
+			if i < len(set) {
+				orders = append(orders, set[i])
+			}
+		}
+	}
+	return orders
+}
+
+// The responses of a matcher per instrument, in the order the orders were submitted
+func referenceResponses(orders []trade.OrderData) []trade.Response {
+	rb := cbuf.New(len(orders) * 4)
+	refs := make(map[uint32]*matcher.M)
+	for i := range orders {
+		od := orders[i]
+		od.Seq = uint64(i + 1)
+		m := refs[od.StockId]
+		if m == nil {
+			m = matcher.NewMatcher(len(orders), rb)
+			refs[od.StockId] = m
+		}
+		m.Submit(&od)
+	}
+	var responses []trade.Response
+	for {
+		r, err := rb.GetForRead()
+		if err != nil {
+			return responses
+		}
+		responses = append(responses, *r)
+	}
+}
+
+func collect(out *cbuf.SPSC) chan []trade.Response {
+	c := make(chan []trade.Response)
+	go func() {
+		var responses []trade.Response
+		for {
+			r, err := out.WaitForRead()
+			if err != nil {
+				c <- responses
+				return
+			}
+			responses = append(responses, *r)
+		}
+	}()
+	return c
+}
+
+func TestSharded(t *testing.T) {
+	orders := mkStockOrders(t, 6)
+	expected := referenceResponses(orders)
+	out := cbuf.NewSPSC(64, cbuf.ParkWait)
+	collected := collect(out)
+	e := NewSharded(3, len(orders), 16, cbuf.ParkWait, out)
+	e.Start()
+	half := len(orders) / 2
+	for i := 0; i < half; i++ {
+		if err := e.Submit(&orders[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := e.Move(1, 2); err != ErrNotPaused {
+		t.Errorf("Expecting %v, got %v instead", ErrNotPaused, err)
+	}
+	e.Pause()
+	if err := e.Submit(&orders[half]); err != ErrPaused {
+		t.Errorf("Expecting %v, got %v instead", ErrPaused, err)
+	}
+	// Crowd every instrument onto one shard, moving each with its resting orders
+	for stock := uint32(1); stock <= 6; stock++ {
+		if err := e.Move(stock, 0); err != nil {
+			t.Fatal(err)
+		}
+		if e.Shard(stock) != 0 {
+			t.Errorf("Expecting stock %d on shard %d, got %d instead", stock, 0, e.Shard(stock))
+		}
+	}
+	e.Resume()
+	for i := half; i < len(orders); i++ {
+		if err := e.Submit(&orders[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	e.Stop()
+	checkResponses(t, expected, <-collected)
+}
@@ -0,0 +1,77 @@
+package fix
+
+import (
+	"github.com/fmstephe/matching_engine/gateway"
+	"github.com/fmstephe/matching_engine/trade"
+	"net"
+	"time"
+)
+
+type Config struct {
+	CompId  string            // The gateway's SenderCompID
+	Traders map[string]uint32 // The TraderId of each counterparty, by its SenderCompID
+	Symbols map[string]uint32 // The StockId of each Symbol
+}
+
+// A FIX 4.4 order entry acceptor. Each counterparty is a trader, its session, sequence numbers and orders
+// survive reconnection and messages sent while it is disconnected are recovered with a ResendRequest, see
+// RESEND_HISTORY. Orders are passed to submit, which must be safe to call from several goroutines, and the
+// engine's responses must be passed to Handle. Prices are whole ticks.
+type Gateway struct {
+	cfg     Config
+	hub     *gateway.Hub
+	traders map[string]*session
+	unit    time.Duration // HeartBtInt is counted in units, a second outside of tests
+}
+
+func NewGateway(cfg Config, submit func(od *trade.OrderData)) *Gateway {
+	return newGateway(cfg, submit, time.Second)
+}
+
+func newGateway(cfg Config, submit func(od *trade.OrderData), unit time.Duration) *Gateway {
+	g := &Gateway{cfg: cfg, hub: gateway.NewHub(submit), traders: make(map[string]*session), unit: unit}
+	for compId, traderId := range cfg.Traders {
+		s := newSession(g, compId, traderId, g.hub.Add(traderId))
+		g.traders[compId] = s
+		g.hub.Go(s.run)
+	}
+	return g
+}
+
+// Accepts connections until ln is closed
+func (g *Gateway) Serve(ln net.Listener) error {
+	return gateway.Serve(ln, g.accept)
+}
+
+// Passes a response to the session of the trader it is for, see gateway.Hub. May be called from any
+// goroutine.
+func (g *Gateway) Handle(r *trade.Response) {
+	g.hub.Handle(r)
+}
+
+// Logs out every session and closes its connection
+func (g *Gateway) Close() {
+	g.hub.Close()
+}
+
+// The first message on a connection must be a Logon from a known counterparty
+func (g *Gateway) accept(conn net.Conn) {
+	r := NewReader(conn)
+	m := &Message{}
+	conn.SetReadDeadline(time.Now().Add(10 * g.unit))
+	if err := r.Read(m); err != nil || m.Type() != LOGON {
+		conn.Close()
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+	s, ok := g.traders[m.Get(SENDER_COMP_ID)]
+	if !ok || m.Get(TARGET_COMP_ID) != g.cfg.CompId {
+		conn.Close()
+		return
+	}
+	select {
+	case s.logons <- inbound{conn: conn, r: r, m: m}:
+	case <-g.hub.Done():
+		conn.Close()
+	}
+}
@@ -0,0 +1,91 @@
+package fix
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+)
+
+// A minimal FIX initiator for driving a gateway in tests
+type initiator struct {
+	t      *testing.T
+	addr   string
+	compId string
+	conn   net.Conn
+	r      *Reader
+	w      *bufio.Writer
+	seq    uint64 // The next sequence number to send
+}
+
+func dial(t *testing.T, addr, compId string) *initiator {
+	i := &initiator{t: t, addr: addr, compId: compId, seq: 1}
+	i.redial()
+	return i
+}
+
+// Opens a new connection, the initiator's sequence numbers carry on
+func (i *initiator) redial() {
+	conn, err := net.Dial("tcp", i.addr)
+	if err != nil {
+		i.t.Fatal(err)
+	}
+	i.conn = conn
+	i.r = NewReader(conn)
+	i.w = bufio.NewWriter(conn)
+}
+
+func (i *initiator) logon(heartbeat int64, reset bool) {
+	m := NewMessage(LOGON).Add(ENCRYPT_METHOD, "0").AddInt(HEART_BT_INT, heartbeat)
+	if reset {
+		m.Add(RESET_SEQ_NUM_FLAG, "Y")
+	}
+	i.send(m)
+	i.expect(LOGON)
+}
+
+func (i *initiator) send(m *Message) {
+	i.sendSeq(m, i.seq)
+}
+
+// Sends m with sequence number seq, the next message sent will follow it
+func (i *initiator) sendSeq(m *Message, seq uint64) {
+	i.seq = seq + 1
+	h := NewMessage(m.Type()).Add(SENDER_COMP_ID, i.compId).Add(TARGET_COMP_ID, "EXCH").AddInt(MSG_SEQ_NUM, int64(seq))
+	h.Add(SENDING_TIME, time.Now().UTC().Format(sendingTimeFormat))
+	h.Fields = append(h.Fields, m.Fields[1:]...)
+	if _, err := i.w.Write(h.Encode(nil)); err != nil {
+		i.t.Fatal(err)
+	}
+	if err := i.w.Flush(); err != nil {
+		i.t.Fatal(err)
+	}
+}
+
+// Reads the next message, which must have msgType. Heartbeats are skipped unless one is expected.
+func (i *initiator) expect(msgType string) *Message {
+	for {
+		m, err := i.read()
+		if err != nil {
+			i.t.Fatalf("Expecting %s, got %v instead", msgType, err)
+		}
+		if m.Type() == HEARTBEAT && msgType != HEARTBEAT {
+			continue
+		}
+		if m.Type() != msgType {
+			i.t.Fatalf("Expecting %s, got %v instead", msgType, m.Fields)
+		}
+		return m
+	}
+}
+
+func (i *initiator) read() (*Message, error) {
+	i.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	m := &Message{}
+	return m, i.r.Read(m)
+}
+
+func newOrderSingle(clOrdId, side string, qty, price int64) *Message {
+	m := NewMessage(NEW_ORDER_SINGLE).Add(CL_ORD_ID, clOrdId).Add(SYMBOL, "ACME").Add(SIDE, side)
+	return m.Add(ORD_TYPE, TYPE_LIMIT).AddInt(ORDER_QTY, qty).AddInt(PRICE, price)
+}
@@ -0,0 +1,261 @@
+package fix
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+)
+
+const (
+	BEGIN_STRING = "FIX.4.4"
+	SOH          = '\x01'
+)
+
+// Tags
+const (
+	AVG_PX                 = 6
+	BEGIN_SEQ_NO           = 7
+	BODY_LENGTH            = 9
+	CHECK_SUM              = 10
+	CL_ORD_ID              = 11
+	CUM_QTY                = 14
+	END_SEQ_NO             = 16
+	EXEC_ID                = 17
+	LAST_PX                = 31
+	LAST_QTY               = 32
+	MSG_SEQ_NUM            = 34
+	MSG_TYPE               = 35
+	NEW_SEQ_NO             = 36
+	ORDER_ID               = 37
+	ORDER_QTY              = 38
+	ORD_STATUS             = 39
+	ORD_TYPE               = 40
+	ORIG_CL_ORD_ID         = 41
+	POSS_DUP_FLAG          = 43
+	PRICE                  = 44
+	REF_SEQ_NUM            = 45
+	SENDER_COMP_ID         = 49
+	SENDING_TIME           = 52
+	SIDE                   = 54
+	SYMBOL                 = 55
+	TARGET_COMP_ID         = 56
+	TEXT                   = 58
+	ENCRYPT_METHOD         = 98
+	CXL_REJ_REASON         = 102
+	ORD_REJ_REASON         = 103
+	HEART_BT_INT           = 108
+	TEST_REQ_ID            = 112
+	ORIG_SENDING_TIME      = 122
+	GAP_FILL_FLAG          = 123
+	RESET_SEQ_NUM_FLAG     = 141
+	EXEC_TYPE              = 150
+	LEAVES_QTY             = 151
+	REF_MSG_TYPE           = 372
+	BUSINESS_REJECT_REASON = 380
+	CXL_REJ_RESPONSE_TO    = 434
+)
+
+// Message types
+const (
+	HEARTBEAT                    = "0"
+	TEST_REQUEST                 = "1"
+	RESEND_REQUEST               = "2"
+	REJECT                       = "3"
+	SEQUENCE_RESET               = "4"
+	LOGOUT                       = "5"
+	EXECUTION_REPORT             = "8"
+	ORDER_CANCEL_REJECT          = "9"
+	LOGON                        = "A"
+	NEW_ORDER_SINGLE             = "D"
+	ORDER_CANCEL_REQUEST         = "F"
+	ORDER_CANCEL_REPLACE_REQUEST = "G"
+	BUSINESS_MESSAGE_REJECT      = "j"
+)
+
+// A message which can't be framed, the stream can't be read any further
+var ErrFraming = errors.New("FIX message can't be framed")
+
+// A message which was framed but is damaged, it should be ignored
+var ErrGarbled = errors.New("FIX message is garbled")
+
+type Field struct {
+	Tag   int
+	Value string
+}
+
+// Every field of a message, in order, except BeginString, BodyLength and CheckSum which are added when it
+// is encoded
+type Message struct {
+	Fields []Field
+}
+
+func NewMessage(msgType string) *Message {
+	return &Message{Fields: []Field{{MSG_TYPE, msgType}}}
+}
+
+func (m *Message) Type() string {
+	return m.Get(MSG_TYPE)
+}
+
+// The value of the first field with tag, or "" if there is none
+func (m *Message) Get(tag int) string {
+	v, _ := m.Lookup(tag)
+	return v
+}
+
+func (m *Message) Lookup(tag int) (string, bool) {
+	for _, f := range m.Fields {
+		if f.Tag == tag {
+			return f.Value, true
+		}
+	}
+	return "", false
+}
+
+// Parses the value of tag as an unsigned integer, returning false if it is missing or malformed
+func (m *Message) Uint(tag int) (uint64, bool) {
+	v, ok := m.Lookup(tag)
+	if !ok {
+		return 0, false
+	}
+	u, err := strconv.ParseUint(v, 10, 64)
+	return u, err == nil
+}
+
+func (m *Message) Add(tag int, value string) *Message {
+	m.Fields = append(m.Fields, Field{tag, value})
+	return m
+}
+
+// Replaces the value of the first field with tag, or adds one if there is none
+func (m *Message) Set(tag int, value string) *Message {
+	for i := range m.Fields {
+		if m.Fields[i].Tag == tag {
+			m.Fields[i].Value = value
+			return m
+		}
+	}
+	return m.Add(tag, value)
+}
+
+func (m *Message) AddInt(tag int, value int64) *Message {
+	return m.Add(tag, strconv.FormatInt(value, 10))
+}
+
+// Appends the encoded message to b
+func (m *Message) Encode(b []byte) []byte {
+	var body []byte
+	for _, f := range m.Fields {
+		body = strconv.AppendInt(body, int64(f.Tag), 10)
+		body = append(body, '=')
+		body = append(body, f.Value...)
+		body = append(body, SOH)
+	}
+	start := len(b)
+	b = append(b, "8="+BEGIN_STRING+"\x019="...)
+	b = strconv.AppendInt(b, int64(len(body)), 10)
+	b = append(b, SOH)
+	b = append(b, body...)
+	sum := checksum(b[start:])
+	return append(b, '1', '0', '=', '0'+sum/100, '0'+sum/10%10, '0'+sum%10, SOH)
+}
+
+func checksum(b []byte) byte {
+	var sum byte
+	for _, c := range b {
+		sum += c
+	}
+	return sum
+}
+
+// Reads messages from a stream
+type Reader struct {
+	r   *bufio.Reader
+	buf []byte
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReader(r)}
+}
+
+// Reads the next message into m. Returns ErrGarbled for a message which should be ignored, and ErrFraming,
+// or the error of the underlying reader, if no more messages can be read.
+func (fr *Reader) Read(m *Message) error {
+	begin, err := fr.field(8)
+	if err != nil {
+		return err
+	}
+	if string(begin) != BEGIN_STRING {
+		return ErrFraming
+	}
+	fr.buf = append(fr.buf[:0], "8="+BEGIN_STRING+"\x019="...)
+	length, err := fr.field(BODY_LENGTH)
+	if err != nil {
+		return err
+	}
+	n, err := strconv.Atoi(string(length))
+	if err != nil || n <= 0 {
+		return ErrFraming
+	}
+	fr.buf = append(fr.buf, length...)
+	fr.buf = append(fr.buf, SOH)
+	headerLen := len(fr.buf)
+	if cap(fr.buf) < headerLen+n {
+		fr.buf = append(fr.buf, make([]byte, n)...)
+	}
+	fr.buf = fr.buf[:headerLen+n]
+	if _, err := io.ReadFull(fr.r, fr.buf[headerLen:]); err != nil {
+		return err
+	}
+	sum := checksum(fr.buf)
+	trailer, err := fr.field(CHECK_SUM)
+	if err != nil {
+		return err
+	}
+	if expected, err := strconv.Atoi(string(trailer)); err != nil || len(trailer) != 3 || byte(expected) != sum {
+		return ErrGarbled
+	}
+	return parse(m, fr.buf[headerLen:])
+}
+
+// Reads a single field, which must have tag, returning its value
+func (fr *Reader) field(tag int) ([]byte, error) {
+	f, err := fr.r.ReadSlice(SOH)
+	if err != nil {
+		if err == bufio.ErrBufferFull {
+			return nil, ErrFraming
+		}
+		return nil, err
+	}
+	eq := bytes.IndexByte(f, '=')
+	if eq < 0 || string(f[:eq]) != strconv.Itoa(tag) {
+		return nil, ErrFraming
+	}
+	return f[eq+1 : len(f)-1], nil
+}
+
+func parse(m *Message, body []byte) error {
+	m.Fields = m.Fields[:0]
+	for len(body) > 0 {
+		end := bytes.IndexByte(body, SOH)
+		if end < 0 {
+			return ErrGarbled
+		}
+		eq := bytes.IndexByte(body[:end], '=')
+		if eq <= 0 {
+			return ErrGarbled
+		}
+		tag, err := strconv.Atoi(string(body[:eq]))
+		if err != nil {
+			return ErrGarbled
+		}
+		m.Fields = append(m.Fields, Field{tag, string(body[eq+1 : end])})
+		body = body[end+1:]
+	}
+	if len(m.Fields) == 0 || m.Fields[0].Tag != MSG_TYPE {
+		return ErrGarbled
+	}
+	return nil
+}
@@ -0,0 +1,335 @@
+package fix
+
+import (
+	"github.com/fmstephe/matching_engine/trade"
+	"strconv"
+)
+
+// ExecType and OrdStatus values
+const (
+	STATUS_NEW              = "0"
+	STATUS_PARTIALLY_FILLED = "1"
+	STATUS_FILLED           = "2"
+	STATUS_CANCELED         = "4"
+	STATUS_REPLACED         = "5"
+	STATUS_REJECTED         = "8"
+	EXEC_TRADE              = "F"
+)
+
+// Side and OrdType values
+const (
+	SIDE_BUY   = "1"
+	SIDE_SELL  = "2"
+	TYPE_LIMIT = "2"
+)
+
+// Why an order was rejected, an OrdRejReason
+const (
+	REJECT_UNKNOWN_SYMBOL  = "1"
+	REJECT_DUPLICATE_ORDER = "6"
+	REJECT_OTHER           = "99"
+)
+
+// Why a cancel was rejected, a CxlRejReason
+const (
+	CXL_REJECT_TOO_LATE = "0"
+	CXL_REJECT_UNKNOWN  = "1"
+	CXL_REJECT_PENDING  = "3"
+	CXL_REJECT_OTHER    = "99"
+)
+
+// The text of a request rejected because the session has as many orders waiting for the engine as it can
+// queue, the counterparty is then logged out
+const OVERRUN_TEXT = "Too many orders waiting for the engine"
+
+// The number of completed orders each session remembers, so a cancel of one is rejected as too late and its
+// ClOrdID can't be reused. Older completed orders are forgotten.
+const COMPLETED_ORDERS = 1024
+
+type order struct {
+	clOrdId string
+	tradeId uint32
+	symbol  string
+	stockId uint32
+	side    string
+	qty     uint32
+	price   int64
+	cum     uint32
+	value   int64  // The sum of price times quantity of every fill
+	done    bool   // Filled or cancelled
+	pending *order // The cancel, or the replacement, waiting for the engine to cancel this order
+}
+
+func (o *order) status() string {
+	switch {
+	case o.done && o.cum == o.qty:
+		return STATUS_FILLED
+	case o.done:
+		return STATUS_CANCELED
+	case o.cum > 0:
+		return STATUS_PARTIALLY_FILLED
+	}
+	return STATUS_NEW
+}
+
+// Maps a trader's orders between FIX and the engine. A replace is a cancel followed, once the engine has
+// cancelled the original, by a new order for the quantity still to be filled.
+type orders struct {
+	s         *session
+	byTradeId map[uint32]*order
+	byClOrdId map[string]*order
+	lastTrade uint32
+	execs     uint64
+	od        trade.OrderData
+	completed [COMPLETED_ORDERS]*order // The last completed orders, which are only kept in byClOrdId
+	next      int                      // The next slot of completed to fill
+}
+
+func (os *orders) init(s *session) {
+	os.s = s
+	os.byTradeId = make(map[uint32]*order)
+	os.byClOrdId = make(map[string]*order)
+}
+
+func (os *orders) newOrder(m *Message) {
+	o, reason, text := os.parse(m)
+	if o == nil {
+		os.reject(m, reason, text)
+		return
+	}
+	if !os.add(o) {
+		os.reject(m, REJECT_OTHER, OVERRUN_TEXT)
+		os.s.overrun()
+		return
+	}
+	os.s.send(os.report(o, STATUS_NEW))
+}
+
+// Reads a new order, or a replacement, from m. Returns the reason and text for rejecting it if it is
+// invalid.
+func (os *orders) parse(m *Message) (o *order, reason, text string) {
+	o = &order{clOrdId: m.Get(CL_ORD_ID), symbol: m.Get(SYMBOL), side: m.Get(SIDE)}
+	if o.clOrdId == "" {
+		return nil, REJECT_OTHER, "Missing ClOrdID"
+	}
+	if _, ok := os.byClOrdId[o.clOrdId]; ok {
+		return nil, REJECT_DUPLICATE_ORDER, "Duplicate ClOrdID"
+	}
+	stockId, ok := os.s.g.cfg.Symbols[o.symbol]
+	if !ok {
+		return nil, REJECT_UNKNOWN_SYMBOL, "Unknown Symbol"
+	}
+	o.stockId = stockId
+	if o.side != SIDE_BUY && o.side != SIDE_SELL {
+		return nil, REJECT_OTHER, "Side must be buy or sell"
+	}
+	if m.Get(ORD_TYPE) != TYPE_LIMIT {
+		return nil, REJECT_OTHER, "OrdType must be limit"
+	}
+	qty, err := strconv.ParseUint(m.Get(ORDER_QTY), 10, 32)
+	if err != nil || qty == 0 {
+		return nil, REJECT_OTHER, "OrderQty must be a positive whole number"
+	}
+	o.qty = uint32(qty)
+	price, err := strconv.ParseInt(m.Get(PRICE), 10, 64)
+	if err != nil || price <= 0 {
+		return nil, REJECT_OTHER, "Price must be a positive whole number of ticks"
+	}
+	o.price = price
+	return o, "", ""
+}
+
+// Gives o the next trade id and submits whatever it has left to fill. Returns false, and o is forgotten, if
+// it can't be queued for the engine.
+func (os *orders) add(o *order) bool {
+	o.tradeId = os.lastTrade + 1
+	kind := trade.BUY
+	if o.side == SIDE_SELL {
+		kind = trade.SELL
+	}
+	os.od.Write(trade.CostData{Price: o.price, Amount: o.qty - o.cum}, os.tradeData(o), kind)
+	if !os.s.q.Submit(&os.od) {
+		return false
+	}
+	os.lastTrade++
+	os.byTradeId[o.tradeId] = o
+	os.byClOrdId[o.clOrdId] = o
+	return true
+}
+
+func (os *orders) cancel(m *Message) {
+	o, reason := os.cancellable(m)
+	if reason != "" {
+		os.cancelReject(m, o, reason, "1", "")
+		return
+	}
+	if !os.submitCancel(o) {
+		os.cancelReject(m, o, CXL_REJECT_OTHER, "1", OVERRUN_TEXT)
+		os.s.overrun()
+		return
+	}
+	o.pending = &order{clOrdId: m.Get(CL_ORD_ID)}
+}
+
+func (os *orders) replace(m *Message) {
+	o, reason := os.cancellable(m)
+	if reason != "" {
+		os.cancelReject(m, o, reason, "2", "")
+		return
+	}
+	r, _, text := os.parse(m)
+	if r == nil || r.symbol != o.symbol || r.side != o.side {
+		if r != nil {
+			text = "Symbol and Side can't be replaced"
+		}
+		os.cancelReject(m, o, CXL_REJECT_TOO_LATE, "2", text)
+		return
+	}
+	if r.qty <= o.cum {
+		os.cancelReject(m, o, CXL_REJECT_TOO_LATE, "2", "OrderQty is already filled")
+		return
+	}
+	if !os.submitCancel(o) {
+		os.cancelReject(m, o, CXL_REJECT_OTHER, "2", OVERRUN_TEXT)
+		os.s.overrun()
+		return
+	}
+	o.pending = r
+}
+
+// Finds the order a cancel or replace refers to, and the reason it can't be cancelled if it can't
+func (os *orders) cancellable(m *Message) (*order, string) {
+	o, ok := os.byClOrdId[m.Get(ORIG_CL_ORD_ID)]
+	switch {
+	case !ok:
+		return nil, CXL_REJECT_UNKNOWN
+	case o.done:
+		return o, CXL_REJECT_TOO_LATE
+	case o.pending != nil:
+		return o, CXL_REJECT_PENDING
+	}
+	return o, ""
+}
+
+// Returns false if the cancel can't be queued for the engine
+func (os *orders) submitCancel(o *order) bool {
+	os.od.Write(trade.CostData{}, os.tradeData(o), trade.CANCEL)
+	return os.s.q.Submit(&os.od)
+}
+
+func (os *orders) tradeData(o *order) trade.TradeData {
+	return trade.TradeData{TraderId: os.s.traderId, TradeId: o.tradeId, StockId: o.stockId}
+}
+
+// Turns a response from the engine into an ExecutionReport or an OrderCancelReject
+func (os *orders) respond(r *trade.Response) {
+	o, ok := os.byTradeId[r.TradeId]
+	if !ok {
+		return
+	}
+	defer os.complete(o)
+	switch r.Kind {
+	case trade.PARTIAL, trade.FULL:
+		price := r.Price
+		if price < 0 {
+			price = -price
+		}
+		o.cum += r.Amount
+		o.value += price * int64(r.Amount)
+		o.done = r.Kind == trade.FULL
+		os.s.send(os.report(o, EXEC_TRADE).AddInt(LAST_QTY, int64(r.Amount)).AddInt(LAST_PX, price))
+	case trade.CANCELLED:
+		o.done = true
+		p := o.pending
+		o.pending = nil
+		if p == nil {
+			return
+		}
+		// A replacement which has been filled while it was pending leaves nothing to submit
+		if p.qty <= o.cum {
+			os.s.send(os.report(o, STATUS_CANCELED).Add(ORIG_CL_ORD_ID, o.clOrdId).Set(CL_ORD_ID, p.clOrdId))
+			return
+		}
+		p.cum = o.cum
+		p.value = o.value
+		if !os.add(p) {
+			os.s.send(os.report(o, STATUS_CANCELED).Add(ORIG_CL_ORD_ID, o.clOrdId).Set(CL_ORD_ID, p.clOrdId).Add(TEXT, OVERRUN_TEXT))
+			os.s.overrun()
+			return
+		}
+		os.s.send(os.report(p, STATUS_REPLACED).Add(ORIG_CL_ORD_ID, o.clOrdId))
+	case trade.NOT_CANCELLED:
+		p := o.pending
+		o.pending = nil
+		if p == nil {
+			return
+		}
+		responseTo := "1"
+		if p.qty != 0 {
+			responseTo = "2"
+		}
+		os.s.send(os.cancelRejectFor(o, p.clOrdId, o.clOrdId, CXL_REJECT_TOO_LATE, responseTo))
+	}
+}
+
+// Once o is done, and has no cancel or replacement waiting for the engine, the engine won't mention it
+// again. It is dropped from byTradeId and kept in byClOrdId until COMPLETED_ORDERS more orders complete.
+func (os *orders) complete(o *order) {
+	if !o.done || o.pending != nil {
+		return
+	}
+	delete(os.byTradeId, o.tradeId)
+	if old := os.completed[os.next]; old != nil && os.byClOrdId[old.clOrdId] == old {
+		delete(os.byClOrdId, old.clOrdId)
+	}
+	os.completed[os.next] = o
+	os.next = (os.next + 1) % COMPLETED_ORDERS
+}
+
+func (os *orders) report(o *order, execType string) *Message {
+	os.execs++
+	avgPx := "0"
+	if o.cum > 0 {
+		avgPx = strconv.FormatFloat(float64(o.value)/float64(o.cum), 'f', -1, 64)
+	}
+	m := NewMessage(EXECUTION_REPORT)
+	m.Add(ORDER_ID, strconv.FormatUint(uint64(o.tradeId), 10)).Add(CL_ORD_ID, o.clOrdId)
+	m.Add(EXEC_ID, strconv.FormatUint(os.execs, 10)).Add(EXEC_TYPE, execType).Add(ORD_STATUS, o.status())
+	m.Add(SYMBOL, o.symbol).Add(SIDE, o.side).AddInt(ORDER_QTY, int64(o.qty)).AddInt(PRICE, o.price)
+	leaves := o.qty - o.cum
+	if o.done {
+		leaves = 0
+	}
+	m.AddInt(CUM_QTY, int64(o.cum)).AddInt(LEAVES_QTY, int64(leaves)).Add(AVG_PX, avgPx)
+	return m
+}
+
+func (os *orders) reject(m *Message, reason, text string) {
+	os.execs++
+	r := NewMessage(EXECUTION_REPORT)
+	r.Add(ORDER_ID, "NONE").Add(CL_ORD_ID, m.Get(CL_ORD_ID)).Add(EXEC_ID, strconv.FormatUint(os.execs, 10))
+	r.Add(EXEC_TYPE, STATUS_REJECTED).Add(ORD_STATUS, STATUS_REJECTED).Add(ORD_REJ_REASON, reason).Add(TEXT, text)
+	r.Add(SYMBOL, m.Get(SYMBOL)).Add(SIDE, m.Get(SIDE)).Add(ORDER_QTY, m.Get(ORDER_QTY))
+	r.Add(CUM_QTY, "0").Add(LEAVES_QTY, "0").Add(AVG_PX, "0")
+	os.s.send(r)
+}
+
+// Rejects the cancel or replace request m, o is the order it refers to if there is one
+func (os *orders) cancelReject(m *Message, o *order, reason, responseTo, text string) {
+	r := os.cancelRejectFor(o, m.Get(CL_ORD_ID), m.Get(ORIG_CL_ORD_ID), reason, responseTo)
+	if text != "" {
+		r.Add(TEXT, text)
+	}
+	os.s.send(r)
+}
+
+func (os *orders) cancelRejectFor(o *order, clOrdId, origClOrdId, reason, responseTo string) *Message {
+	orderId, status := "NONE", STATUS_REJECTED
+	if o != nil {
+		orderId, status = strconv.FormatUint(uint64(o.tradeId), 10), o.status()
+	}
+	r := NewMessage(ORDER_CANCEL_REJECT)
+	r.Add(ORDER_ID, orderId).Add(CL_ORD_ID, clOrdId).Add(ORIG_CL_ORD_ID, origClOrdId).Add(ORD_STATUS, status)
+	r.Add(CXL_REJ_RESPONSE_TO, responseTo).Add(CXL_REJ_REASON, reason)
+	return r
+}
@@ -0,0 +1,343 @@
+package fix
+
+import (
+	"bufio"
+	"github.com/fmstephe/matching_engine/gateway"
+	"net"
+	"strconv"
+	"time"
+)
+
+const sendingTimeFormat = "20060102-15:04:05.000"
+
+// The number of messages each session can always resend, a ResendRequest for older messages is answered
+// with a gap fill
+const RESEND_HISTORY = 1024
+
+// A message read from a connection, or the error which ended it
+type inbound struct {
+	conn net.Conn
+	r    *Reader
+	m    *Message
+	err  error
+}
+
+// The session layer for one counterparty. Its goroutine owns the session's state, its connection and its
+// orders.
+type session struct {
+	g        *Gateway
+	compId   string
+	traderId uint32
+	logons   chan inbound
+	inbound  chan inbound
+	q        *gateway.Queues
+	// Session state, kept across connections
+	inSeq    uint64     // The next sequence number expected
+	outSeq   uint64     // The next sequence number to send
+	sent     []*Message // The messages sent from sentFrom on, nil for admin messages which are never resent
+	sentFrom uint64     // The sequence number of sent[0]
+	// Connection state
+	conn       net.Conn
+	w          *bufio.Writer
+	heartbeat  time.Duration
+	lastSent   time.Time
+	lastRecv   time.Time
+	testSent   time.Time // When an unanswered TestRequest was sent
+	resending  bool      // A ResendRequest is outstanding
+	loggingOut bool
+	testReqs   int
+	buf        []byte
+	orders     orders
+}
+
+func newSession(g *Gateway, compId string, traderId uint32, q *gateway.Queues) *session {
+	s := &session{g: g, compId: compId, traderId: traderId, logons: make(chan inbound), inbound: make(chan inbound), q: q, inSeq: 1, outSeq: 1, sentFrom: 1}
+	s.orders.init(s)
+	return s
+}
+
+func (s *session) run() {
+	ticker := time.NewTicker(s.g.unit / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case in := <-s.logons:
+			s.logon(in)
+		case in := <-s.inbound:
+			if in.conn != s.conn {
+				continue
+			}
+			if in.err != nil {
+				s.disconnect()
+				continue
+			}
+			s.receive(in.m)
+		case r := <-s.q.Responses:
+			s.orders.respond(&r)
+		case now := <-ticker.C:
+			s.tick(now)
+		case <-s.g.hub.Done():
+			if s.conn != nil {
+				s.send(NewMessage(LOGOUT))
+				s.disconnect()
+			}
+			return
+		}
+	}
+}
+
+func (s *session) logon(in inbound) {
+	if s.conn != nil {
+		in.conn.Close()
+		return
+	}
+	m := in.m
+	heartbeat, ok := m.Uint(HEART_BT_INT)
+	if !ok {
+		in.conn.Close()
+		return
+	}
+	reset := m.Get(RESET_SEQ_NUM_FLAG) == "Y"
+	if reset {
+		s.inSeq = 1
+		s.outSeq = 1
+		s.sent = s.sent[:0]
+		s.sentFrom = 1
+	}
+	if seq, _ := m.Uint(MSG_SEQ_NUM); seq < s.inSeq {
+		in.conn.Close()
+		return
+	}
+	s.conn = in.conn
+	s.w = bufio.NewWriter(in.conn)
+	s.heartbeat = time.Duration(heartbeat) * s.g.unit
+	s.lastRecv = time.Now()
+	s.testSent = time.Time{}
+	s.resending = false
+	s.loggingOut = false
+	go s.read(in.conn, in.r)
+	reply := NewMessage(LOGON).Add(ENCRYPT_METHOD, "0").AddInt(HEART_BT_INT, int64(heartbeat))
+	if reset {
+		reply.Add(RESET_SEQ_NUM_FLAG, "Y")
+	}
+	s.send(reply)
+	s.sequence(m)
+}
+
+func (s *session) read(conn net.Conn, r *Reader) {
+	for {
+		m := &Message{}
+		err := r.Read(m)
+		if err == ErrGarbled {
+			continue
+		}
+		select {
+		case s.inbound <- inbound{conn: conn, m: m, err: err}:
+		case <-s.g.hub.Done():
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Logs out a counterparty sending orders faster than the engine takes them
+func (s *session) overrun() {
+	s.send(NewMessage(LOGOUT).Add(TEXT, OVERRUN_TEXT))
+	s.disconnect()
+}
+
+func (s *session) disconnect() {
+	if s.conn == nil {
+		return
+	}
+	s.conn.Close()
+	s.conn = nil
+	s.w = nil
+}
+
+func (s *session) receive(m *Message) {
+	s.lastRecv = time.Now()
+	s.testSent = time.Time{}
+	// Serving a ResendRequest first means two sessions which have both seen a gap can't stall each other
+	if m.Type() == RESEND_REQUEST {
+		s.resend(m)
+	}
+	if m.Type() == SEQUENCE_RESET && m.Get(GAP_FILL_FLAG) != "Y" {
+		if next, ok := m.Uint(NEW_SEQ_NO); ok && next > s.inSeq {
+			s.inSeq = next
+		}
+		return
+	}
+	if !s.sequence(m) {
+		return
+	}
+	switch m.Type() {
+	case HEARTBEAT, REJECT, RESEND_REQUEST, LOGON:
+	case TEST_REQUEST:
+		s.send(NewMessage(HEARTBEAT).Add(TEST_REQ_ID, m.Get(TEST_REQ_ID)))
+	case SEQUENCE_RESET:
+		if next, ok := m.Uint(NEW_SEQ_NO); ok && next > s.inSeq {
+			s.inSeq = next
+		}
+	case LOGOUT:
+		if !s.loggingOut {
+			s.send(NewMessage(LOGOUT))
+		}
+		s.disconnect()
+	case NEW_ORDER_SINGLE:
+		s.orders.newOrder(m)
+	case ORDER_CANCEL_REQUEST:
+		s.orders.cancel(m)
+	case ORDER_CANCEL_REPLACE_REQUEST:
+		s.orders.replace(m)
+	default:
+		s.send(NewMessage(BUSINESS_MESSAGE_REJECT).Add(REF_SEQ_NUM, m.Get(MSG_SEQ_NUM)).Add(REF_MSG_TYPE, m.Type()).Add(BUSINESS_REJECT_REASON, "3"))
+	}
+}
+
+// Checks the sequence number of m, returning true if m is the next message expected. A gap is answered
+// with a ResendRequest and messages past it are dropped until it has been filled. A sequence number which
+// is too low, and isn't a possible duplicate, ends the session.
+func (s *session) sequence(m *Message) bool {
+	seq, _ := m.Uint(MSG_SEQ_NUM)
+	switch {
+	case seq > s.inSeq:
+		if !s.resending {
+			s.send(NewMessage(RESEND_REQUEST).AddInt(BEGIN_SEQ_NO, int64(s.inSeq)).Add(END_SEQ_NO, "0"))
+			s.resending = true
+		}
+		return false
+	case seq < s.inSeq:
+		if m.Get(POSS_DUP_FLAG) != "Y" {
+			s.send(NewMessage(LOGOUT).Add(TEXT, "MsgSeqNum too low, expecting "+strconv.FormatUint(s.inSeq, 10)))
+			s.disconnect()
+		}
+		return false
+	}
+	s.inSeq++
+	s.resending = false
+	return true
+}
+
+// Resends the application messages asked for as possible duplicates, admin messages and messages too old
+// to be kept are skipped with gap fills
+func (s *session) resend(m *Message) {
+	begin, _ := m.Uint(BEGIN_SEQ_NO)
+	end, _ := m.Uint(END_SEQ_NO)
+	if begin == 0 {
+		begin = 1
+	}
+	if end == 0 || end >= s.outSeq {
+		end = s.outSeq - 1
+	}
+	gap := uint64(0)
+	for seq := begin; seq <= end; seq++ {
+		var sent *Message
+		if seq >= s.sentFrom {
+			sent = s.sent[seq-s.sentFrom]
+		}
+		if sent == nil {
+			if gap == 0 {
+				gap = seq
+			}
+			continue
+		}
+		if gap != 0 {
+			s.gapFill(gap, seq)
+			gap = 0
+		}
+		s.write(sent, seq, true)
+	}
+	if gap != 0 {
+		s.gapFill(gap, end+1)
+	}
+}
+
+func (s *session) gapFill(seq, next uint64) {
+	s.write(NewMessage(SEQUENCE_RESET).Add(GAP_FILL_FLAG, "Y").AddInt(NEW_SEQ_NO, int64(next)), seq, true)
+}
+
+func (s *session) tick(now time.Time) {
+	if s.conn == nil || s.heartbeat == 0 {
+		return
+	}
+	if now.Sub(s.lastSent) >= s.heartbeat {
+		s.send(NewMessage(HEARTBEAT))
+	}
+	if now.Sub(s.lastRecv) < s.heartbeat+s.heartbeat/5 {
+		return
+	}
+	if s.testSent.IsZero() {
+		s.testReqs++
+		s.send(NewMessage(TEST_REQUEST).Add(TEST_REQ_ID, "TEST"+strconv.Itoa(s.testReqs)))
+		s.testSent = now
+		return
+	}
+	if now.Sub(s.testSent) >= s.heartbeat {
+		s.disconnect()
+	}
+}
+
+// Sends a new message with the next sequence number. Application messages are kept for resending and are
+// sequenced even while disconnected, so the counterparty finds the gap when it reconnects. At least the last
+// RESEND_HISTORY messages are kept.
+func (s *session) send(m *Message) {
+	if len(s.sent) == 2*RESEND_HISTORY {
+		n := copy(s.sent, s.sent[RESEND_HISTORY:])
+		clear(s.sent[n:])
+		s.sent = s.sent[:n]
+		s.sentFrom += RESEND_HISTORY
+	}
+	switch m.Type() {
+	case EXECUTION_REPORT, ORDER_CANCEL_REJECT, BUSINESS_MESSAGE_REJECT:
+		m.Add(SENDING_TIME, time.Now().UTC().Format(sendingTimeFormat))
+		s.sent = append(s.sent, m)
+	default:
+		if s.conn == nil {
+			return
+		}
+		s.sent = append(s.sent, nil)
+	}
+	seq := s.outSeq
+	s.outSeq++
+	s.write(m, seq, false)
+	if m.Type() == LOGOUT {
+		s.loggingOut = true
+	}
+}
+
+// Writes m with the standard header. A possible duplicate carries the time m was first sent, if it has one.
+func (s *session) write(m *Message, seq uint64, possDup bool) {
+	if s.conn == nil {
+		return
+	}
+	s.lastSent = time.Now()
+	now := s.lastSent.UTC().Format(sendingTimeFormat)
+	h := &Message{Fields: make([]Field, 0, len(m.Fields)+7)}
+	h.Add(MSG_TYPE, m.Type()).Add(SENDER_COMP_ID, s.g.cfg.CompId).Add(TARGET_COMP_ID, s.compId).AddInt(MSG_SEQ_NUM, int64(seq))
+	if possDup {
+		orig, ok := m.Lookup(SENDING_TIME)
+		if !ok {
+			orig = now
+		}
+		h.Add(POSS_DUP_FLAG, "Y").Add(ORIG_SENDING_TIME, orig)
+	}
+	h.Add(SENDING_TIME, now)
+	for _, f := range m.Fields[1:] {
+		if f.Tag != SENDING_TIME {
+			h.Fields = append(h.Fields, f)
+		}
+	}
+	s.buf = h.Encode(s.buf[:0])
+	// A counterparty which stops reading is disconnected, rather than holding up the session
+	s.conn.SetWriteDeadline(time.Now().Add(10 * s.g.unit))
+	if _, err := s.w.Write(s.buf); err != nil {
+		s.disconnect()
+		return
+	}
+	if err := s.w.Flush(); err != nil {
+		s.disconnect()
+	}
+}
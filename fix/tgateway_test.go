@@ -0,0 +1,237 @@
+package fix
+
+import (
+	"github.com/fmstephe/matching_engine/gateway"
+	"github.com/fmstephe/matching_engine/trade"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// Runs a gateway in front of a matcher, returning its address and a function to shut everything down
+func newExchange(t *testing.T, unit time.Duration) (string, func()) {
+	var g *Gateway
+	l := gateway.NewEngine(1000, func(r *trade.Response) {
+		g.Handle(r)
+	})
+	cfg := Config{CompId: "EXCH", Traders: map[string]uint32{"A": 1, "B": 2}, Symbols: map[string]uint32{"ACME": 1}}
+	g = newGateway(cfg, l.Submit, unit)
+	l.Start()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go g.Serve(ln)
+	return ln.Addr().String(), func() {
+		ln.Close()
+		g.Close()
+		l.Stop()
+	}
+}
+
+func expectFields(t *testing.T, m *Message, expected map[int]string) {
+	for tag, value := range expected {
+		if m.Get(tag) != value {
+			t.Errorf("Expecting %d=%s, got %d=%s instead in %v", tag, value, tag, m.Get(tag), m.Fields)
+		}
+	}
+}
+
+func TestGatewayOrders(t *testing.T) {
+	addr, stop := newExchange(t, time.Second)
+	defer stop()
+	a := dial(t, addr, "A")
+	a.logon(30, true)
+	b := dial(t, addr, "B")
+	b.logon(30, true)
+	a.send(newOrderSingle("a1", SIDE_BUY, 10, 100))
+	expectFields(t, a.expect(EXECUTION_REPORT), map[int]string{CL_ORD_ID: "a1", EXEC_TYPE: STATUS_NEW, ORD_STATUS: STATUS_NEW, LEAVES_QTY: "10"})
+	b.send(newOrderSingle("b1", SIDE_SELL, 4, 100))
+	expectFields(t, b.expect(EXECUTION_REPORT), map[int]string{CL_ORD_ID: "b1", EXEC_TYPE: STATUS_NEW})
+	expectFields(t, b.expect(EXECUTION_REPORT), map[int]string{EXEC_TYPE: EXEC_TRADE, ORD_STATUS: STATUS_FILLED, LAST_QTY: "4", LAST_PX: "100", LEAVES_QTY: "0"})
+	expectFields(t, a.expect(EXECUTION_REPORT), map[int]string{EXEC_TYPE: EXEC_TRADE, ORD_STATUS: STATUS_PARTIALLY_FILLED, CUM_QTY: "4", LEAVES_QTY: "6", AVG_PX: "100"})
+	// Replace the rest of a1 with a higher priced order
+	replace := NewMessage(ORDER_CANCEL_REPLACE_REQUEST).Add(ORIG_CL_ORD_ID, "a1").Add(CL_ORD_ID, "a2").Add(SYMBOL, "ACME").Add(SIDE, SIDE_BUY)
+	a.send(replace.Add(ORD_TYPE, TYPE_LIMIT).Add(ORDER_QTY, "8").Add(PRICE, "101"))
+	expectFields(t, a.expect(EXECUTION_REPORT), map[int]string{CL_ORD_ID: "a2", ORIG_CL_ORD_ID: "a1", EXEC_TYPE: STATUS_REPLACED, ORD_STATUS: STATUS_PARTIALLY_FILLED, ORDER_QTY: "8", CUM_QTY: "4", LEAVES_QTY: "4"})
+	b.send(newOrderSingle("b2", SIDE_SELL, 2, 101))
+	b.expect(EXECUTION_REPORT)
+	expectFields(t, b.expect(EXECUTION_REPORT), map[int]string{EXEC_TYPE: EXEC_TRADE, LAST_PX: "101"})
+	expectFields(t, a.expect(EXECUTION_REPORT), map[int]string{CL_ORD_ID: "a2", EXEC_TYPE: EXEC_TRADE, CUM_QTY: "6", LEAVES_QTY: "2"})
+	// Cancel it, then try again
+	a.send(NewMessage(ORDER_CANCEL_REQUEST).Add(ORIG_CL_ORD_ID, "a2").Add(CL_ORD_ID, "a3").Add(SYMBOL, "ACME").Add(SIDE, SIDE_BUY))
+	expectFields(t, a.expect(EXECUTION_REPORT), map[int]string{CL_ORD_ID: "a3", ORIG_CL_ORD_ID: "a2", EXEC_TYPE: STATUS_CANCELED, ORD_STATUS: STATUS_CANCELED, LEAVES_QTY: "0"})
+	a.send(NewMessage(ORDER_CANCEL_REQUEST).Add(ORIG_CL_ORD_ID, "a2").Add(CL_ORD_ID, "a4").Add(SYMBOL, "ACME").Add(SIDE, SIDE_BUY))
+	expectFields(t, a.expect(ORDER_CANCEL_REJECT), map[int]string{CL_ORD_ID: "a4", CXL_REJ_REASON: CXL_REJECT_TOO_LATE, CXL_REJ_RESPONSE_TO: "1"})
+	a.send(NewMessage(ORDER_CANCEL_REQUEST).Add(ORIG_CL_ORD_ID, "zz").Add(CL_ORD_ID, "a5").Add(SYMBOL, "ACME").Add(SIDE, SIDE_BUY))
+	expectFields(t, a.expect(ORDER_CANCEL_REJECT), map[int]string{ORDER_ID: "NONE", CXL_REJ_REASON: CXL_REJECT_UNKNOWN})
+	// Trades are priced between the buy and the sell, a filled order can't be cancelled
+	b.send(newOrderSingle("b3", SIDE_SELL, 3, 90))
+	b.expect(EXECUTION_REPORT)
+	a.send(newOrderSingle("a6", SIDE_BUY, 3, 95))
+	a.expect(EXECUTION_REPORT)
+	expectFields(t, a.expect(EXECUTION_REPORT), map[int]string{CL_ORD_ID: "a6", ORD_STATUS: STATUS_FILLED, LAST_PX: "92"})
+	a.send(NewMessage(ORDER_CANCEL_REQUEST).Add(ORIG_CL_ORD_ID, "a6").Add(CL_ORD_ID, "a7").Add(SYMBOL, "ACME").Add(SIDE, SIDE_BUY))
+	expectFields(t, a.expect(ORDER_CANCEL_REJECT), map[int]string{CL_ORD_ID: "a7", ORD_STATUS: STATUS_FILLED, CXL_REJ_REASON: CXL_REJECT_TOO_LATE})
+	// Invalid orders and unsupported messages
+	bad := newOrderSingle("a8", SIDE_BUY, 1, 100)
+	bad.Set(SYMBOL, "NOPE")
+	a.send(bad)
+	expectFields(t, a.expect(EXECUTION_REPORT), map[int]string{CL_ORD_ID: "a8", EXEC_TYPE: STATUS_REJECTED, ORD_REJ_REASON: REJECT_UNKNOWN_SYMBOL})
+	a.send(newOrderSingle("a1", SIDE_BUY, 1, 100))
+	expectFields(t, a.expect(EXECUTION_REPORT), map[int]string{EXEC_TYPE: STATUS_REJECTED, ORD_REJ_REASON: REJECT_DUPLICATE_ORDER})
+	a.send(NewMessage("AE"))
+	expectFields(t, a.expect(BUSINESS_MESSAGE_REJECT), map[int]string{REF_MSG_TYPE: "AE"})
+	a.send(NewMessage(LOGOUT))
+	a.expect(LOGOUT)
+}
+
+// A resting buy filled by a larger sell is filled, whatever the size of the sell
+func TestGatewayRestingFill(t *testing.T) {
+	addr, stop := newExchange(t, time.Second)
+	defer stop()
+	a := dial(t, addr, "A")
+	a.logon(30, true)
+	b := dial(t, addr, "B")
+	b.logon(30, true)
+	a.send(newOrderSingle("a1", SIDE_BUY, 3, 100))
+	a.expect(EXECUTION_REPORT)
+	b.send(newOrderSingle("b1", SIDE_SELL, 5, 100))
+	b.expect(EXECUTION_REPORT)
+	expectFields(t, b.expect(EXECUTION_REPORT), map[int]string{ORD_STATUS: STATUS_PARTIALLY_FILLED, CUM_QTY: "3", LEAVES_QTY: "2"})
+	expectFields(t, a.expect(EXECUTION_REPORT), map[int]string{CL_ORD_ID: "a1", ORD_STATUS: STATUS_FILLED, CUM_QTY: "3", LEAVES_QTY: "0"})
+}
+
+func TestGatewaySequence(t *testing.T) {
+	addr, stop := newExchange(t, time.Second)
+	defer stop()
+	a := dial(t, addr, "A")
+	a.logon(30, true)
+	a.send(newOrderSingle("a1", SIDE_BUY, 5, 50))
+	expectFields(t, a.expect(EXECUTION_REPORT), map[int]string{MSG_SEQ_NUM: "2"})
+	a.send(NewMessage(TEST_REQUEST).Add(TEST_REQ_ID, "X"))
+	expectFields(t, a.expect(HEARTBEAT), map[int]string{TEST_REQ_ID: "X", MSG_SEQ_NUM: "3"})
+	// Skip 4, the gateway asks for it and drops 5
+	a.sendSeq(NewMessage(TEST_REQUEST).Add(TEST_REQ_ID, "Y"), 5)
+	expectFields(t, a.expect(RESEND_REQUEST), map[int]string{BEGIN_SEQ_NO: "4", END_SEQ_NO: "0"})
+	a.sendSeq(NewMessage(SEQUENCE_RESET).Add(POSS_DUP_FLAG, "Y").Add(GAP_FILL_FLAG, "Y").Add(NEW_SEQ_NO, "6"), 4)
+	a.sendSeq(NewMessage(TEST_REQUEST).Add(TEST_REQ_ID, "Z"), 6)
+	expectFields(t, a.expect(HEARTBEAT), map[int]string{TEST_REQ_ID: "Z", MSG_SEQ_NUM: "5"})
+	// Everything the gateway sent, admin messages are gap filled
+	a.send(NewMessage(RESEND_REQUEST).Add(BEGIN_SEQ_NO, "1").Add(END_SEQ_NO, "0"))
+	expectFields(t, a.expect(SEQUENCE_RESET), map[int]string{MSG_SEQ_NUM: "1", NEW_SEQ_NO: "2", GAP_FILL_FLAG: "Y"})
+	dup := a.expect(EXECUTION_REPORT)
+	expectFields(t, dup, map[int]string{MSG_SEQ_NUM: "2", POSS_DUP_FLAG: "Y", CL_ORD_ID: "a1"})
+	if _, ok := dup.Lookup(ORIG_SENDING_TIME); !ok {
+		t.Errorf("Expecting OrigSendingTime, got %v instead", dup.Fields)
+	}
+	expectFields(t, a.expect(SEQUENCE_RESET), map[int]string{MSG_SEQ_NUM: "3", NEW_SEQ_NO: "6"})
+	// A sequence number which is too low ends the session
+	next := a.seq
+	a.sendSeq(NewMessage(TEST_REQUEST).Add(TEST_REQ_ID, "W"), 2)
+	expectFields(t, a.expect(LOGOUT), map[int]string{MSG_SEQ_NUM: "6"})
+	if _, err := a.read(); err == nil {
+		t.Errorf("Expecting the connection to be closed")
+	}
+	// A fill while disconnected is recovered after logging on again
+	b := dial(t, addr, "B")
+	b.logon(30, true)
+	b.send(newOrderSingle("b1", SIDE_SELL, 5, 50))
+	b.expect(EXECUTION_REPORT)
+	b.expect(EXECUTION_REPORT)
+	a.redial()
+	a.seq = next
+	a.logon(30, false)
+	a.send(NewMessage(RESEND_REQUEST).Add(BEGIN_SEQ_NO, "7").Add(END_SEQ_NO, "0"))
+	for {
+		m := a.expect(EXECUTION_REPORT)
+		if m.Get(ORD_STATUS) == STATUS_FILLED {
+			expectFields(t, m, map[int]string{CL_ORD_ID: "a1", CUM_QTY: "5"})
+			break
+		}
+	}
+}
+
+// Completed orders and sent messages are only kept for so long
+func TestGatewayForgets(t *testing.T) {
+	addr, stop := newExchange(t, time.Second)
+	defer stop()
+	a := dial(t, addr, "A")
+	a.logon(30, true)
+	for i := 0; i <= COMPLETED_ORDERS; i++ {
+		clOrdId := "a" + strconv.Itoa(i)
+		a.send(newOrderSingle(clOrdId, SIDE_BUY, 1, 100))
+		a.expect(EXECUTION_REPORT)
+		a.send(NewMessage(ORDER_CANCEL_REQUEST).Add(ORIG_CL_ORD_ID, clOrdId).Add(CL_ORD_ID, "c"+strconv.Itoa(i)).Add(SYMBOL, "ACME").Add(SIDE, SIDE_BUY))
+		expectFields(t, a.expect(EXECUTION_REPORT), map[int]string{ORD_STATUS: STATUS_CANCELED})
+	}
+	a.send(NewMessage(ORDER_CANCEL_REQUEST).Add(ORIG_CL_ORD_ID, "a0").Add(CL_ORD_ID, "x").Add(SYMBOL, "ACME").Add(SIDE, SIDE_BUY))
+	expectFields(t, a.expect(ORDER_CANCEL_REJECT), map[int]string{ORDER_ID: "NONE", CXL_REJ_REASON: CXL_REJECT_UNKNOWN})
+	last := "a" + strconv.Itoa(COMPLETED_ORDERS)
+	a.send(NewMessage(ORDER_CANCEL_REQUEST).Add(ORIG_CL_ORD_ID, last).Add(CL_ORD_ID, "y").Add(SYMBOL, "ACME").Add(SIDE, SIDE_BUY))
+	expectFields(t, a.expect(ORDER_CANCEL_REJECT), map[int]string{CXL_REJ_REASON: CXL_REJECT_TOO_LATE})
+	// More than 2*RESEND_HISTORY messages have been sent, the first RESEND_HISTORY have been dropped
+	from := strconv.Itoa(RESEND_HISTORY + 1)
+	a.send(NewMessage(RESEND_REQUEST).Add(BEGIN_SEQ_NO, "1").Add(END_SEQ_NO, from))
+	expectFields(t, a.expect(SEQUENCE_RESET), map[int]string{MSG_SEQ_NUM: "1", NEW_SEQ_NO: from, GAP_FILL_FLAG: "Y"})
+	expectFields(t, a.expect(EXECUTION_REPORT), map[int]string{MSG_SEQ_NUM: from, POSS_DUP_FLAG: "Y"})
+}
+
+func TestGatewayHeartbeat(t *testing.T) {
+	addr, stop := newExchange(t, 20*time.Millisecond)
+	defer stop()
+	a := dial(t, addr, "A")
+	a.logon(1, true)
+	// A silent initiator is sent heartbeats, then a test request, then disconnected
+	a.expect(HEARTBEAT)
+	a.expect(TEST_REQUEST)
+	for {
+		if _, err := a.read(); err != nil {
+			break
+		}
+	}
+	// An unknown counterparty can't log on
+	x := dial(t, addr, "X")
+	x.send(NewMessage(LOGON).Add(ENCRYPT_METHOD, "0").Add(HEART_BT_INT, "30"))
+	if _, err := x.read(); err == nil {
+		t.Errorf("Expecting the connection to be closed")
+	}
+}
+
+// Orders sent faster than the engine takes them are rejected once the session's queue is full, and the
+// counterparty is logged out
+func TestGatewayOverrun(t *testing.T) {
+	release := make(chan struct{})
+	cfg := Config{CompId: "EXCH", Traders: map[string]uint32{"A": 1}, Symbols: map[string]uint32{"ACME": 1}}
+	g := newGateway(cfg, func(od *trade.OrderData) { <-release }, time.Second)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go g.Serve(ln)
+	defer func() {
+		ln.Close()
+		close(release)
+		g.Close()
+	}()
+	a := dial(t, ln.Addr().String(), "A")
+	a.logon(30, true)
+	for i := 0; i <= gateway.QUEUE_LEN+1; i++ {
+		a.send(newOrderSingle("a"+strconv.Itoa(i), SIDE_BUY, 1, 100))
+	}
+	accepted := 0
+	for {
+		m := a.expect(EXECUTION_REPORT)
+		if m.Get(EXEC_TYPE) == STATUS_REJECTED {
+			expectFields(t, m, map[int]string{ORD_REJ_REASON: REJECT_OTHER, TEXT: OVERRUN_TEXT})
+			break
+		}
+		accepted++
+	}
+	// The first order may already be with the engine
+	if accepted != gateway.QUEUE_LEN && accepted != gateway.QUEUE_LEN+1 {
+		t.Errorf("Expecting %d orders accepted, got %d instead", gateway.QUEUE_LEN, accepted)
+	}
+	expectFields(t, a.expect(LOGOUT), map[int]string{TEXT: OVERRUN_TEXT})
+}
@@ -0,0 +1,43 @@
+package fix
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEncode(t *testing.T) {
+	m := NewMessage(HEARTBEAT).Add(SENDER_COMP_ID, "A").Add(TARGET_COMP_ID, "B").Add(MSG_SEQ_NUM, "1")
+	expected := "8=FIX.4.4\x019=20\x0135=0\x0149=A\x0156=B\x0134=1\x0110=125\x01"
+	if encoded := string(m.Encode(nil)); encoded != expected {
+		t.Errorf("Expecting %q, got %q instead", expected, encoded)
+	}
+}
+
+func TestRead(t *testing.T) {
+	var stream []byte
+	first := NewMessage(NEW_ORDER_SINGLE).Add(CL_ORD_ID, "1").AddInt(PRICE, 100)
+	stream = first.Encode(stream)
+	// Damage the checksum of the second message
+	damaged := NewMessage(HEARTBEAT).Encode(nil)
+	damaged[len(damaged)-2]++
+	stream = append(stream, damaged...)
+	stream = NewMessage(LOGOUT).Encode(stream)
+	r := NewReader(bytes.NewReader(stream))
+	m := &Message{}
+	if err := r.Read(m); err != nil {
+		t.Fatal(err)
+	}
+	if m.Type() != NEW_ORDER_SINGLE || m.Get(CL_ORD_ID) != "1" || m.Get(PRICE) != "100" {
+		t.Errorf("Expecting %v, got %v instead", first.Fields, m.Fields)
+	}
+	if err := r.Read(m); err != ErrGarbled {
+		t.Errorf("Expecting %v, got %v instead", ErrGarbled, err)
+	}
+	if err := r.Read(m); err != nil || m.Type() != LOGOUT {
+		t.Errorf("Expecting %s, got %v (%v) instead", LOGOUT, m.Fields, err)
+	}
+	r = NewReader(bytes.NewReader([]byte("8=FIX.4.2\x019=5\x0135=0\x0110=000\x01")))
+	if err := r.Read(m); err != ErrFraming {
+		t.Errorf("Expecting %v, got %v instead", ErrFraming, err)
+	}
+}
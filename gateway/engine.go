@@ -0,0 +1,18 @@
+package gateway
+
+import (
+	"github.com/fmstephe/matching_engine/cbuf"
+	"github.com/fmstephe/matching_engine/engine"
+	"github.com/fmstephe/matching_engine/matcher"
+	"github.com/fmstephe/matching_engine/trade"
+)
+
+// A matcher with room for slabSize resting orders, behind two lanes which put cancels first. Its responses
+// are passed to handle, which is usually a gateway's Handle. Enough for a gateway with a handful of traders,
+// and for testing one. Orders are passed to Submit once it has been started.
+func NewEngine(slabSize int, handle func(r *trade.Response)) *engine.Lanes {
+	out := cbuf.NewSPSC(64, cbuf.ParkWait)
+	m := matcher.NewMatcher(slabSize, out)
+	r := engine.NewRunner(m, out, 8, cbuf.ParkWait, handle)
+	return engine.NewLanes(r, cbuf.ParkWait, engine.ByCancel, engine.Lane{Size: 64, Burst: 8}, engine.Lane{Size: 64})
+}
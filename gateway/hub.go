@@ -0,0 +1,102 @@
+package gateway
+
+import (
+	"github.com/fmstephe/matching_engine/trade"
+	"net"
+	"sync"
+)
+
+// The number of responses, and of orders, each session can have waiting
+const QUEUE_LEN = 1024
+
+// Connects the sessions of an order entry gateway to the engine. Each trader's session has a queue of the
+// engine's responses for it and a queue of its orders for the engine, which a goroutine of its own submits.
+// A session never waits on the engine, so the engine only waits on a session while that session is busy
+// with a full queue of responses.
+type Hub struct {
+	submit   func(od *trade.OrderData)
+	byTrader map[uint32]*Queues
+	done     chan struct{}
+	wg       sync.WaitGroup
+}
+
+type Queues struct {
+	Responses chan trade.Response // The engine's responses for the session's trader
+	orders    chan trade.OrderData
+}
+
+// Orders are passed to submit, which must be safe to call from several goroutines
+func NewHub(submit func(od *trade.OrderData)) *Hub {
+	return &Hub{submit: submit, byTrader: make(map[uint32]*Queues), done: make(chan struct{})}
+}
+
+// Creates the queues for traderId's session, and starts submitting its orders. Must be called before Handle.
+func (h *Hub) Add(traderId uint32) *Queues {
+	q := &Queues{Responses: make(chan trade.Response, QUEUE_LEN), orders: make(chan trade.OrderData, QUEUE_LEN)}
+	h.byTrader[traderId] = q
+	h.Go(func() {
+		for {
+			select {
+			case od := <-q.orders:
+				h.submit(&od)
+			case <-h.done:
+				return
+			}
+		}
+	})
+	return q
+}
+
+// Runs f on a goroutine of its own, f must return once Done is closed
+func (h *Hub) Go(f func()) {
+	h.wg.Add(1)
+	go func() {
+		defer h.wg.Done()
+		f()
+	}()
+}
+
+// Passes a response to the session of the trader it is for. Waits while that session's queue is full.
+// May be called from any goroutine.
+func (h *Hub) Handle(r *trade.Response) {
+	if q, ok := h.byTrader[r.TraderId]; ok {
+		select {
+		case q.Responses <- *r:
+		case <-h.done:
+		}
+	}
+}
+
+// Closed once the hub is closing
+func (h *Hub) Done() <-chan struct{} {
+	return h.done
+}
+
+// Waits for every goroutine started with Go to return. Orders already being submitted are submitted, so the
+// engine must still be running, orders still queued are dropped.
+func (h *Hub) Close() {
+	close(h.done)
+	h.wg.Wait()
+}
+
+// Queues od for the engine, returning false if QUEUE_LEN orders are already waiting. Orders are submitted
+// in the order they are queued.
+func (q *Queues) Submit(od *trade.OrderData) bool {
+	select {
+	case q.orders <- *od:
+		return true
+	default:
+		return false
+	}
+}
+
+// Passes each connection ln accepts to accept, on a goroutine of its own, until ln is closed
+func Serve(ln net.Listener, accept func(conn net.Conn)) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go accept(conn)
+	}
+}
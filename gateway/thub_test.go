@@ -0,0 +1,71 @@
+package gateway
+
+import (
+	"github.com/fmstephe/matching_engine/trade"
+	"testing"
+)
+
+// A hub whose submit blocks until released, as an engine would while it is busy
+func newBlockedHub() (h *Hub, started, release chan struct{}) {
+	started = make(chan struct{}, 1)
+	release = make(chan struct{})
+	h = NewHub(func(od *trade.OrderData) {
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		<-release
+	})
+	return h, started, release
+}
+
+// Responses reach a session while its orders wait for the engine
+func TestHandleWhileSubmitting(t *testing.T) {
+	h, started, release := newBlockedHub()
+	q := h.Add(1)
+	handled := make(chan int)
+	h.Go(func() {
+		n := 0
+		for {
+			select {
+			case <-q.Responses:
+				if n++; n == 2*QUEUE_LEN {
+					handled <- n
+				}
+			case <-h.Done():
+				return
+			}
+		}
+	})
+	od := &trade.OrderData{}
+	od.WriteBuy(trade.CostData{Price: 1, Amount: 1}, trade.TradeData{TraderId: 1, TradeId: 1, StockId: 1})
+	q.Submit(od)
+	<-started
+	r := &trade.Response{TraderId: 1}
+	for i := 0; i < 2*QUEUE_LEN; i++ {
+		h.Handle(r)
+	}
+	if n := <-handled; n != 2*QUEUE_LEN {
+		t.Errorf("Expecting %d responses, got %d instead", 2*QUEUE_LEN, n)
+	}
+	close(release)
+	h.Close()
+}
+
+func TestSubmitFull(t *testing.T) {
+	h, started, release := newBlockedHub()
+	q := h.Add(1)
+	od := &trade.OrderData{}
+	q.Submit(od)
+	<-started
+	for i := 0; i < QUEUE_LEN; i++ {
+		if !q.Submit(od) {
+			t.Fatalf("Expecting order %d to be queued", i)
+		}
+	}
+	if q.Submit(od) {
+		t.Errorf("Expecting a full queue")
+	}
+	close(release)
+	h.Close()
+}
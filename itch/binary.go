@@ -0,0 +1,193 @@
+package itch
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/fmstephe/matching_engine/trade"
+	"io"
+)
+
+// A decoded ITCH 5.0 message. Only the fields belonging to Type are set, message types we don't decode
+// only have their header fields set.
+type Message struct {
+	Type        byte
+	Locate      uint16
+	Tracking    uint16
+	Timestamp   uint64 // Nanoseconds since midnight
+	Ref         uint64 // Order reference number, the original reference for OrderReplace
+	NewRef      uint64 // OrderReplace only
+	Side        trade.OrderKind
+	Shares      uint32
+	Price       uint32 // Limit price, or the execution price for ExecutedPrice
+	Match       uint64
+	Printable   bool
+	Symbol      [symbolLen]byte
+	Attribution [4]byte
+	EventCode   byte
+	Offset      int64 // Offset of this message's length prefix in the stream
+}
+
+// Writes the order described by an AddOrder, AddOrderMPID or OrderDelete message into od. Returns false for
+// any other message type. The order's guid is the ITCH order reference number and its stock id is the locate.
+func (m *Message) OrderData(od *trade.OrderData) bool {
+	switch m.Type {
+	case AddOrder, AddOrderMPID:
+		od.Write(trade.CostData{Price: int64(m.Price), Amount: m.Shares}, m.tradeData(m.Ref), m.Side)
+		return true
+	case OrderDelete:
+		od.Write(trade.CostData{}, m.tradeData(m.Ref), trade.CANCEL)
+		return true
+	}
+	return false
+}
+
+// Writes an OrderReplace message as a cancel of the original order followed by a new order. ITCH does not
+// repeat the side of the original order so it must be provided. Returns false for any other message type.
+func (m *Message) ReplaceData(side trade.OrderKind, cancel, add *trade.OrderData) bool {
+	if m.Type != OrderReplace {
+		return false
+	}
+	cancel.Write(trade.CostData{}, m.tradeData(m.Ref), trade.CANCEL)
+	add.Write(trade.CostData{Price: int64(m.Price), Amount: m.Shares}, m.tradeData(m.NewRef), side)
+	return true
+}
+
+func (m *Message) tradeData(ref uint64) trade.TradeData {
+	return trade.TradeData{TraderId: uint32(ref >> 32), TradeId: uint32(ref), StockId: uint32(m.Locate)}
+}
+
+// The side indicator of an AddOrder, AddOrderMPID or NonCrossTrade was neither buy nor sell
+type SideError struct {
+	Offset    int64
+	Type      byte
+	Indicator byte
+}
+
+func (e *SideError) Error() string {
+	return fmt.Sprintf("Message %q at offset %d has side %q", e.Type, e.Offset, e.Indicator)
+}
+
+type FormatError struct {
+	Offset int64
+	Type   byte
+	Length int
+}
+
+func (e *FormatError) Error() string {
+	return fmt.Sprintf("Message %q at offset %d has length %d, expected %d", e.Type, e.Offset, e.Length, messageLens[e.Type])
+}
+
+// Reads length prefixed ITCH 5.0 binary messages. Reading does not allocate.
+type BinaryReader struct {
+	r      *bufio.Reader
+	buf    [maxMessageLen]byte
+	msg    Message
+	offset int64
+}
+
+func NewBinaryReader(r io.Reader) *BinaryReader {
+	return &BinaryReader{r: bufio.NewReaderSize(r, 64*1024)}
+}
+
+// Returns the next message in the stream, io.EOF at the end of the stream. The returned message is
+// reused and is only valid until the next call to Next.
+func (br *BinaryReader) Next() (*Message, error) {
+	if _, err := io.ReadFull(br.r, br.buf[:lengthPrefixLen]); err != nil {
+		return nil, err
+	}
+	m := &br.msg
+	*m = Message{Offset: br.offset}
+	l := int(get16(br.buf[:]))
+	br.offset += int64(lengthPrefixLen + l)
+	if l < headerLen {
+		if _, err := br.r.Discard(l); err != nil {
+			return nil, unexpected(err)
+		}
+		return nil, &FormatError{Offset: m.Offset, Length: l}
+	}
+	if l > len(br.buf) {
+		if _, err := io.ReadFull(br.r, br.buf[:headerLen]); err != nil {
+			return nil, unexpected(err)
+		}
+		if _, err := br.r.Discard(l - headerLen); err != nil {
+			return nil, unexpected(err)
+		}
+	} else if _, err := io.ReadFull(br.r, br.buf[:l]); err != nil {
+		return nil, unexpected(err)
+	}
+	b := br.buf[:]
+	m.Type = b[0]
+	m.Locate = get16(b[1:])
+	m.Tracking = get16(b[3:])
+	m.Timestamp = get48(b[5:])
+	expected := int(messageLens[m.Type])
+	if expected == 0 {
+		return m, nil
+	}
+	if l != expected {
+		return nil, &FormatError{Offset: m.Offset, Type: m.Type, Length: l}
+	}
+	var ok bool
+	switch m.Type {
+	case SystemEvent:
+		m.EventCode = b[11]
+	case AddOrder, AddOrderMPID:
+		m.Ref = get64(b[11:])
+		if m.Side, ok = side(b[19]); !ok {
+			return nil, &SideError{Offset: m.Offset, Type: m.Type, Indicator: b[19]}
+		}
+		m.Shares = get32(b[20:])
+		copy(m.Symbol[:], b[24:32])
+		m.Price = get32(b[32:])
+		if m.Type == AddOrderMPID {
+			copy(m.Attribution[:], b[36:40])
+		}
+	case OrderExecuted, ExecutedPrice:
+		m.Ref = get64(b[11:])
+		m.Shares = get32(b[19:])
+		m.Match = get64(b[23:])
+		if m.Type == ExecutedPrice {
+			m.Printable = b[31] == 'Y'
+			m.Price = get32(b[32:])
+		}
+	case OrderCancel:
+		m.Ref = get64(b[11:])
+		m.Shares = get32(b[19:])
+	case OrderDelete:
+		m.Ref = get64(b[11:])
+	case OrderReplace:
+		m.Ref = get64(b[11:])
+		m.NewRef = get64(b[19:])
+		m.Shares = get32(b[27:])
+		m.Price = get32(b[31:])
+	case NonCrossTrade:
+		m.Ref = get64(b[11:])
+		if m.Side, ok = side(b[19]); !ok {
+			return nil, &SideError{Offset: m.Offset, Type: m.Type, Indicator: b[19]}
+		}
+		m.Shares = get32(b[20:])
+		copy(m.Symbol[:], b[24:32])
+		m.Price = get32(b[32:])
+		m.Match = get64(b[36:])
+	case StockDirectory, TradingAction:
+		copy(m.Symbol[:], b[11:19])
+	}
+	return m, nil
+}
+
+func side(indicator byte) (trade.OrderKind, bool) {
+	switch indicator {
+	case buyIndicator:
+		return trade.BUY, true
+	case sellIndicator:
+		return trade.SELL, true
+	}
+	return 0, false
+}
+
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,210 @@
+package itch
+
+import (
+	"errors"
+	"fmt"
+	"github.com/fmstephe/matching_engine/trade"
+	"io"
+	"math"
+)
+
+// Writes ITCH 5.0 binary messages, each preceded by a two byte big-endian length, as found in NASDAQ's
+// published ITCH files. Engine prices are written unscaled, i.e. a price of 1 is 0.0001 in ITCH terms.
+// The stock locate of each message is the engine's stock id, which must fit in 16 bits.
+type Encoder struct {
+	w       io.Writer
+	buf     [lengthPrefixLen + maxMessageLen]byte
+	now     uint64
+	match   uint64
+	symbols map[uint32][symbolLen]byte
+}
+
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w, symbols: make(map[uint32][symbolLen]byte)}
+}
+
+// Sets the timestamp, nanoseconds since midnight, of all following messages
+func (e *Encoder) SetTime(nanos int64) {
+	e.now = uint64(nanos) & timestampMask
+}
+
+// Stocks without a symbol are written using their decimal stock id
+func (e *Encoder) SetSymbol(stockId uint32, symbol string) error {
+	if len(symbol) > symbolLen {
+		return errors.New(fmt.Sprintf("Symbol %q is longer than %d characters", symbol, symbolLen))
+	}
+	e.symbols[stockId] = padSymbol(symbol)
+	return nil
+}
+
+func padSymbol(symbol string) [symbolLen]byte {
+	var s [symbolLen]byte
+	for i := range s {
+		s[i] = ' '
+	}
+	copy(s[:], symbol)
+	return s
+}
+
+// Writes the ITCH equivalent of d. Executions are given increasing match numbers, LEVEL deltas have no
+// ITCH equivalent and are ignored.
+func (e *Encoder) WriteDelta(d *trade.Delta) error {
+	switch d.Kind {
+	case trade.ADD:
+		return e.AddOrder(d.StockId, uint64(d.Guid), d.Side, d.Amount, d.Price)
+	case trade.EXECUTE:
+		e.match++
+		return e.Executed(d.StockId, uint64(d.Guid), d.Amount, e.match)
+	case trade.DELETE:
+		return e.Delete(d.StockId, uint64(d.Guid))
+	case trade.LEVEL:
+		return nil
+	}
+	return errors.New(fmt.Sprintf("Unknown delta kind %d", d.Kind))
+}
+
+func (e *Encoder) SystemEvent(code byte) error {
+	b, err := e.header(SystemEvent, 0)
+	if err != nil {
+		return err
+	}
+	b[11] = code
+	return e.flush(SystemEvent)
+}
+
+func (e *Encoder) AddOrder(stockId uint32, ref uint64, side trade.OrderKind, shares uint32, price int64) error {
+	b, err := e.header(AddOrder, stockId)
+	if err != nil {
+		return err
+	}
+	put64(b[11:], ref)
+	b[19] = indicator(side)
+	put32(b[20:], shares)
+	e.putSymbol(b[24:], stockId)
+	if err := putPrice(b[32:], price); err != nil {
+		return err
+	}
+	return e.flush(AddOrder)
+}
+
+func (e *Encoder) Executed(stockId uint32, ref uint64, shares uint32, match uint64) error {
+	b, err := e.header(OrderExecuted, stockId)
+	if err != nil {
+		return err
+	}
+	put64(b[11:], ref)
+	put32(b[19:], shares)
+	put64(b[23:], match)
+	return e.flush(OrderExecuted)
+}
+
+func (e *Encoder) ExecutedAtPrice(stockId uint32, ref uint64, shares uint32, match uint64, printable bool, price int64) error {
+	b, err := e.header(ExecutedPrice, stockId)
+	if err != nil {
+		return err
+	}
+	put64(b[11:], ref)
+	put32(b[19:], shares)
+	put64(b[23:], match)
+	b[31] = 'N'
+	if printable {
+		b[31] = 'Y'
+	}
+	if err := putPrice(b[32:], price); err != nil {
+		return err
+	}
+	return e.flush(ExecutedPrice)
+}
+
+func (e *Encoder) Cancel(stockId uint32, ref uint64, shares uint32) error {
+	b, err := e.header(OrderCancel, stockId)
+	if err != nil {
+		return err
+	}
+	put64(b[11:], ref)
+	put32(b[19:], shares)
+	return e.flush(OrderCancel)
+}
+
+func (e *Encoder) Delete(stockId uint32, ref uint64) error {
+	b, err := e.header(OrderDelete, stockId)
+	if err != nil {
+		return err
+	}
+	put64(b[11:], ref)
+	return e.flush(OrderDelete)
+}
+
+func (e *Encoder) Replace(stockId uint32, origRef, newRef uint64, shares uint32, price int64) error {
+	b, err := e.header(OrderReplace, stockId)
+	if err != nil {
+		return err
+	}
+	put64(b[11:], origRef)
+	put64(b[19:], newRef)
+	put32(b[27:], shares)
+	if err := putPrice(b[31:], price); err != nil {
+		return err
+	}
+	return e.flush(OrderReplace)
+}
+
+func (e *Encoder) Trade(stockId uint32, ref uint64, side trade.OrderKind, shares uint32, price int64, match uint64) error {
+	b, err := e.header(NonCrossTrade, stockId)
+	if err != nil {
+		return err
+	}
+	put64(b[11:], ref)
+	b[19] = indicator(side)
+	put32(b[20:], shares)
+	e.putSymbol(b[24:], stockId)
+	if err := putPrice(b[32:], price); err != nil {
+		return err
+	}
+	put64(b[36:], match)
+	return e.flush(NonCrossTrade)
+}
+
+// Writes the common header of every message and returns the message body, including the header
+func (e *Encoder) header(msgType byte, stockId uint32) ([]byte, error) {
+	if stockId > math.MaxUint16 {
+		return nil, errors.New(fmt.Sprintf("Stock id %d cannot be represented as an ITCH stock locate", stockId))
+	}
+	b := e.buf[lengthPrefixLen:]
+	b[0] = msgType
+	put16(b[1:], uint16(stockId))
+	put16(b[3:], 0) // Tracking number
+	put48(b[5:], e.now)
+	return b, nil
+}
+
+func (e *Encoder) putSymbol(b []byte, stockId uint32) {
+	s, ok := e.symbols[stockId]
+	if !ok {
+		s = padSymbol(fmt.Sprintf("%d", stockId))
+		e.symbols[stockId] = s
+	}
+	copy(b, s[:])
+}
+
+func (e *Encoder) flush(msgType byte) error {
+	l := int(messageLens[msgType])
+	put16(e.buf[:], uint16(l))
+	_, err := e.w.Write(e.buf[:lengthPrefixLen+l])
+	return err
+}
+
+func indicator(side trade.OrderKind) byte {
+	if side == trade.BUY {
+		return buyIndicator
+	}
+	return sellIndicator
+}
+
+func putPrice(b []byte, price int64) error {
+	if price < 0 || price > math.MaxUint32 {
+		return errors.New(fmt.Sprintf("Price %d cannot be represented in ITCH", price))
+	}
+	put32(b, uint32(price))
+	return nil
+}
@@ -0,0 +1,81 @@
+package itch
+
+// ITCH 5.0 message types
+const (
+	SystemEvent     = byte('S')
+	AddOrder        = byte('A')
+	AddOrderMPID    = byte('F')
+	OrderExecuted   = byte('E')
+	ExecutedPrice   = byte('C')
+	OrderCancel     = byte('X')
+	OrderDelete     = byte('D')
+	OrderReplace    = byte('U')
+	NonCrossTrade   = byte('P')
+	StockDirectory  = byte('R')
+	TradingAction   = byte('H')
+	buyIndicator    = byte('B')
+	sellIndicator   = byte('S')
+	symbolLen       = 8
+	headerLen       = 11 // Message type, stock locate, tracking number and timestamp
+	maxMessageLen   = 64
+	timestampMask   = 1<<48 - 1
+	lengthPrefixLen = 2
+)
+
+// The length in bytes of each message type we understand, excluding the two byte length prefix, indexed by
+// message type. Types we don't understand have length 0.
+var messageLens = [256]uint16{
+	SystemEvent:    12,
+	AddOrder:       36,
+	AddOrderMPID:   40,
+	OrderExecuted:  31,
+	ExecutedPrice:  36,
+	OrderCancel:    23,
+	OrderDelete:    19,
+	OrderReplace:   35,
+	NonCrossTrade:  44,
+	StockDirectory: 39,
+	TradingAction:  25,
+}
+
+func put16(b []byte, v uint16) {
+	b[0] = byte(v >> 8)
+	b[1] = byte(v)
+}
+
+func put32(b []byte, v uint32) {
+	b[0] = byte(v >> 24)
+	b[1] = byte(v >> 16)
+	b[2] = byte(v >> 8)
+	b[3] = byte(v)
+}
+
+func put48(b []byte, v uint64) {
+	b[0] = byte(v >> 40)
+	b[1] = byte(v >> 32)
+	b[2] = byte(v >> 24)
+	b[3] = byte(v >> 16)
+	b[4] = byte(v >> 8)
+	b[5] = byte(v)
+}
+
+func put64(b []byte, v uint64) {
+	put32(b, uint32(v>>32))
+	put32(b[4:], uint32(v))
+}
+
+func get16(b []byte) uint16 {
+	return uint16(b[0])<<8 | uint16(b[1])
+}
+
+func get32(b []byte) uint32 {
+	return uint32(b[0])<<24 | uint32(b[1])<<16 | uint32(b[2])<<8 | uint32(b[3])
+}
+
+func get48(b []byte) uint64 {
+	return uint64(get16(b))<<32 | uint64(get32(b[2:]))
+}
+
+func get64(b []byte) uint64 {
+	return uint64(get32(b))<<32 | uint64(get32(b[4:]))
+}
@@ -2,53 +2,100 @@ package itch
 
 import (
 	"bufio"
+	"errors"
+	"fmt"
 	"github.com/fmstephe/matching_engine/trade"
 	"io"
 	"math"
-	"os"
 	"strconv"
 	"strings"
 )
 
+type ErrorPolicy int
+
+const (
+	FAIL_ON_ERROR = ErrorPolicy(0) // ReadOrder returns the first malformed line as a *ParseError
+	SKIP_ERRORS   = ErrorPolicy(1) // Malformed lines are counted and skipped
+)
+
+var ErrShortLine = errors.New("Too few fields")
+
+// Describes a malformed line
+type ParseError struct {
+	Line uint
+	Text string
+	Err  error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("Line %d: %s: %q", e.Line, e.Err.Error(), e.Text)
+}
+
 type ItchReader struct {
 	lineCount uint
 	maxBuy    int64
 	minSell   int64
+	policy    ErrorPolicy
+	skipped   uint
+	lastErr   *ParseError
 	r         *bufio.Reader
 }
 
-func NewItchReader(fName string) *ItchReader {
-	f, err := os.Open(fName)
-	if err != nil {
-		panic(err.Error())
-	}
-	r := bufio.NewReader(f)
+// Reads orders from r, which may be a file, a decompressing reader, a pipe etc. The first line of column
+// headers is consumed immediately.
+func NewItchReader(r io.Reader) (*ItchReader, error) {
+	br := bufio.NewReader(r)
 	// Clear column headers
-	if _, err := r.ReadString('\n'); err != nil {
-		panic(err.Error())
+	if _, err := br.ReadString('\n'); err != nil {
+		return nil, err
 	}
-	return &ItchReader{lineCount: 1, minSell: math.MaxInt32, r: r}
+	return &ItchReader{lineCount: 1, minSell: math.MaxInt32, r: br}, nil
 }
 
+func (i *ItchReader) SetPolicy(policy ErrorPolicy) {
+	i.policy = policy
+}
+
+// Returns the next order, or a nil order for lines describing messages we ignore. At the end of the
+// input err is io.EOF.
 func (i *ItchReader) ReadOrder() (o *trade.Order, line string, err error) {
-	i.lineCount++
 	for {
-		line, err = i.r.ReadString('\n')
+		line, err = i.readLine()
 		if err != nil {
-			return
+			return nil, line, err
 		}
-		if line != "" {
+		o, err = mkOrder(line)
+		if err == nil {
 			break
 		}
+		perr := &ParseError{Line: i.lineCount, Text: strings.TrimRight(line, "\r\n"), Err: err}
+		if i.policy == FAIL_ON_ERROR {
+			return nil, line, perr
+		}
+		i.skipped++
+		i.lastErr = perr
 	}
-	o, err = mkOrder(line)
 	if o != nil && o.Kind() == trade.BUY && o.Price() > i.maxBuy {
 		i.maxBuy = o.Price()
 	}
 	if o != nil && o.Kind() == trade.SELL && o.Price() < i.minSell {
 		i.minSell = o.Price()
 	}
-	return
+	return o, line, nil
+}
+
+// Returns the next non-blank line, a final line without a newline is returned without error
+func (i *ItchReader) readLine() (line string, err error) {
+	for {
+		line, err = i.r.ReadString('\n')
+		if line == "" && err != nil {
+			return "", err
+		}
+		i.lineCount++
+		if strings.TrimSpace(line) != "" {
+			return line, nil
+		}
+	}
 }
 
 func (i *ItchReader) ReadAll() (orders []*trade.Order, err error) {
@@ -70,6 +117,16 @@ func (i *ItchReader) LineCount() uint {
 	return i.lineCount
 }
 
+// The number of malformed lines skipped under the SKIP_ERRORS policy
+func (i *ItchReader) Skipped() uint {
+	return i.skipped
+}
+
+// The most recent malformed line skipped under the SKIP_ERRORS policy, nil if none have been skipped
+func (i *ItchReader) LastSkipped() *ParseError {
+	return i.lastErr
+}
+
 func (i *ItchReader) MaxBuy() int64 {
 	return i.maxBuy
 }
@@ -79,45 +136,46 @@ func (i *ItchReader) MinSell() int64 {
 }
 
 func mkOrder(line string) (o *trade.Order, err error) {
-	ss := strings.Split(line, " ")
-	var useful []string
-	for _, w := range ss {
-		if w != "" && w != "\n" {
-			useful = append(useful, w)
-		}
+	useful := strings.Fields(line)
+	if len(useful) < 4 {
+		return nil, ErrShortLine
+	}
+	switch useful[3] {
+	case "B", "S", "D":
+	default:
+		return nil, nil
+	}
+	if len(useful) < 6 {
+		return nil, ErrShortLine
 	}
 	cd, td, err := mkData(useful)
 	if err != nil {
-		return
+		return nil, err
 	}
 	switch useful[3] {
 	case "B":
-		o = trade.NewBuy(cd, td)
-		return
+		return trade.NewBuy(cd, td), nil
 	case "S":
-		o = trade.NewSell(cd, td)
-		return
-	case "D":
-		o = trade.NewCancel(td)
-		return
+		return trade.NewSell(cd, td), nil
 	default:
-		return
+		return trade.NewCancel(td), nil
 	}
-	panic("Unreachable")
 }
 
 func mkData(useful []string) (cd trade.CostData, td trade.TradeData, err error) {
-	//      print("ID: ", useful[2], " Type: ", useful[3], " Price: ",  useful[4], " Amount: ", useful[5])
-	//      println()
-	var price, amount, traderId, tradeId int
-	amount, err = strconv.Atoi(useful[4])
-	price, err = strconv.Atoi(useful[5])
-	traderId, err = strconv.Atoi(useful[2])
-	tradeId, err = strconv.Atoi(useful[2])
+	amount, err := strconv.ParseUint(useful[4], 10, 32)
+	if err != nil {
+		return
+	}
+	price, err := strconv.ParseInt(useful[5], 10, 64)
+	if err != nil {
+		return
+	}
+	id, err := strconv.ParseUint(useful[2], 10, 32)
 	if err != nil {
 		return
 	}
-	cd = trade.CostData{Price: int64(price), Amount: uint32(amount)}
-	td = trade.TradeData{TraderId: uint32(traderId), TradeId: uint32(tradeId), StockId: uint32(1)}
+	cd = trade.CostData{Price: price, Amount: uint32(amount)}
+	td = trade.TradeData{TraderId: uint32(id), TradeId: uint32(id), StockId: uint32(1)}
 	return
 }
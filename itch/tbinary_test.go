@@ -0,0 +1,153 @@
+package itch
+
+import (
+	"bytes"
+	"github.com/fmstephe/matching_engine/trade"
+	"io"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	if err := e.SetSymbol(7, "AAPL"); err != nil {
+		t.Fatal(err)
+	}
+	e.SetTime(34200000000000)
+	check(t, e.SystemEvent('Q'))
+	check(t, e.AddOrder(7, 101, trade.BUY, 100, 1500))
+	check(t, e.AddOrder(7, 102, trade.SELL, 50, 1510))
+	check(t, e.Executed(7, 101, 40, 1))
+	check(t, e.Cancel(7, 101, 10))
+	check(t, e.Replace(7, 102, 103, 60, 1505))
+	check(t, e.Delete(7, 103))
+	check(t, e.Trade(7, 0, trade.BUY, 20, 1502, 2))
+	r := NewBinaryReader(&buf)
+	symbol := padSymbol("AAPL")
+	expected := []Message{
+		{Type: SystemEvent, EventCode: 'Q'},
+		{Type: AddOrder, Ref: 101, Side: trade.BUY, Shares: 100, Symbol: symbol, Price: 1500},
+		{Type: AddOrder, Ref: 102, Side: trade.SELL, Shares: 50, Symbol: symbol, Price: 1510},
+		{Type: OrderExecuted, Ref: 101, Shares: 40, Match: 1},
+		{Type: OrderCancel, Ref: 101, Shares: 10},
+		{Type: OrderReplace, Ref: 102, NewRef: 103, Shares: 60, Price: 1505},
+		{Type: OrderDelete, Ref: 103},
+		{Type: NonCrossTrade, Side: trade.BUY, Shares: 20, Symbol: symbol, Price: 1502, Match: 2},
+	}
+	offset := int64(0)
+	for _, exp := range expected {
+		m, err := r.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.Offset != offset {
+			t.Errorf("Expecting offset %d, got %d instead", offset, m.Offset)
+		}
+		offset += int64(lengthPrefixLen + messageLens[exp.Type])
+		if exp.Type != SystemEvent {
+			exp.Locate = 7
+		}
+		exp.Timestamp = 34200000000000
+		checkMessage(t, m, &exp)
+	}
+	if _, err := r.Next(); err != io.EOF {
+		t.Errorf("Expecting EOF, got %v instead", err)
+	}
+}
+
+func TestOrderData(t *testing.T) {
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	check(t, e.AddOrder(3, 1<<33+5, trade.SELL, 10, 99))
+	check(t, e.Delete(3, 1<<33+5))
+	r := NewBinaryReader(&buf)
+	od := &trade.OrderData{}
+	m, _ := r.Next()
+	if !m.OrderData(od) {
+		t.Fatalf("No order data for %c", m.Type)
+	}
+	expected := trade.OrderData{Price: 99, Guid: 1<<33 + 5, Amount: 10, StockId: 3, Kind: trade.SELL}
+	if *od != expected {
+		t.Errorf("Expecting %v, got %v instead", expected, *od)
+	}
+	m, _ = r.Next()
+	m.OrderData(od)
+	expected = trade.OrderData{Guid: 1<<33 + 5, StockId: 3, Kind: trade.CANCEL}
+	if *od != expected {
+		t.Errorf("Expecting %v, got %v instead", expected, *od)
+	}
+}
+
+func TestFormatError(t *testing.T) {
+	b := []byte{0, 12, OrderDelete, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	r := NewBinaryReader(bytes.NewReader(b))
+	if _, err := r.Next(); err == nil {
+		t.Errorf("Expecting format error")
+	}
+	r = NewBinaryReader(bytes.NewReader(b[:8]))
+	if _, err := r.Next(); err != io.ErrUnexpectedEOF {
+		t.Errorf("Expecting unexpected EOF, got %v instead", err)
+	}
+}
+
+// A message too short for a header is skipped, reading carries on from the next message
+func TestShortMessage(t *testing.T) {
+	var buf bytes.Buffer
+	buf.Write([]byte{0, 3, OrderDelete, 0, 1})
+	e := NewEncoder(&buf)
+	check(t, e.Delete(1, 9))
+	r := NewBinaryReader(&buf)
+	if _, err := r.Next(); err == nil {
+		t.Errorf("Expecting format error")
+	}
+	m, err := r.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkMessage(t, m, &Message{Type: OrderDelete, Locate: 1, Ref: 9})
+	if m.Offset != 5 {
+		t.Errorf("Expecting offset %d, got %d instead", 5, m.Offset)
+	}
+}
+
+func TestUnknownSide(t *testing.T) {
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	check(t, e.AddOrder(1, 9, trade.BUY, 10, 100))
+	buf.Bytes()[lengthPrefixLen+19] = 'X'
+	_, err := NewBinaryReader(&buf).Next()
+	if _, ok := err.(*SideError); !ok {
+		t.Errorf("Expecting a side error, got %v instead", err)
+	}
+}
+
+func TestNextAllocs(t *testing.T) {
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	for i := 0; i < 1000; i++ {
+		check(t, e.AddOrder(1, uint64(i), trade.BUY, 10, 100))
+		check(t, e.Executed(1, uint64(i), 10, uint64(i)))
+	}
+	r := NewBinaryReader(bytes.NewReader(buf.Bytes()))
+	allocs := testing.AllocsPerRun(1000, func() {
+		if _, err := r.Next(); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("Expecting %d allocations, got %v instead", 0, allocs)
+	}
+}
+
+func checkMessage(t *testing.T, m, exp *Message) {
+	exp.Offset = m.Offset
+	if *m != *exp {
+		t.Errorf("Expecting %+v, got %+v instead", *exp, *m)
+	}
+}
+
+func check(t *testing.T, err error) {
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,77 @@
+package itch
+
+import (
+	"bytes"
+	"github.com/fmstephe/matching_engine/trade"
+	"io"
+	"math"
+	"testing"
+)
+
+func TestWriteDelta(t *testing.T) {
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	deltas := []trade.Delta{
+		{Kind: trade.ADD, Guid: 11, Side: trade.BUY, StockId: 4, Price: 20, Amount: 5},
+		{Kind: trade.LEVEL, Side: trade.BUY, StockId: 4, Price: 20, Amount: 5},
+		{Kind: trade.EXECUTE, Guid: 11, Side: trade.BUY, StockId: 4, Price: 20, Amount: 2},
+		{Kind: trade.EXECUTE, Guid: 11, Side: trade.BUY, StockId: 4, Price: 20, Amount: 1},
+		{Kind: trade.DELETE, Guid: 11, Side: trade.BUY, StockId: 4, Price: 20, Amount: 2},
+	}
+	for i := range deltas {
+		check(t, e.WriteDelta(&deltas[i]))
+	}
+	// Stocks without a symbol are written using their decimal stock id
+	expected := []Message{
+		{Type: AddOrder, Locate: 4, Ref: 11, Side: trade.BUY, Shares: 5, Symbol: padSymbol("4"), Price: 20},
+		{Type: OrderExecuted, Locate: 4, Ref: 11, Shares: 2, Match: 1},
+		{Type: OrderExecuted, Locate: 4, Ref: 11, Shares: 1, Match: 2},
+		{Type: OrderDelete, Locate: 4, Ref: 11},
+	}
+	r := NewBinaryReader(&buf)
+	for _, exp := range expected {
+		m, err := r.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkMessage(t, m, &exp)
+	}
+	if _, err := r.Next(); err != io.EOF {
+		t.Errorf("Expecting EOF, got %v instead", err)
+	}
+}
+
+// Nothing is written for a message which can't be represented
+func TestEncoderErrors(t *testing.T) {
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	if err := e.SetSymbol(1, "TOOLONGSYM"); err == nil {
+		t.Errorf("Expecting an error for a symbol longer than %d characters", symbolLen)
+	}
+	if err := e.AddOrder(math.MaxUint16+1, 1, trade.BUY, 1, 1); err == nil {
+		t.Errorf("Expecting an error for stock id %d", math.MaxUint16+1)
+	}
+	if err := e.Delete(math.MaxUint32, 1); err == nil {
+		t.Errorf("Expecting an error for stock id %d", uint32(math.MaxUint32))
+	}
+	if err := e.AddOrder(1, 1, trade.BUY, 1, -1); err == nil {
+		t.Errorf("Expecting an error for price %d", -1)
+	}
+	if err := e.Replace(1, 1, 2, 1, math.MaxUint32+1); err == nil {
+		t.Errorf("Expecting an error for price %d", int64(math.MaxUint32+1))
+	}
+	if err := e.WriteDelta(&trade.Delta{Kind: 99}); err == nil {
+		t.Errorf("Expecting an error for delta kind %d", 99)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("Expecting nothing written, got %d bytes instead", buf.Len())
+	}
+	check(t, e.Delete(math.MaxUint16, 1))
+	m, err := NewBinaryReader(&buf).Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Locate != math.MaxUint16 {
+		t.Errorf("Expecting locate %d, got %d instead", math.MaxUint16, m.Locate)
+	}
+}
@@ -0,0 +1,73 @@
+package itch
+
+import (
+	"github.com/fmstephe/matching_engine/trade"
+	"io"
+	"strings"
+	"testing"
+)
+
+const readerInput = `time x id type amount price
+1 x 10 B 5 100
+
+2 x 11 S 3 99
+3 x 12 B 4
+4 x 13 Q
+5 x 14 S 4 abc
+6 x 10 D 0 0`
+
+func TestReaderFail(t *testing.T) {
+	ir, err := NewItchReader(strings.NewReader(readerInput))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRead(t, ir, trade.BUY, 10, 2)
+	checkRead(t, ir, trade.SELL, 11, 4)
+	_, _, err = ir.ReadOrder()
+	perr, ok := err.(*ParseError)
+	if !ok {
+		t.Fatalf("Expecting *ParseError, got %v instead", err)
+	}
+	if perr.Line != 5 || perr.Err != ErrShortLine || perr.Text != "3 x 12 B 4" {
+		t.Errorf("Unexpected parse error %s", perr.Error())
+	}
+}
+
+func TestReaderSkip(t *testing.T) {
+	ir, err := NewItchReader(strings.NewReader(readerInput))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ir.SetPolicy(SKIP_ERRORS)
+	checkRead(t, ir, trade.BUY, 10, 2)
+	checkRead(t, ir, trade.SELL, 11, 4)
+	// An ignored message type produces a nil order
+	o, _, err := ir.ReadOrder()
+	if o != nil || err != nil {
+		t.Errorf("Expecting nil order and error, got %v and %v instead", o, err)
+	}
+	checkRead(t, ir, trade.CANCEL, 10, 8)
+	if ir.Skipped() != 2 || ir.LastSkipped().Line != 7 {
+		t.Errorf("Expecting 2 skipped lines, last on line 7, got %d and %v instead", ir.Skipped(), ir.LastSkipped())
+	}
+	if _, _, err := ir.ReadOrder(); err != io.EOF {
+		t.Errorf("Expecting EOF, got %v instead", err)
+	}
+}
+
+func TestReaderEmpty(t *testing.T) {
+	if _, err := NewItchReader(strings.NewReader("")); err != io.EOF {
+		t.Errorf("Expecting EOF, got %v instead", err)
+	}
+}
+
+func checkRead(t *testing.T, ir *ItchReader, kind trade.OrderKind, traderId uint32, line uint) {
+	o, _, err := ir.ReadOrder()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if o.Kind() != kind || o.TraderId() != traderId || ir.LineCount() != line {
+		t.Errorf("Expecting %s from trader %d on line %d, got %s on line %d instead", kind, traderId, line, o, ir.LineCount())
+	}
+}
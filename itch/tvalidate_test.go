@@ -0,0 +1,103 @@
+package itch
+
+import (
+	"bytes"
+	"github.com/fmstephe/matching_engine/trade"
+	"io"
+	"testing"
+)
+
+func TestValidatorClean(t *testing.T) {
+	v := validate(t, func(e *Encoder) {
+		check(t, e.AddOrder(1, 1, trade.BUY, 100, 10))
+		check(t, e.AddOrder(1, 2, trade.BUY, 100, 10))
+		check(t, e.AddOrder(1, 3, trade.SELL, 100, 12))
+		check(t, e.Executed(1, 1, 100, 1))
+		check(t, e.Executed(1, 2, 50, 2))
+		check(t, e.Cancel(1, 2, 10))
+		check(t, e.Replace(1, 3, 4, 20, 11))
+		check(t, e.Delete(1, 4))
+	})
+	for _, m := range v.Mismatches() {
+		t.Errorf("Unexpected mismatch %s", m.String())
+	}
+	b := v.Book(1)
+	if b.Size() != 1 || b.PeekBuy().Amount() != 40 || b.PeekSell() != nil {
+		t.Errorf("Unexpected book, size %d, best buy %s", b.Size(), b.PeekBuy())
+	}
+	if v.Executions() != 2 {
+		t.Errorf("Expecting 2 executions, got %d instead", v.Executions())
+	}
+}
+
+func TestValidatorMismatches(t *testing.T) {
+	v := validate(t, func(e *Encoder) {
+		check(t, e.AddOrder(1, 1, trade.BUY, 100, 10))
+		check(t, e.AddOrder(1, 2, trade.BUY, 100, 10))
+		check(t, e.Executed(1, 2, 100, 1))             // Out of time priority
+		check(t, e.AddOrder(1, 3, trade.SELL, 100, 9)) // Crosses
+		check(t, e.Delete(1, 99))                      // Unknown
+	})
+	mismatches := v.Mismatches()
+	expected := []struct {
+		msgType byte
+		ref     uint64
+	}{{OrderExecuted, 2}, {AddOrder, 3}, {OrderDelete, 99}}
+	if len(mismatches) != len(expected) {
+		t.Fatalf("Expecting %d mismatches, got %v", len(expected), mismatches)
+	}
+	for i, exp := range expected {
+		if mismatches[i].Type != exp.msgType || mismatches[i].Ref != exp.ref {
+			t.Errorf("Expecting mismatch on %c %d, got %s", exp.msgType, exp.ref, mismatches[i].String())
+		}
+	}
+}
+
+func TestValidatorExecutedPrice(t *testing.T) {
+	v := validate(t, func(e *Encoder) {
+		check(t, e.AddOrder(1, 1, trade.SELL, 100, 10))
+		check(t, e.AddOrder(1, 2, trade.BUY, 100, 8))
+		check(t, e.ExecutedAtPrice(1, 1, 50, 1, true, 11))
+		check(t, e.ExecutedAtPrice(1, 1, 10, 2, true, 9)) // Below the sell's limit
+	})
+	mismatches := v.Mismatches()
+	if len(mismatches) != 1 || mismatches[0].Type != ExecutedPrice || mismatches[0].Offset == 0 {
+		t.Fatalf("Expecting one mismatch on the second execution, got %v instead", mismatches)
+	}
+	if v.Book(1).PeekSell().Amount() != 40 {
+		t.Errorf("Expecting 40 shares resting, got %d instead", v.Book(1).PeekSell().Amount())
+	}
+}
+
+// A partial cancel leaves an order's priority unchanged, in the engine as well as the exchange's book
+func TestValidatorCancelPriority(t *testing.T) {
+	v := validate(t, func(e *Encoder) {
+		check(t, e.AddOrder(1, 1, trade.BUY, 100, 8))
+		check(t, e.AddOrder(1, 2, trade.BUY, 100, 8))
+		check(t, e.Cancel(1, 1, 60))
+		check(t, e.Executed(1, 1, 40, 1))
+		check(t, e.Cancel(1, 2, 100))
+		check(t, e.AddOrder(1, 3, trade.SELL, 10, 8)) // Crosses nothing, the engine's book is empty too
+	})
+	for _, m := range v.Mismatches() {
+		t.Errorf("Unexpected mismatch %s", m.String())
+	}
+	if v.Book(1).Size() != 1 {
+		t.Errorf("Expecting 1 resting order, got %d instead", v.Book(1).Size())
+	}
+}
+
+func validate(t *testing.T, write func(e *Encoder)) *Validator {
+	var buf bytes.Buffer
+	write(NewEncoder(&buf))
+	r := NewBinaryReader(&buf)
+	v := NewValidator(10)
+	for {
+		m, err := r.Next()
+		if err == io.EOF {
+			return v
+		}
+		check(t, err)
+		v.Apply(m)
+	}
+}
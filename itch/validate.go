@@ -0,0 +1,228 @@
+package itch
+
+import (
+	"fmt"
+	"github.com/fmstephe/matching_engine/cbuf"
+	"github.com/fmstephe/matching_engine/matcher"
+	"github.com/fmstephe/matching_engine/trade"
+	"math"
+)
+
+// Describes a point where the exchange's feed disagrees with the engine's matching
+type Mismatch struct {
+	Offset int64 // Offset of the offending message
+	Type   byte
+	Locate uint16
+	Ref    uint64
+	Reason string
+}
+
+func (m *Mismatch) String() string {
+	return fmt.Sprintf("offset %d, message %c, locate %d, ref %d: %s", m.Offset, m.Type, m.Locate, m.Ref, m.Reason)
+}
+
+// The trader id of the orders the validator submits to take the liquidity an execution reports
+const aggressorTrader = math.MaxUint32
+
+// A trade the engine made against a resting order
+type fill struct {
+	guid   int64
+	amount uint32
+	price  int64
+}
+
+// Rebuilds the book of every stock from an ITCH feed using trade.MatchTrees, and feeds the same order flow
+// through a matcher.M for each stock. Each reported execution is replayed against the matcher as an order
+// taking exactly that liquidity, priced so the engine's midpoint would be the reported price, and the
+// resting orders, amounts and prices the engine trades with are compared with the execution. An added
+// order the engine would have traded is also a mismatch. Once a stock has a mismatch its engine book may
+// differ from the exchange's, so later mismatches for that stock may follow from the first.
+type Validator struct {
+	books      map[uint16]*trade.MatchTrees
+	engines    map[uint16]*matcher.M
+	slab       *trade.Slab
+	rb         *cbuf.Response
+	od         trade.OrderData
+	cancel     trade.OrderData
+	fills      []fill
+	lastTrade  uint32
+	mismatches []Mismatch
+	executions int
+}
+
+func NewValidator(slabSize int) *Validator {
+	v := &Validator{books: make(map[uint16]*trade.MatchTrees), engines: make(map[uint16]*matcher.M)}
+	v.slab = trade.NewSlab(slabSize)
+	v.rb = cbuf.New(1024)
+	return v
+}
+
+func (v *Validator) Mismatches() []Mismatch {
+	return v.mismatches
+}
+
+func (v *Validator) Executions() int {
+	return v.executions
+}
+
+// Returns the rebuilt book for locate, nil if no orders have been seen for that stock
+func (v *Validator) Book(locate uint16) *trade.MatchTrees {
+	return v.books[locate]
+}
+
+func (v *Validator) Apply(m *Message) {
+	switch m.Type {
+	case AddOrder, AddOrderMPID:
+		m.OrderData(&v.od)
+		v.add(m, &v.od)
+	case OrderExecuted, ExecutedPrice:
+		v.executions++
+		o := v.get(m)
+		if o == nil {
+			return
+		}
+		price := o.Price()
+		if m.Type == ExecutedPrice {
+			price = int64(m.Price)
+		}
+		v.execute(m, o, price)
+		v.reduce(m, v.book(m.Locate), o, m.Shares)
+	case OrderCancel:
+		if o := v.get(m); o != nil {
+			v.reduce(m, v.book(m.Locate), o, m.Shares)
+		}
+	case OrderDelete:
+		if o := v.get(m); o != nil {
+			m.OrderData(&v.cancel)
+			v.submit(m.Locate, &v.cancel)
+			v.slab.Free(v.book(m.Locate).Cancel(o))
+		}
+	case OrderReplace:
+		o := v.get(m)
+		if o == nil {
+			return
+		}
+		m.ReplaceData(o.Kind(), &v.cancel, &v.od)
+		v.submit(m.Locate, &v.cancel)
+		v.slab.Free(v.book(m.Locate).Cancel(o))
+		v.add(m, &v.od)
+	}
+}
+
+func (v *Validator) add(m *Message, od *trade.OrderData) {
+	b := v.book(m.Locate)
+	if b.Get(od.Guid) != nil {
+		v.mismatch(m, "order reference is already in use")
+		return
+	}
+	v.submit(m.Locate, od)
+	for _, f := range v.fills {
+		v.mismatch(m, fmt.Sprintf("engine traded %d shares with ref %d at %d", f.amount, f.guid, f.price))
+	}
+	o := v.slab.Malloc()
+	o.CopyFrom(od)
+	if od.Kind == trade.BUY {
+		b.PushBuy(o)
+	} else {
+		b.PushSell(o)
+	}
+}
+
+// Submits an order taking m.Shares from the resting order o at price, the engine trades at the midpoint
+// of the two orders' prices. Compares the engine's trades with the execution m reports.
+func (v *Validator) execute(m *Message, o *trade.Order, price int64) {
+	kind := trade.BUY
+	if o.Kind() == trade.BUY {
+		kind = trade.SELL
+	}
+	v.lastTrade++
+	td := trade.TradeData{TraderId: aggressorTrader, TradeId: v.lastTrade, StockId: uint32(m.Locate)}
+	v.od.Write(trade.CostData{Price: max(2*price-o.Price(), 1), Amount: m.Shares}, td, kind)
+	v.submit(m.Locate, &v.od)
+	filled := uint32(0)
+	for _, f := range v.fills {
+		filled += f.amount
+		if f.guid != o.Guid() || f.price != price {
+			v.mismatch(m, fmt.Sprintf("engine traded %d shares with ref %d at %d, reported %d shares at %d", f.amount, f.guid, f.price, m.Shares, price))
+		}
+	}
+	if filled < m.Shares {
+		v.mismatch(m, fmt.Sprintf("engine traded %d shares, reported %d shares", filled, m.Shares))
+		// Nothing the feed reports can trade with what is left
+		v.cancel.Write(trade.CostData{}, td, trade.CANCEL)
+		v.submit(m.Locate, &v.cancel)
+	}
+}
+
+// Submits od to the engine for locate, collecting the trades it makes against resting orders in v.fills
+func (v *Validator) submit(locate uint16, od *trade.OrderData) {
+	e := v.engine(locate)
+	v.fills = v.fills[:0]
+	if err := e.Submit(od); err != nil {
+		panic(err.Error())
+	}
+	for {
+		pending := e.Flush()
+		for r, err := v.rb.GetForRead(); err == nil; r, err = v.rb.GetForRead() {
+			guid := int64(r.TraderId)<<32 | int64(r.TradeId)
+			if (r.Kind == trade.PARTIAL || r.Kind == trade.FULL) && guid != od.Guid {
+				price := r.Price
+				if price < 0 {
+					price = -price
+				}
+				v.fills = append(v.fills, fill{guid: guid, amount: r.Amount, price: price})
+			}
+		}
+		if pending == 0 {
+			return
+		}
+	}
+}
+
+func (v *Validator) reduce(m *Message, b *trade.MatchTrees, o *trade.Order, shares uint32) {
+	if shares > o.Amount() {
+		v.mismatch(m, fmt.Sprintf("%d shares removed from order (%s)", shares, o))
+		shares = o.Amount()
+	}
+	if m.Type == OrderCancel && !v.engine(m.Locate).Reduce(o.Guid(), shares) {
+		v.cancel.Write(trade.CostData{}, trade.TradeData{TraderId: o.TraderId(), TradeId: o.TradeId(), StockId: uint32(m.Locate)}, trade.CANCEL)
+		v.submit(m.Locate, &v.cancel)
+	}
+	if shares == o.Amount() {
+		v.slab.Free(b.Cancel(o))
+		return
+	}
+	b.Reduce(o, shares)
+}
+
+func (v *Validator) get(m *Message) *trade.Order {
+	o := v.book(m.Locate).Get(int64(m.Ref))
+	if o == nil {
+		v.mismatch(m, "unknown order reference")
+	}
+	return o
+}
+
+func (v *Validator) book(locate uint16) *trade.MatchTrees {
+	b := v.books[locate]
+	if b == nil {
+		b = &trade.MatchTrees{}
+		v.books[locate] = b
+	}
+	return b
+}
+
+// Every engine shares the validator's slab and response buffer, responses are read after each order
+func (v *Validator) engine(locate uint16) *matcher.M {
+	e := v.engines[locate]
+	if e == nil {
+		e = matcher.NewMatcherFromSlab(v.slab, v.rb)
+		e.SetOverflowPolicy(matcher.OVERFLOW_SPILL)
+		v.engines[locate] = e
+	}
+	return e
+}
+
+func (v *Validator) mismatch(m *Message, reason string) {
+	v.mismatches = append(v.mismatches, Mismatch{Offset: m.Offset, Type: m.Type, Locate: m.Locate, Ref: m.Ref, Reason: reason})
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"compress/gzip"
+	"flag"
+	"github.com/fmstephe/fstrconv"
+	"github.com/fmstephe/matching_engine/itch"
+	"io"
+	"log"
+	"os"
+	"strings"
+)
+
+var (
+	filePath = flag.String("f", "", "Relative path to an ITCH 5.0 binary file, may be gzipped")
+	maxPrint = flag.Int("n", 100, "The maximum number of mismatches to print")
+	slabSize = flag.Int("s", 1000*1000, "The number of orders to preallocate")
+)
+
+func main() {
+	flag.Parse()
+	f, err := os.Open(*filePath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	var in io.Reader = f
+	if strings.HasSuffix(*filePath, ".gz") {
+		if in, err = gzip.NewReader(f); err != nil {
+			log.Fatal(err)
+		}
+	}
+	r := itch.NewBinaryReader(in)
+	v := itch.NewValidator(*slabSize)
+	messages := int64(0)
+	for {
+		m, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		messages++
+		v.Apply(m)
+	}
+	mismatches := v.Mismatches()
+	for i := range mismatches {
+		if i == *maxPrint {
+			println("...")
+			break
+		}
+		println(mismatches[i].String())
+	}
+	println("Messages   ", fstrconv.Itoa64Comma(messages))
+	println("Executions ", fstrconv.Itoa64Comma(int64(v.Executions())))
+	println("Mismatches ", fstrconv.Itoa64Comma(int64(len(mismatches))))
+	if len(mismatches) > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"github.com/fmstephe/fstrconv"
+	"github.com/fmstephe/matching_engine/cbuf"
+	"github.com/fmstephe/matching_engine/itch"
+	"github.com/fmstephe/matching_engine/matcher"
+	"github.com/fmstephe/matching_engine/trade"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var (
+	filePath = flag.String("f", "", "Relative path to an ITCH file to read")
+	line     = flag.Uint("l", 0, "First line to break on, execution continues until line l is reached")
+	depth    = flag.Int("d", 5, "The number of price levels printed on each side of the book")
+	slabSize = flag.Int("s", 10000, "The number of orders to preallocate")
+	bufSize  = flag.Int("b", 10000, "The size of the response buffer, the most responses a single order can produce")
+)
+
+const help = `Commands
+  s, <enter>      step to the next order
+  c               continue to the next breakpoint
+  c <line>        continue to line
+  b guid <n>      break on orders with guid n
+  b price <n>     break on orders with price n
+  b trader <n>    break on orders from trader n
+  d               delete all breakpoints
+  p               print the top of the book
+  depth <n>       print n levels of the book
+  r               print the responses to the last order
+  q               quit`
+
+type breakpoints struct {
+	guids   map[int64]bool
+	prices  map[int64]bool
+	traders map[uint32]bool
+}
+
+func newBreakpoints() *breakpoints {
+	return &breakpoints{guids: make(map[int64]bool), prices: make(map[int64]bool), traders: make(map[uint32]bool)}
+}
+
+func (b *breakpoints) hit(o *trade.Order) bool {
+	return b.guids[o.Guid()] || b.prices[o.Price()] || b.traders[o.TraderId()]
+}
+
+type debugger struct {
+	ir        *itch.ItchReader
+	m         *matcher.M
+	rb        *cbuf.Response
+	in        *bufio.Reader
+	bps       *breakpoints
+	order     *trade.Order
+	od        trade.OrderData
+	responses []trade.Response
+	untilLine uint // Continue until this line is reached
+	running   bool // Continue until a breakpoint is hit
+}
+
+func main() {
+	flag.Parse()
+	f, err := os.Open(*filePath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	ir, err := itch.NewItchReader(f)
+	if err != nil {
+		log.Fatal(err)
+	}
+	ir.SetPolicy(itch.SKIP_ERRORS)
+	rb := cbuf.New(*bufSize)
+	d := &debugger{
+		ir:        ir,
+		m:         matcher.NewMatcher(*slabSize, rb),
+		rb:        rb,
+		in:        bufio.NewReader(os.Stdin),
+		bps:       newBreakpoints(),
+		untilLine: *line,
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			println(fmt.Sprintf("Panic at line %d", d.ir.LineCount()))
+			panic(r)
+		}
+	}()
+	d.loop()
+}
+
+func (d *debugger) loop() {
+	for {
+		o, _, err := d.ir.ReadOrder()
+		if err != nil {
+			println(err.Error())
+			return
+		}
+		if o == nil {
+			continue
+		}
+		d.submit(o)
+		if d.ir.LineCount() < d.untilLine {
+			continue
+		}
+		if d.running && !d.bps.hit(o) {
+			continue
+		}
+		d.running = false
+		d.printOrder()
+		if !d.prompt() {
+			return
+		}
+	}
+}
+
+func (d *debugger) submit(o *trade.Order) {
+	d.order = o
+	d.od.Write(trade.CostData{Price: o.Price(), Amount: o.Amount()}, trade.TradeData{TraderId: o.TraderId(), TradeId: o.TradeId(), StockId: o.StockId()}, o.Kind())
+	d.m.Submit(&d.od)
+	d.responses = d.responses[:0]
+	for d.rb.Reads() < d.rb.Writes() {
+		r, err := d.rb.GetForRead()
+		if err != nil {
+			panic(err.Error())
+		}
+		d.responses = append(d.responses, *r)
+	}
+}
+
+// Reads commands until execution should resume, returns false if the debugger should quit
+func (d *debugger) prompt() bool {
+	for {
+		print("> ")
+		cmd, err := d.in.ReadString('\n')
+		if err != nil {
+			println(err.Error())
+			return false
+		}
+		args := strings.Fields(cmd)
+		if len(args) == 0 {
+			return true
+		}
+		switch {
+		case args[0] == "s":
+			return true
+		case args[0] == "c" && len(args) == 1:
+			d.running = true
+			return true
+		case args[0] == "c":
+			l, err := strconv.ParseUint(args[1], 10, 32)
+			if err != nil {
+				println(err.Error())
+				continue
+			}
+			d.untilLine = uint(l)
+			return true
+		case args[0] == "b" && len(args) == 3:
+			d.addBreakpoint(args[1], args[2])
+		case args[0] == "d":
+			d.bps = newBreakpoints()
+		case args[0] == "p":
+			d.printBook(1)
+		case args[0] == "depth" && len(args) == 2:
+			n, err := strconv.Atoi(args[1])
+			if err != nil {
+				println(err.Error())
+				continue
+			}
+			d.printBook(n)
+		case args[0] == "r":
+			d.printResponses()
+		case args[0] == "q":
+			return false
+		default:
+			println(help)
+		}
+	}
+}
+
+func (d *debugger) addBreakpoint(kind, val string) {
+	n, err := strconv.ParseInt(val, 10, 64)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+	switch kind {
+	case "guid":
+		d.bps.guids[n] = true
+	case "price":
+		d.bps.prices[n] = true
+	case "trader":
+		d.bps.traders[uint32(n)] = true
+	default:
+		println(help)
+	}
+}
+
+func (d *debugger) printOrder() {
+	println("Order       ", d.order.String())
+	println("Line        ", d.ir.LineCount())
+	println("Max Buy     ", fstrconv.Itoa64Delim(d.ir.MaxBuy(), ','))
+	println("Min Sell    ", fstrconv.Itoa64Delim(d.ir.MinSell(), ','))
+	println("Responses   ", len(d.responses))
+	println("Skipped     ", d.ir.Skipped())
+	println("Total       ", d.m.Size())
+	d.printBook(*depth)
+}
+
+func (d *debugger) printBook(n int) {
+	println("Buy Limits  ", formatLevels(d.m.BuyLevels(n)))
+	println("Sell Limits ", formatLevels(d.m.SellLevels(n)))
+	println()
+}
+
+func (d *debugger) printResponses() {
+	for i := range d.responses {
+		r := &d.responses[i]
+		println(fmt.Sprintf("%s, price %s, amount %d, trader %d, trade %d, counter party %d", r.Kind.String(), fstrconv.Itoa64Delim(r.Price, ','), r.Amount, r.TraderId, r.TradeId, r.CounterParty))
+	}
+}
+
+func formatLevels(levels []trade.Level) string {
+	var b bytes.Buffer
+	for _, l := range levels {
+		b.WriteString(fmt.Sprintf("(%s, %s)", fstrconv.Itoa64Delim(l.Price, ','), fstrconv.Itoa64Delim(int64(l.Size), ',')))
+		b.WriteString(", ")
+	}
+	return b.String()
+}
@@ -0,0 +1,192 @@
+package journal
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/fmstephe/matching_engine/trade"
+	"hash/crc32"
+	"io"
+)
+
+const (
+	magic     = "MEJN"
+	version   = uint32(2)
+	headerLen = 8
+	RecordLen = 48 // seq 8, recvTime 8, price 8, guid 8, amount 4, stockId 4, kind 4, crc 4
+	crcOffset = RecordLen - 4
+)
+
+var (
+	ErrHeader   = errors.New("Not a journal, or an unsupported journal version")
+	ErrChecksum = errors.New("Journal record checksum mismatch")
+	crcTable    = crc32.MakeTable(crc32.Castagnoli)
+)
+
+type SequenceError struct {
+	Expected, Found uint64
+}
+
+func (e *SequenceError) Error() string {
+	return fmt.Sprintf("Journal sequence gap, expected %d found %d", e.Expected, e.Found)
+}
+
+type syncer interface {
+	Sync() error
+}
+
+// Appends sequenced, checksummed trade.OrderData records. Each record is written with a single call to
+// Write, so it survives the death of the process once Append returns. Surviving the death of the machine
+// depends on the sync policy.
+type Writer struct {
+	w         io.Writer
+	s         syncer // nil if w can't be synced
+	syncEvery int
+	unsynced  int
+	held      bool // Syncs are put off until ReleaseSync
+	seq       uint64
+	buf       [RecordLen]byte
+}
+
+// Records are appended to w with sequence numbers following lastSeq. A journal header is written if lastSeq
+// is 0, otherwise w is assumed to be positioned at the end of an existing journal. If w has a Sync method
+// it will be called after every syncEvery records, a syncEvery of 0 never syncs.
+func NewWriter(w io.Writer, lastSeq uint64, syncEvery int) (*Writer, error) {
+	jw := &Writer{w: w, syncEvery: syncEvery, seq: lastSeq}
+	if s, ok := w.(syncer); ok {
+		jw.s = s
+	}
+	if lastSeq == 0 {
+		var h [headerLen]byte
+		copy(h[:], magic)
+		binary.LittleEndian.PutUint32(h[4:], version)
+		if _, err := w.Write(h[:]); err != nil {
+			return nil, err
+		}
+	}
+	return jw, nil
+}
+
+// The sequence number of the last record appended
+func (jw *Writer) Seq() uint64 {
+	return jw.seq
+}
+
+// Appends od with the next sequence number. An od which has already been sequenced must carry that
+// number, otherwise a *SequenceError is returned.
+func (jw *Writer) Append(od *trade.OrderData) error {
+	if od.Seq != 0 && od.Seq != jw.seq+1 {
+		return &SequenceError{Expected: jw.seq + 1, Found: od.Seq}
+	}
+	EncodeRecord(jw.buf[:], jw.seq+1, od)
+	if _, err := jw.w.Write(jw.buf[:]); err != nil {
+		return err
+	}
+	jw.seq++
+	jw.unsynced++
+	if !jw.held && jw.syncEvery > 0 && jw.unsynced >= jw.syncEvery {
+		return jw.Sync()
+	}
+	return nil
+}
+
+// Puts off syncing until ReleaseSync, so a batch of records costs at most one sync
+func (jw *Writer) HoldSync() {
+	jw.held = true
+}
+
+// Syncs once if the records appended since HoldSync were due a sync
+func (jw *Writer) ReleaseSync() error {
+	jw.held = false
+	if jw.syncEvery > 0 && jw.unsynced >= jw.syncEvery {
+		return jw.Sync()
+	}
+	return nil
+}
+
+func (jw *Writer) Sync() error {
+	jw.unsynced = 0
+	if jw.s == nil {
+		return nil
+	}
+	return jw.s.Sync()
+}
+
+// Encodes a single journal record into b, which must be at least RecordLen long
+func EncodeRecord(b []byte, seq uint64, od *trade.OrderData) {
+	binary.LittleEndian.PutUint64(b[0:], seq)
+	binary.LittleEndian.PutUint64(b[8:], uint64(od.RecvTime))
+	binary.LittleEndian.PutUint64(b[16:], uint64(od.Price))
+	binary.LittleEndian.PutUint64(b[24:], uint64(od.Guid))
+	binary.LittleEndian.PutUint32(b[32:], od.Amount)
+	binary.LittleEndian.PutUint32(b[36:], od.StockId)
+	binary.LittleEndian.PutUint32(b[40:], uint32(od.Kind))
+	binary.LittleEndian.PutUint32(b[crcOffset:], crc32.Checksum(b[:crcOffset], crcTable))
+}
+
+// Decodes a single journal record from b into od, returning its sequence number, which is also written to od.Seq
+func DecodeRecord(b []byte, od *trade.OrderData) (seq uint64, err error) {
+	if binary.LittleEndian.Uint32(b[crcOffset:]) != crc32.Checksum(b[:crcOffset], crcTable) {
+		return 0, ErrChecksum
+	}
+	seq = binary.LittleEndian.Uint64(b[0:])
+	od.Seq = seq
+	od.RecvTime = int64(binary.LittleEndian.Uint64(b[8:]))
+	od.Price = int64(binary.LittleEndian.Uint64(b[16:]))
+	od.Guid = int64(binary.LittleEndian.Uint64(b[24:]))
+	od.Amount = binary.LittleEndian.Uint32(b[32:])
+	od.StockId = binary.LittleEndian.Uint32(b[36:])
+	od.Kind = trade.OrderKind(binary.LittleEndian.Uint32(b[40:]))
+	return seq, nil
+}
+
+// Reads a journal from its beginning
+type Reader struct {
+	r      *bufio.Reader
+	buf    [RecordLen]byte
+	seq    uint64
+	offset int64
+}
+
+func NewReader(r io.Reader) (*Reader, error) {
+	jr := &Reader{r: bufio.NewReader(r)}
+	var h [headerLen]byte
+	if _, err := io.ReadFull(jr.r, h[:]); err != nil {
+		return nil, err
+	}
+	if string(h[:4]) != magic || binary.LittleEndian.Uint32(h[4:]) != version {
+		return nil, ErrHeader
+	}
+	jr.offset = headerLen
+	return jr, nil
+}
+
+// Reads the next record into od, returning its sequence number. At the end of the journal err is io.EOF.
+// A record which was only partly written, typically at the end of a journal when the process died, is
+// reported as io.ErrUnexpectedEOF.
+func (jr *Reader) Next(od *trade.OrderData) (seq uint64, err error) {
+	if _, err = io.ReadFull(jr.r, jr.buf[:]); err != nil {
+		return 0, err
+	}
+	if seq, err = DecodeRecord(jr.buf[:], od); err != nil {
+		return 0, err
+	}
+	if seq != jr.seq+1 {
+		return 0, &SequenceError{Expected: jr.seq + 1, Found: seq}
+	}
+	jr.seq = seq
+	jr.offset += RecordLen
+	return seq, nil
+}
+
+// The sequence number of the last record read
+func (jr *Reader) Seq() uint64 {
+	return jr.seq
+}
+
+// The offset immediately after the last valid record read. A journal with a damaged tail can be truncated
+// to this offset before appending to it again.
+func (jr *Reader) Offset() int64 {
+	return jr.offset
+}
@@ -0,0 +1,156 @@
+package journal
+
+import (
+	"bytes"
+	"github.com/fmstephe/matching_engine/trade"
+	"io"
+	"testing"
+)
+
+var tjournalOrderMaker = trade.NewOrderMaker()
+
+func TestRoundTrip(t *testing.T) {
+	orders, err := tjournalOrderMaker.RndTradeSet(100, 10, 1, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	writeAll(t, &buf, orders)
+	jr, err := NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	od := &trade.OrderData{}
+	for i := range orders {
+		seq, err := jr.Next(od)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seq != uint64(i+1) {
+			t.Errorf("Expecting sequence %d, got %d instead", i+1, seq)
+		}
+		expected := orders[i]
+		expected.Seq = seq
+		if *od != expected {
+			t.Errorf("Expecting %v, got %v instead", expected, *od)
+		}
+	}
+	if _, err := jr.Next(od); err != io.EOF {
+		t.Errorf("Expecting EOF, got %v instead", err)
+	}
+	if jr.Offset() != int64(headerLen+len(orders)*RecordLen) {
+		t.Errorf("Unexpected offset %d", jr.Offset())
+	}
+}
+
+func TestResume(t *testing.T) {
+	orders, _ := tjournalOrderMaker.RndTradeSet(10, 1, 1, 1000)
+	var buf bytes.Buffer
+	writeAll(t, &buf, orders[:5])
+	jw, err := NewWriter(&buf, 5, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 5; i < len(orders); i++ {
+		if err := jw.Append(&orders[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	jr, _ := NewReader(&buf)
+	od := &trade.OrderData{}
+	for range orders {
+		if _, err := jr.Next(od); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if jr.Seq() != uint64(len(orders)) {
+		t.Errorf("Expecting sequence %d, got %d instead", len(orders), jr.Seq())
+	}
+}
+
+func TestDamage(t *testing.T) {
+	orders, _ := tjournalOrderMaker.RndTradeSet(10, 1, 1, 1000)
+	var buf bytes.Buffer
+	writeAll(t, &buf, orders)
+	b := buf.Bytes()
+	// Torn tail
+	jr, _ := NewReader(bytes.NewReader(b[:len(b)-3]))
+	checkDamage(t, jr, len(orders)-1, io.ErrUnexpectedEOF)
+	// Corrupt record
+	corrupt := append([]byte(nil), b...)
+	corrupt[headerLen+RecordLen*2+9]++
+	jr, _ = NewReader(bytes.NewReader(corrupt))
+	checkDamage(t, jr, 2, ErrChecksum)
+	// Missing record
+	gap := append(append([]byte(nil), b[:headerLen+RecordLen]...), b[headerLen+RecordLen*2:]...)
+	jr, _ = NewReader(bytes.NewReader(gap))
+	od := &trade.OrderData{}
+	jr.Next(od)
+	if _, err := jr.Next(od); err == nil {
+		t.Errorf("Expecting sequence error")
+	} else if serr, ok := err.(*SequenceError); !ok || serr.Expected != 2 || serr.Found != 3 {
+		t.Errorf("Unexpected error %v", err)
+	}
+	// Not a journal
+	if _, err := NewReader(bytes.NewReader([]byte("NOTAJOURNAL"))); err != ErrHeader {
+		t.Errorf("Expecting header error, got %v instead", err)
+	}
+}
+
+func checkDamage(t *testing.T, jr *Reader, valid int, expected error) {
+	od := &trade.OrderData{}
+	for i := 0; i < valid; i++ {
+		if _, err := jr.Next(od); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := jr.Next(od); err != expected {
+		t.Errorf("Expecting %v, got %v instead", expected, err)
+	}
+	if jr.Offset() != int64(headerLen+valid*RecordLen) {
+		t.Errorf("Expecting offset %d, got %d instead", headerLen+valid*RecordLen, jr.Offset())
+	}
+}
+
+func writeAll(t *testing.T, w io.Writer, orders []trade.OrderData) {
+	jw, err := NewWriter(w, 0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range orders {
+		if err := jw.Append(&orders[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSequenced(t *testing.T) {
+	orders, _ := tjournalOrderMaker.RndTradeSet(10, 1, 1, 1000)
+	var buf bytes.Buffer
+	jw, _ := NewWriter(&buf, 0, 0)
+	for i := range orders {
+		orders[i].Seq = uint64(i + 1)
+		orders[i].RecvTime = int64(1000 + i)
+		if err := jw.Append(&orders[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A sequence number which doesn't follow the journal is rejected
+	od := orders[0]
+	od.Seq = 20
+	if _, ok := jw.Append(&od).(*SequenceError); !ok {
+		t.Errorf("Expecting a SequenceError")
+	}
+	jr, _ := NewReader(&buf)
+	for i := range orders {
+		if _, err := jr.Next(&od); err != nil {
+			t.Fatal(err)
+		}
+		if od != orders[i] {
+			t.Errorf("Expecting %v, got %v instead", orders[i], od)
+		}
+	}
+	if _, err := jr.Next(&od); err != io.EOF {
+		t.Errorf("Expecting EOF, got %v instead", err)
+	}
+}
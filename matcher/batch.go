@@ -0,0 +1,36 @@
+package matcher
+
+import (
+	"github.com/fmstephe/matching_engine/trade"
+)
+
+// Submits each order in ods in turn, writing the outcome of ods[i] to errs[i], which must be at least as
+// long as ods. Returns the number of orders applied. Responses are published, and the journal synced, once
+// for the whole batch. Each order is checked against the response buffer as it meets it, as if it had been
+// submitted on its own, so the overflow policy applies order by order. Under OVERFLOW_PANIC the orders ahead
+// of the one which doesn't fit have already been matched and journalled when it panics. If the journal
+// can't be synced the error of every order applied is a *SyncError.
+func (m *M) SubmitBatch(ods []trade.OrderData, errs []error) int {
+	if m.journal != nil {
+		m.journal.HoldSync()
+	}
+	accepted := 0
+	for i := range ods {
+		errs[i] = m.submit(&ods[i])
+		if errs[i] == nil {
+			accepted++
+		}
+	}
+	if m.journal != nil {
+		if err := m.journal.ReleaseSync(); err != nil {
+			serr := &SyncError{Err: err}
+			for i := range ods {
+				if errs[i] == nil {
+					errs[i] = serr
+				}
+			}
+		}
+	}
+	m.rb.Publish()
+	return accepted
+}
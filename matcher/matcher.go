@@ -3,23 +3,217 @@ package matcher
 import (
 	"fmt"
 	"github.com/fmstephe/matching_engine/cbuf"
+	"github.com/fmstephe/matching_engine/journal"
+	"github.com/fmstephe/matching_engine/stats"
 	"github.com/fmstephe/matching_engine/trade"
+	"io"
 )
 
+// Returned by Submit when an order has been journalled and matched but the journal couldn't be synced. Unlike
+// every other error from Submit the order has been applied, so it can't be submitted again.
+type SyncError struct {
+	Err error
+}
+
+func (e *SyncError) Error() string {
+	return "Journal sync failed: " + e.Err.Error()
+}
+
+func (e *SyncError) Unwrap() error {
+	return e.Err
+}
+
 type M struct {
 	matchTrees trade.MatchTrees // No constructor required
 	slab       *trade.Slab
-	rb         *cbuf.Response
+	rb         cbuf.ResponseWriter
+	db         *cbuf.Delta            // Optional market data feed
+	deltaSeqs  map[uint32]uint64      // Last delta sequence number for each stock
+	qb         *cbuf.Quote            // Optional top of book feed
+	quotes     map[uint32]trade.Quote // The last best bid and offer published to qb for each stock
+	tape       *stats.Tape            // Optional trade statistics
+	now        int64                  // The engine clock
+	journal    *journal.Writer        // Optional write-ahead journal
+	lastSeq    uint64                 // Sequence number of the last order submitted
+	digest     trade.Digest           // Rolling hash of every response written, see Digest
+	in         trade.OrderData        // The Seq and RecvTime of the order being submitted, every response is stamped with them
+	overflow   OverflowPolicy
+	spill      *spillWriter // Wraps rb when overflow is OVERFLOW_SPILL
+}
+
+// A matcher writing to a buffer which can wait for its readers defaults to OVERFLOW_BLOCK, otherwise it
+// defaults to OVERFLOW_PANIC.
+func NewMatcher(slabSize int, rb cbuf.ResponseWriter) *M {
+	return NewMatcherFromSlab(trade.NewSlab(slabSize), rb)
+}
+
+// Orders are allocated from slab, which may be shared by matchers running on the same goroutine
+func NewMatcherFromSlab(slab *trade.Slab, rb cbuf.ResponseWriter) *M {
+	m := &M{slab: slab, rb: rb, deltaSeqs: make(map[uint32]uint64), quotes: make(map[uint32]trade.Quote)}
+	if _, ok := rb.(cbuf.FreeWaiter); ok {
+		m.overflow = OVERFLOW_BLOCK
+	}
+	return m
+}
+
+// Every change to the resting book will be written to db. A nil db turns the feed off.
+func (m *M) SetDeltaBuffer(db *cbuf.Delta) {
+	m.db = db
+}
+
+// Each submitted order which changes the best bid or offer will write exactly one quote to qb.
+// A nil qb turns the feed off.
+func (m *M) SetQuoteBuffer(qb *cbuf.Quote) {
+	m.qb = qb
+}
+
+// Every trade will be recorded in tape. A nil tape turns statistics off.
+func (m *M) SetTape(tape *stats.Tape) {
+	m.tape = tape
+}
+
+// Sets the engine clock, trades are recorded in tape at the time of the last call to SetTime. Submitting an
+// order with a RecvTime also sets the clock, so sequenced orders replay with the times they were received.
+func (m *M) SetTime(now int64) {
+	m.now = now
+}
+
+// Every order submitted will be appended to j before it is processed. A nil j turns journalling off.
+func (m *M) SetJournal(j *journal.Writer) {
+	m.journal = j
+}
+
+// Submits every order in r without journalling them, rebuilding the book and response stream exactly as
+// they were when the journal was written. Orders already reflected in a restored snapshot are skipped.
+// Returns the sequence number of the last order replayed.
+func (m *M) Replay(r *journal.Reader) (uint64, error) {
+	od := &trade.OrderData{}
+	for {
+		seq, err := r.Next(od)
+		if err == io.EOF {
+			return m.lastSeq, nil
+		}
+		if err != nil {
+			return m.lastSeq, err
+		}
+		if seq > m.lastSeq {
+			if err := m.Apply(seq, od); err != nil {
+				return m.lastSeq, err
+			}
+		}
+	}
 }
 
-func NewMatcher(slabSize int, rb *cbuf.Response) *M {
-	slab := trade.NewSlab(slabSize)
-	return &M{slab: slab, rb: rb}
+// Submits an order which has already been journalled elsewhere with sequence number seq, it is not
+// journalled again. Orders must be applied in sequence, any gap is returned as a *journal.SequenceError.
+// Matchers applying orders journalled elsewhere should not reject orders, see SetOverflowPolicy.
+func (m *M) Apply(seq uint64, od *trade.OrderData) error {
+	if seq != m.lastSeq+1 {
+		return &journal.SequenceError{Expected: m.lastSeq + 1, Found: seq}
+	}
+	j := m.journal
+	m.journal = nil
+	err := m.Submit(od)
+	m.journal = j
+	if err != nil {
+		return err
+	}
+	m.lastSeq = seq
+	return nil
 }
 
-func (m *M) Submit(od *trade.OrderData) {
+// Removes every resting order, returning them to the slab. No responses are written, this is for
+// discarding a matcher whose book has been restored elsewhere.
+func (m *M) Clear() {
+	for o := m.matchTrees.PopBuy(); o != nil; o = m.matchTrees.PopBuy() {
+		m.slab.Free(o)
+	}
+	for o := m.matchTrees.PopSell(); o != nil; o = m.matchTrees.PopSell() {
+		m.slab.Free(o)
+	}
+}
+
+// Reduces the resting order with guid by amount, keeping its place in its queue. No responses, deltas or
+// quotes are written, this is for following a book whose partial cancels are decided elsewhere. Returns
+// false, changing nothing, if there is no such order or it doesn't have more than amount resting.
+func (m *M) Reduce(guid int64, amount uint32) bool {
+	o := m.matchTrees.Get(guid)
+	if o == nil || amount >= o.Amount() {
+		return false
+	}
+	m.matchTrees.Reduce(o, amount)
+	return true
+}
+
+// The number of resting orders
+func (m *M) Size() int {
+	return m.matchTrees.Size()
+}
+
+// Returns up to n of the best buy levels
+func (m *M) BuyLevels(n int) []trade.Level {
+	return m.matchTrees.BuyLevels(nil, n)
+}
+
+// Returns up to n of the best sell levels
+func (m *M) SellLevels(n int) []trade.Level {
+	return m.matchTrees.SellLevels(nil, n)
+}
+
+// A hash of the resting book and every response written so far. Two matchers fed the same orders will
+// have the same digest.
+func (m *M) Digest() trade.Digest {
+	d := m.digest
+	d.Book = m.matchTrees.Digest().Book
+	return d
+}
+
+// Submits an order, od is neither modified nor kept. Its responses are stamped with its journal sequence
+// number if a journal is set, and with od.Seq otherwise, see LastSeq. Returns ErrOverflow if the order is
+// rejected, see SetOverflowPolicy. Returns the journal's error if od can't be appended to it. After either
+// error nothing has changed. An error syncing the journal is returned as a *SyncError, the order has then
+// been journalled and matched, as its record had already been written.
+func (m *M) Submit(od *trade.OrderData) error {
+	err := m.submit(od)
+	m.rb.Publish()
+	return err
+}
+
+// Submits od without publishing its responses
+func (m *M) submit(od *trade.OrderData) error {
 	o := m.slab.Malloc()
 	o.CopyFrom(od)
+	if err := m.reserve(o); err != nil {
+		m.slab.Free(o)
+		if m.overflow != OVERFLOW_REJECT {
+			panic(err.Error())
+		}
+		return err
+	}
+	var serr error
+	if m.journal != nil {
+		seq := m.journal.Seq()
+		err := m.journal.Append(od)
+		if m.journal.Seq() == seq {
+			m.slab.Free(o)
+			return err
+		}
+		if err != nil {
+			serr = &SyncError{Err: err}
+		}
+	}
+	m.in.Seq = od.Seq
+	if m.journal != nil {
+		m.in.Seq = m.journal.Seq()
+	}
+	m.in.RecvTime = od.RecvTime
+	if m.in.Seq != 0 {
+		m.lastSeq = m.in.Seq
+	}
+	if od.RecvTime != 0 {
+		m.now = od.RecvTime
+	}
+	top := m.qb != nil && m.atTop(o)
 	switch o.Kind() {
 	case trade.BUY:
 		m.addBuy(o)
@@ -30,6 +224,33 @@ func (m *M) Submit(od *trade.OrderData) {
 	default:
 		panic(fmt.Sprintf("OrderKind %s not supported", o.Kind().String()))
 	}
+	if top {
+		m.publishQuote(od.StockId)
+	}
+	return serr
+}
+
+// Returns true if o can change the best bid or offer, i.e. it will trade or rest at, or cancel an order
+// at, the best price on its side
+func (m *M) atTop(o *trade.Order) bool {
+	switch o.Kind() {
+	case trade.BUY:
+		b := m.matchTrees.PeekBuy()
+		return b == nil || o.Price() >= b.Price()
+	case trade.SELL:
+		s := m.matchTrees.PeekSell()
+		return s == nil || o.Price() <= s.Price()
+	case trade.CANCEL:
+		ro := m.matchTrees.Get(o.Guid())
+		if ro == nil {
+			return false
+		}
+		if ro.Kind() == trade.BUY {
+			return ro.Price() == m.matchTrees.PeekBuy().Price()
+		}
+		return ro.Price() == m.matchTrees.PeekSell().Price()
+	}
+	return true
 }
 
 func (m *M) addBuy(b *trade.Order) {
@@ -38,22 +259,25 @@ func (m *M) addBuy(b *trade.Order) {
 	}
 	if !m.fillableBuy(b) {
 		m.matchTrees.PushBuy(b)
+		m.publishOrder(trade.ADD, b, b.Amount())
 	}
 }
 
 func (m *M) addSell(s *trade.Order) {
 	if !m.fillableSell(s) {
 		m.matchTrees.PushSell(s)
+		m.publishOrder(trade.ADD, s, s.Amount())
 	}
 }
 
 func (m *M) cancel(o *trade.Order) {
 	ro := m.matchTrees.Cancel(o)
 	if ro != nil {
-		completeCancel(m.rb, trade.CANCELLED, ro)
+		m.publishOrder(trade.DELETE, ro, ro.Amount())
+		completeCancel(m.rb, &m.digest, &m.in, trade.CANCELLED, ro)
 		m.slab.Free(ro)
 	} else {
-		completeCancel(m.rb, trade.NOT_CANCELLED, o)
+		completeCancel(m.rb, &m.digest, &m.in, trade.NOT_CANCELLED, o)
 	}
 	m.slab.Free(o)
 }
@@ -68,24 +292,29 @@ func (m *M) fillableBuy(b *trade.Order) bool {
 			if b.Amount() > s.Amount() {
 				amount := s.Amount()
 				price := price(b.Price(), s.Price())
-				m.slab.Free(m.matchTrees.PopSell())
+				m.matchTrees.PopSell()
+				m.publishOrder(trade.EXECUTE, s, amount)
+				m.slab.Free(s)
 				b.ReduceAmount(amount)
-				completeTrade(m.rb, trade.PARTIAL, trade.FULL, b, s, price, amount)
+				m.completeTrade(trade.PARTIAL, trade.FULL, b, s, price, amount)
 				continue
 			}
 			if s.Amount() > b.Amount() {
 				amount := b.Amount()
 				price := price(b.Price(), s.Price())
-				s.ReduceAmount(amount)
-				completeTrade(m.rb, trade.FULL, trade.PARTIAL, b, s, price, amount)
+				m.matchTrees.Reduce(s, amount)
+				m.publishOrder(trade.EXECUTE, s, amount)
+				m.completeTrade(trade.FULL, trade.PARTIAL, b, s, price, amount)
 				m.slab.Free(b)
 				return true // The buy has been used up
 			}
 			if s.Amount() == b.Amount() {
 				amount := b.Amount()
 				price := price(b.Price(), s.Price())
-				completeTrade(m.rb, trade.FULL, trade.FULL, b, s, price, amount)
-				m.slab.Free(m.matchTrees.PopSell())
+				m.completeTrade(trade.FULL, trade.FULL, b, s, price, amount)
+				m.matchTrees.PopSell()
+				m.publishOrder(trade.EXECUTE, s, amount)
+				m.slab.Free(s)
 				m.slab.Free(b)
 				return true // The buy has been used up
 			}
@@ -106,8 +335,9 @@ func (m *M) fillableSell(s *trade.Order) bool {
 			if b.Amount() > s.Amount() {
 				amount := s.Amount()
 				price := price(b.Price(), s.Price())
-				b.ReduceAmount(amount)
-				completeTrade(m.rb, trade.PARTIAL, trade.FULL, b, s, price, amount)
+				m.matchTrees.Reduce(b, amount)
+				m.publishOrder(trade.EXECUTE, b, amount)
+				m.completeTrade(trade.PARTIAL, trade.FULL, b, s, price, amount)
 				m.slab.Free(s)
 				return true // The sell has been used up
 			}
@@ -115,15 +345,19 @@ func (m *M) fillableSell(s *trade.Order) bool {
 				amount := b.Amount()
 				price := price(b.Price(), s.Price())
 				s.ReduceAmount(amount)
-				completeTrade(m.rb, trade.PARTIAL, trade.FULL, b, s, price, amount)
-				m.slab.Free(m.matchTrees.PopBuy())
+				m.completeTrade(trade.FULL, trade.PARTIAL, b, s, price, amount)
+				m.matchTrees.PopBuy()
+				m.publishOrder(trade.EXECUTE, b, amount)
+				m.slab.Free(b)
 				continue
 			}
 			if s.Amount() == b.Amount() {
 				amount := b.Amount()
 				price := price(b.Price(), s.Price())
-				completeTrade(m.rb, trade.FULL, trade.FULL, b, s, price, amount)
-				m.slab.Free(m.matchTrees.PopBuy())
+				m.completeTrade(trade.FULL, trade.FULL, b, s, price, amount)
+				m.matchTrees.PopBuy()
+				m.publishOrder(trade.EXECUTE, b, amount)
+				m.slab.Free(b)
 				m.slab.Free(s)
 				return true // The sell has been used up
 			}
@@ -134,6 +368,54 @@ func (m *M) fillableSell(s *trade.Order) bool {
 	panic("Unreachable")
 }
 
+// Publishes a change to a resting order, followed by the new state of its price level
+func (m *M) publishOrder(kind trade.DeltaKind, o *trade.Order, amount uint32) {
+	if m.db == nil {
+		return
+	}
+	d, err := m.db.GetForWrite()
+	if err != nil {
+		panic(err.Error())
+	}
+	d.WriteOrder(kind, m.nextDeltaSeq(o.StockId()), o, amount)
+	var count uint32
+	var level uint64
+	if o.Kind() == trade.BUY {
+		count, level = m.matchTrees.BuyLevel(o.Price())
+	} else {
+		count, level = m.matchTrees.SellLevel(o.Price())
+	}
+	l, err := m.db.GetForWrite()
+	if err != nil {
+		panic(err.Error())
+	}
+	l.WriteLevel(m.nextDeltaSeq(o.StockId()), o.Kind(), o.StockId(), o.Price(), count, level)
+}
+
+func (m *M) publishQuote(stockId uint32) {
+	if m.qb == nil {
+		return
+	}
+	var quote trade.Quote
+	quote.WriteBest(stockId, &m.matchTrees)
+	last := m.quotes[stockId]
+	if !quote.Changed(&last) {
+		return
+	}
+	q, err := m.qb.GetForWrite()
+	if err != nil {
+		panic(err.Error())
+	}
+	*q = quote
+	m.quotes[stockId] = quote
+}
+
+func (m *M) nextDeltaSeq(stockId uint32) uint64 {
+	seq := m.deltaSeqs[stockId] + 1
+	m.deltaSeqs[stockId] = seq
+	return seq
+}
+
 func price(bPrice, sPrice int64) int64 {
 	if sPrice == trade.MARKET_PRICE {
 		return bPrice
@@ -142,7 +424,14 @@ func price(bPrice, sPrice int64) int64 {
 	return sPrice + (d >> 1)
 }
 
-func completeTrade(rb *cbuf.Response, brk, srk trade.ResponseKind, b, s *trade.Order, price int64, amount uint32) {
+func (m *M) completeTrade(brk, srk trade.ResponseKind, b, s *trade.Order, price int64, amount uint32) {
+	completeTrade(m.rb, &m.digest, &m.in, brk, srk, b, s, price, amount)
+	if m.tape != nil {
+		m.tape.Record(s.StockId(), price, amount, m.now)
+	}
+}
+
+func completeTrade(rb cbuf.ResponseWriter, d *trade.Digest, in *trade.OrderData, brk, srk trade.ResponseKind, b, s *trade.Order, price int64, amount uint32) {
 	br, berr := rb.GetForWrite()
 	if berr != nil {
 		panic(berr.Error())
@@ -153,12 +442,18 @@ func completeTrade(rb *cbuf.Response, brk, srk trade.ResponseKind, b, s *trade.O
 	}
 	br.WriteTrade(brk, -price, amount, b.TraderId(), b.TradeId(), s.TraderId())
 	sr.WriteTrade(srk, price, amount, s.TraderId(), s.TradeId(), b.TraderId())
+	br.WriteStamp(in)
+	sr.WriteStamp(in)
+	d.AddResponse(br)
+	d.AddResponse(sr)
 }
 
-func completeCancel(rb *cbuf.Response, rk trade.ResponseKind, d *trade.Order) {
+func completeCancel(rb cbuf.ResponseWriter, d *trade.Digest, in *trade.OrderData, rk trade.ResponseKind, o *trade.Order) {
 	r, err := rb.GetForWrite()
 	if err != nil {
 		panic(err.Error())
 	}
-	r.WriteCancel(rk, d.TraderId(), d.TradeId())
+	r.WriteCancel(rk, o.TraderId(), o.TradeId())
+	r.WriteStamp(in)
+	d.AddResponse(r)
 }
@@ -0,0 +1,158 @@
+package matcher
+
+import (
+	"errors"
+	"github.com/fmstephe/matching_engine/cbuf"
+	"github.com/fmstephe/matching_engine/trade"
+)
+
+// What Submit does when the response buffer can't hold every response an order could cause. Whichever
+// policy is chosen the book is never left half matched. The delta and quote buffers are read on the
+// submitting goroutine, so no policy can wait or spill for them, an order they can't hold every delta and
+// quote of is rejected under OVERFLOW_REJECT and panics under every other policy.
+type OverflowPolicy int
+
+const (
+	OVERFLOW_PANIC  = OverflowPolicy(iota) // Panics before the order touches the book
+	OVERFLOW_BLOCK                         // Waits for readers to make room, the buffer must be a cbuf.FreeWaiter
+	OVERFLOW_SPILL                         // Queues responses which don't fit, moving them into the buffer as it makes room
+	OVERFLOW_REJECT                        // Rejects the order with ErrOverflow, it is not journalled
+)
+
+var ErrOverflow = errors.New("Response buffer can't hold every response the order could cause")
+
+// Must be called before any orders are submitted
+func (m *M) SetOverflowPolicy(policy OverflowPolicy) {
+	if policy == OVERFLOW_BLOCK {
+		if _, ok := m.rb.(cbuf.FreeWaiter); !ok {
+			panic("OVERFLOW_BLOCK needs a response buffer which can wait for its readers")
+		}
+	}
+	if policy == OVERFLOW_SPILL && m.spill == nil {
+		m.spill = &spillWriter{rb: m.rb}
+		m.rb = m.spill
+	}
+	m.overflow = policy
+}
+
+// Moves as many spilled responses into the response buffer as it has room for and publishes them.
+// Returns the number of responses still spilled.
+func (m *M) Flush() int {
+	m.rb.Publish()
+	if m.spill == nil {
+		return 0
+	}
+	return m.spill.pending()
+}
+
+// Makes sure every response, delta and quote o could cause can be written before o is matched. Returns
+// ErrOverflow if they can't be, o must then not be matched and, unless the policy is OVERFLOW_REJECT, the
+// caller panics.
+func (m *M) reserve(o *trade.Order) error {
+	trades := m.maxTrades(o)
+	if !m.fits(o, trades) {
+		trades = m.trades(o)
+	}
+	// Each resting order o trades with, and o if it rests or cancels, writes an order and a level delta
+	if m.db != nil && m.db.Free() < 2*(trades+1) {
+		return ErrOverflow
+	}
+	if m.qb != nil && m.qb.Free() < 1 {
+		return ErrOverflow
+	}
+	need := responses(o, trades)
+	if m.rb.Free() >= need {
+		return nil
+	}
+	switch m.overflow {
+	case OVERFLOW_BLOCK:
+		m.rb.(cbuf.FreeWaiter).WaitFree(need)
+		return nil
+	case OVERFLOW_SPILL:
+		return nil
+	}
+	return ErrOverflow
+}
+
+// The most trades o could make, found without walking the book. It trades with each resting order at most
+// once, and for at least one unit.
+func (m *M) maxTrades(o *trade.Order) int {
+	if o.Kind() == trade.CANCEL {
+		return 0
+	}
+	return min(m.matchTrees.Size(), int(o.Amount()))
+}
+
+// Returns true if every response and delta of o trading with trades resting orders can be written now
+func (m *M) fits(o *trade.Order, trades int) bool {
+	if m.db != nil && m.db.Free() < 2*(trades+1) {
+		return false
+	}
+	return m.rb.Free() >= responses(o, trades)
+}
+
+// The number of resting orders o would trade with if it were matched now
+func (m *M) trades(o *trade.Order) int {
+	if o.Kind() == trade.CANCEL {
+		return 0
+	}
+	return m.matchTrees.Trades(o)
+}
+
+// The number of responses o would cause trading with trades resting orders
+func responses(o *trade.Order, trades int) int {
+	if o.Kind() == trade.CANCEL {
+		return 1
+	}
+	return 2 * trades
+}
+
+// Writes responses to rb while it has room, and to an unbounded queue once it doesn't. Queued responses
+// are moved into rb, in order, each time the spillWriter is published.
+type spillWriter struct {
+	rb    cbuf.ResponseWriter
+	queue []*trade.Response
+	head  int
+	free  []*trade.Response // Recycled queue entries
+}
+
+func (s *spillWriter) GetForWrite() (*trade.Response, error) {
+	if s.pending() == 0 && s.rb.Free() > 0 {
+		return s.rb.GetForWrite()
+	}
+	var r *trade.Response
+	if n := len(s.free); n > 0 {
+		r = s.free[n-1]
+		s.free = s.free[:n-1]
+	} else {
+		r = &trade.Response{}
+	}
+	s.queue = append(s.queue, r)
+	return r, nil
+}
+
+func (s *spillWriter) Publish() {
+	for s.pending() > 0 && s.rb.Free() > 0 {
+		w, err := s.rb.GetForWrite()
+		if err != nil {
+			panic(err.Error())
+		}
+		r := s.queue[s.head]
+		*w = *r
+		s.free = append(s.free, r)
+		s.head++
+	}
+	if s.pending() == 0 {
+		s.queue = s.queue[:0]
+		s.head = 0
+	}
+	s.rb.Publish()
+}
+
+func (s *spillWriter) Free() int {
+	return s.rb.Free() - s.pending()
+}
+
+func (s *spillWriter) pending() int {
+	return len(s.queue) - s.head
+}
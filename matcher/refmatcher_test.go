@@ -6,9 +6,11 @@ import (
 )
 
 type refmatcher struct {
-	buys  *prioq
-	sells *prioq
-	rb    *cbuf.Response
+	buys   *prioq
+	sells  *prioq
+	rb     *cbuf.Response
+	digest trade.Digest
+	in     *trade.OrderData
 }
 
 func newRefmatcher(lowPrice, highPrice int64, rb *cbuf.Response) *refmatcher {
@@ -18,15 +20,16 @@ func newRefmatcher(lowPrice, highPrice int64, rb *cbuf.Response) *refmatcher {
 }
 
 func (m *refmatcher) submit(od *trade.OrderData) {
+	m.in = od
 	o := &trade.Order{}
 	o.CopyFrom(od)
 	if o.Kind() == trade.CANCEL {
 		co := m.pop(o)
 		if co != nil {
-			completeCancel(m.rb, trade.CANCELLED, co)
+			completeCancel(m.rb, &m.digest, m.in, trade.CANCELLED, co)
 		}
 		if co == nil {
-			completeCancel(m.rb, trade.NOT_CANCELLED, o)
+			completeCancel(m.rb, &m.digest, m.in, trade.NOT_CANCELLED, o)
 		}
 	} else {
 		m.push(o)
@@ -50,7 +53,7 @@ func (m *refmatcher) match() {
 			m.popBuy()
 			amount := s.Amount()
 			price := price(b.Price(), s.Price())
-			completeTrade(m.rb, trade.FULL, trade.FULL, b, s, price, amount)
+			completeTrade(m.rb, &m.digest, m.in, trade.FULL, trade.FULL, b, s, price, amount)
 		}
 		if s.Amount() > b.Amount() {
 			// pop buy
@@ -58,7 +61,7 @@ func (m *refmatcher) match() {
 			amount := b.Amount()
 			price := price(b.Price(), s.Price())
 			s.ReduceAmount(b.Amount())
-			completeTrade(m.rb, trade.FULL, trade.PARTIAL, b, s, price, amount)
+			completeTrade(m.rb, &m.digest, m.in, trade.FULL, trade.PARTIAL, b, s, price, amount)
 		}
 		if b.Amount() > s.Amount() {
 			// pop sell
@@ -66,11 +69,27 @@ func (m *refmatcher) match() {
 			amount := s.Amount()
 			price := price(b.Price(), s.Price())
 			b.ReduceAmount(s.Amount())
-			completeTrade(m.rb, trade.PARTIAL, trade.FULL, b, s, price, amount)
+			completeTrade(m.rb, &m.digest, m.in, trade.PARTIAL, trade.FULL, b, s, price, amount)
 		}
 	}
 }
 
+// The digest of the responses written and the resting book, each order added behind the one queued ahead of it
+func (m *refmatcher) Digest() trade.Digest {
+	d := m.digest
+	d.Book = 0
+	for _, q := range [][][]*trade.Order{m.buys.prios, m.sells.prios} {
+		for _, prio := range q {
+			var ahead *trade.Order
+			for _, o := range prio {
+				d.AddOrder(o, ahead)
+				ahead = o
+			}
+		}
+	}
+	return d
+}
+
 func (m *refmatcher) Size() int {
 	return -1
 }
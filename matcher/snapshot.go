@@ -0,0 +1,109 @@
+package matcher
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"github.com/fmstephe/matching_engine/trade"
+	"io"
+	"sort"
+)
+
+const snapshotVersion = uint32(4)
+
+var ErrSnapshotVersion = errors.New("Unsupported matcher snapshot version")
+
+// Follows the version, which comes first, and the book
+type snapshotState struct {
+	LastSeq   uint64
+	Now       int64
+	Stream    uint64 // See Digest
+	DeltaSeqs uint32 // The number of (stock id, delta sequence) pairs that follow
+	Quotes    uint32 // The number of quotes that follow them
+}
+
+type deltaSeq struct {
+	StockId uint32
+	Seq     uint64
+}
+
+// Writes the resting book and the sequence numbers needed to continue exactly where this matcher
+// left off. Orders journalled after the snapshot can be replayed on top of it using Replay.
+func (m *M) WriteSnapshot(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	if err := binary.Write(bw, binary.LittleEndian, snapshotVersion); err != nil {
+		return err
+	}
+	if err := m.matchTrees.WriteSnapshot(bw); err != nil {
+		return err
+	}
+	state := snapshotState{LastSeq: m.lastSeq, Now: m.now, Stream: m.digest.Stream, DeltaSeqs: uint32(len(m.deltaSeqs)), Quotes: uint32(len(m.quotes))}
+	if err := binary.Write(bw, binary.LittleEndian, &state); err != nil {
+		return err
+	}
+	for _, stockId := range sortedKeys(m.deltaSeqs) {
+		ds := deltaSeq{StockId: stockId, Seq: m.deltaSeqs[stockId]}
+		if err := binary.Write(bw, binary.LittleEndian, &ds); err != nil {
+			return err
+		}
+	}
+	for _, stockId := range sortedKeys(m.quotes) {
+		q := m.quotes[stockId]
+		if err := binary.Write(bw, binary.LittleEndian, &q); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// Restores a snapshot into a newly created matcher. Output buffers, the tape and journal are not part of
+// the snapshot. A snapshot of another version is rejected with ErrSnapshotVersion before anything is read.
+func (m *M) ReadSnapshot(r io.Reader) error {
+	br := bufio.NewReader(r)
+	var version uint32
+	if err := binary.Read(br, binary.LittleEndian, &version); err != nil {
+		return err
+	}
+	if version != snapshotVersion {
+		return ErrSnapshotVersion
+	}
+	if err := m.matchTrees.ReadSnapshot(br, m.slab); err != nil {
+		return err
+	}
+	var state snapshotState
+	if err := binary.Read(br, binary.LittleEndian, &state); err != nil {
+		return err
+	}
+	m.lastSeq = state.LastSeq
+	m.now = state.Now
+	m.digest.Stream = state.Stream
+	for i := uint32(0); i < state.DeltaSeqs; i++ {
+		var ds deltaSeq
+		if err := binary.Read(br, binary.LittleEndian, &ds); err != nil {
+			return err
+		}
+		m.deltaSeqs[ds.StockId] = ds.Seq
+	}
+	for i := uint32(0); i < state.Quotes; i++ {
+		var q trade.Quote
+		if err := binary.Read(br, binary.LittleEndian, &q); err != nil {
+			return err
+		}
+		m.quotes[q.StockId] = q
+	}
+	return nil
+}
+
+func sortedKeys[V any](m map[uint32]V) []uint32 {
+	keys := make([]uint32, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	return keys
+}
+
+// The journal sequence number of the last order submitted
+func (m *M) LastSeq() uint64 {
+	return m.lastSeq
+}
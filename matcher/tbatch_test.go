@@ -0,0 +1,208 @@
+package matcher
+
+import (
+	"bytes"
+	"github.com/fmstephe/matching_engine/cbuf"
+	"github.com/fmstephe/matching_engine/journal"
+	"github.com/fmstephe/matching_engine/trade"
+	"testing"
+)
+
+var tbatchOrderMaker = trade.NewOrderMaker()
+
+// A batch causes exactly the responses its orders cause when submitted one at a time
+func TestSubmitBatch(t *testing.T) {
+	orders, err := tbatchOrderMaker.RndTradeSet(1000, 10, 1, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	batched := make([]trade.OrderData, len(orders))
+	copy(batched, orders)
+	rb := cbuf.New(len(orders) * 4)
+	m := NewMatcher(len(orders), rb)
+	for i := range orders {
+		m.Submit(&orders[i])
+	}
+	brb := cbuf.New(len(orders) * 4)
+	bm := NewMatcher(len(orders), brb)
+	errs := make([]error, 7)
+	for i := 0; i < len(batched); i += len(errs) {
+		batch := batched[i:min(i+len(errs), len(batched))]
+		if accepted := bm.SubmitBatch(batch, errs); accepted != len(batch) {
+			t.Errorf("Expecting %d accepted, got %d instead", len(batch), accepted)
+		}
+	}
+	if bm.Digest() != m.Digest() {
+		t.Errorf("Expecting %v, got %v instead", m.Digest(), bm.Digest())
+	}
+	if brb.Writes() != rb.Writes() {
+		t.Errorf("Expecting %d responses, got %d instead", rb.Writes(), brb.Writes())
+	}
+	for {
+		r, err := rb.GetForRead()
+		if err != nil {
+			break
+		}
+		br, _ := brb.GetForRead()
+		if *br != *r {
+			t.Errorf("Expecting %v, got %v instead", *r, *br)
+		}
+	}
+}
+
+// Each order in a batch is accepted or rejected on its own
+func TestSubmitBatchReject(t *testing.T) {
+	m := NewMatcher(10, cbuf.New(4))
+	var buf bytes.Buffer
+	jw, _ := journal.NewWriter(&buf, 0, 0)
+	m.SetJournal(jw)
+	m.SetOverflowPolicy(OVERFLOW_REJECT)
+	buy := overflowBook(m)
+	batch := make([]trade.OrderData, 3)
+	batch[0].WriteSell(trade.CostData{Price: 20, Amount: 1}, trade.TradeData{TraderId: 4, TradeId: 4, StockId: 1})
+	batch[1] = *buy
+	batch[2].WriteBuy(trade.CostData{Price: 5, Amount: 1}, trade.TradeData{TraderId: 5, TradeId: 5, StockId: 1})
+	errs := make([]error, len(batch))
+	if accepted := m.SubmitBatch(batch, errs); accepted != 2 {
+		t.Errorf("Expecting %d accepted, got %d instead", 2, accepted)
+	}
+	expected := []error{nil, ErrOverflow, nil}
+	for i := range expected {
+		if errs[i] != expected[i] {
+			t.Errorf("Expecting %v, got %v instead", expected[i], errs[i])
+		}
+	}
+	if m.Size() != 5 {
+		t.Errorf("Expecting %d resting orders, got %d instead", 5, m.Size())
+	}
+	if m.LastSeq() != 5 {
+		t.Errorf("Expecting sequence %d, got %d instead", 5, m.LastSeq())
+	}
+}
+
+// A batch is checked order by order, so an order trading with one ahead of it in the batch panics once the
+// orders ahead of it have been matched
+func TestSubmitBatchPanic(t *testing.T) {
+	m := NewMatcher(10, cbuf.New(2))
+	// Cancelling an unknown order leaves one free response
+	cancel := &trade.OrderData{}
+	cancel.Write(trade.CostData{}, trade.TradeData{TraderId: 1, TradeId: 1, StockId: 1}, trade.CANCEL)
+	m.Submit(cancel)
+	batch := make([]trade.OrderData, 2)
+	batch[0].WriteBuy(trade.CostData{Price: 10, Amount: 1}, trade.TradeData{TraderId: 2, TradeId: 2, StockId: 1})
+	batch[1].WriteSell(trade.CostData{Price: 10, Amount: 1}, trade.TradeData{TraderId: 3, TradeId: 3, StockId: 1})
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("Expecting a panic")
+			}
+		}()
+		m.SubmitBatch(batch, make([]error, len(batch)))
+	}()
+	if m.Size() != 1 {
+		t.Errorf("Expecting %d resting orders, got %d instead", 1, m.Size())
+	}
+}
+
+// Orders which could trade with the same resting order only need room for the trades they make
+func TestSubmitBatchFits(t *testing.T) {
+	m := NewMatcher(10, cbuf.New(4))
+	cancel := &trade.OrderData{}
+	cancel.Write(trade.CostData{}, trade.TradeData{TraderId: 1, TradeId: 1, StockId: 1}, trade.CANCEL)
+	m.Submit(cancel)
+	buy := &trade.OrderData{}
+	buy.WriteBuy(trade.CostData{Price: 10, Amount: 1}, trade.TradeData{TraderId: 2, TradeId: 2, StockId: 1})
+	m.Submit(buy)
+	batch := make([]trade.OrderData, 2)
+	batch[0].WriteSell(trade.CostData{Price: 10, Amount: 1}, trade.TradeData{TraderId: 3, TradeId: 3, StockId: 1})
+	batch[1].WriteSell(trade.CostData{Price: 10, Amount: 1}, trade.TradeData{TraderId: 4, TradeId: 4, StockId: 1})
+	if accepted := m.SubmitBatch(batch, make([]error, len(batch))); accepted != 2 {
+		t.Errorf("Expecting %d accepted, got %d instead", 2, accepted)
+	}
+	if m.Size() != 1 {
+		t.Errorf("Expecting %d resting orders, got %d instead", 1, m.Size())
+	}
+}
+
+// A batch causing more responses than the buffer holds waits for the reader
+func TestSubmitBatchBlock(t *testing.T) {
+	rb := cbuf.NewSPSC(4, cbuf.ParkWait)
+	m := NewMatcher(10, rb)
+	read := make(chan int)
+	go func() {
+		n := 0
+		for _, err := rb.WaitForRead(); err == nil; _, err = rb.WaitForRead() {
+			n++
+		}
+		read <- n
+	}()
+	buy := overflowBook(m)
+	batch := []trade.OrderData{*buy, {}}
+	batch[1].WriteBuy(trade.CostData{Price: 5, Amount: 1}, trade.TradeData{TraderId: 4, TradeId: 4, StockId: 1})
+	if accepted := m.SubmitBatch(batch, make([]error, len(batch))); accepted != 2 {
+		t.Errorf("Expecting %d accepted, got %d instead", 2, accepted)
+	}
+	rb.Close()
+	if n := <-read; n != 6 {
+		t.Errorf("Expecting %d responses, got %d instead", 6, n)
+	}
+	if m.Size() != 1 {
+		t.Errorf("Expecting %d resting orders, got %d instead", 1, m.Size())
+	}
+}
+
+type syncCounter struct {
+	bytes.Buffer
+	syncs int
+}
+
+func (s *syncCounter) Sync() error {
+	s.syncs++
+	return nil
+}
+
+// A batch is synced once, however many records it journals
+func TestSubmitBatchSync(t *testing.T) {
+	orders, err := tbatchOrderMaker.RndTradeSet(100, 10, 1, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewMatcher(len(orders), cbuf.New(len(orders)*4))
+	var w syncCounter
+	jw, _ := journal.NewWriter(&w, 0, 1)
+	m.SetJournal(jw)
+	errs := make([]error, 10)
+	for i := 0; i < len(orders); i += len(errs) {
+		m.SubmitBatch(orders[i:i+len(errs)], errs)
+	}
+	if w.syncs != len(orders)/len(errs) {
+		t.Errorf("Expecting %d syncs, got %d instead", len(orders)/len(errs), w.syncs)
+	}
+	if jw.Seq() != uint64(len(orders)) {
+		t.Errorf("Expecting %d orders journalled, got %d instead", len(orders), jw.Seq())
+	}
+}
+
+// A batch whose journal records can't be synced has still been applied
+func TestSubmitBatchSyncError(t *testing.T) {
+	orders, err := tbatchOrderMaker.RndTradeSet(5, 2, 1, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewMatcher(len(orders), cbuf.New(len(orders)*4))
+	w := &failWriter{failSync: true}
+	jw, _ := journal.NewWriter(w, 0, 1)
+	m.SetJournal(jw)
+	errs := make([]error, len(orders))
+	if accepted := m.SubmitBatch(orders, errs); accepted != len(orders) {
+		t.Errorf("Expecting %d accepted, got %d instead", len(orders), accepted)
+	}
+	for i := range errs {
+		if _, ok := errs[i].(*SyncError); !ok {
+			t.Errorf("Expecting a sync error, got %v instead", errs[i])
+		}
+	}
+	if m.LastSeq() != uint64(len(orders)) {
+		t.Errorf("Expecting sequence %d, got %d instead", len(orders), m.LastSeq())
+	}
+}
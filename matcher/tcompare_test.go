@@ -42,6 +42,13 @@ func compareMatchers(t *testing.T, orderPairs, depth int, lowPrice, highPrice in
 		m.Submit(o)
 		m.Submit(o)
 		checkBuffers(t, rrb, rb)
+		checkDigests(t, rm, m)
+	}
+}
+
+func checkDigests(t *testing.T, rm *refmatcher, m *M) {
+	if rm.Digest() != m.Digest() {
+		t.Errorf("Different digests detected. Simple: %v, Real: %v", rm.Digest(), m.Digest())
 	}
 }
 
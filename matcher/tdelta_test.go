@@ -0,0 +1,136 @@
+package matcher
+
+import (
+	"github.com/fmstephe/matching_engine/cbuf"
+	"github.com/fmstephe/matching_engine/trade"
+	"testing"
+)
+
+var tdeltaOrderMaker = trade.NewOrderMaker()
+
+type levelKey struct {
+	side  trade.OrderKind
+	price int64
+}
+
+// A book rebuilt purely from a delta feed
+type deltaBook struct {
+	orders map[int64]*trade.Delta
+	seqs   map[uint32]uint64
+}
+
+func newDeltaBook() *deltaBook {
+	return &deltaBook{orders: make(map[int64]*trade.Delta), seqs: make(map[uint32]uint64)}
+}
+
+func (b *deltaBook) apply(t *testing.T, d *trade.Delta) {
+	if d.Seq != b.seqs[d.StockId]+1 {
+		t.Errorf("Expecting sequence number %d, got %d instead", b.seqs[d.StockId]+1, d.Seq)
+	}
+	b.seqs[d.StockId] = d.Seq
+	switch d.Kind {
+	case trade.ADD:
+		if _, ok := b.orders[d.Guid]; ok {
+			t.Errorf("Order %d added twice", d.Guid)
+		}
+		cp := *d
+		b.orders[d.Guid] = &cp
+	case trade.EXECUTE:
+		o := b.orders[d.Guid]
+		if o == nil || o.Amount < d.Amount {
+			t.Errorf("Invalid execution %v of %v", d, o)
+			return
+		}
+		o.Amount -= d.Amount
+		if o.Amount == 0 {
+			delete(b.orders, d.Guid)
+		}
+	case trade.DELETE:
+		o := b.orders[d.Guid]
+		if o == nil || o.Amount != d.Amount {
+			t.Errorf("Invalid delete %v of %v", d, o)
+		}
+		delete(b.orders, d.Guid)
+	case trade.LEVEL:
+		count, level := b.level(d.Side, d.Price)
+		if count != d.Count || level != d.Level {
+			t.Errorf("Expecting level (%d, %d), got (%d, %d) instead", count, level, d.Count, d.Level)
+		}
+	}
+}
+
+func (b *deltaBook) level(side trade.OrderKind, price int64) (count uint32, level uint64) {
+	for _, o := range b.orders {
+		if o.Side == side && o.Price == price {
+			count++
+			level += uint64(o.Amount)
+		}
+	}
+	return count, level
+}
+
+func TestDeltaSimple(t *testing.T) {
+	rb := cbuf.New(20)
+	db := cbuf.NewDelta(20)
+	m := NewMatcher(100, rb)
+	m.SetDeltaBuffer(db)
+	b := &trade.OrderData{}
+	b.WriteBuy(trade.CostData{Price: 7, Amount: 5}, trade.TradeData{TraderId: trader1, TradeId: 1, StockId: stockId})
+	m.Submit(b)
+	s := &trade.OrderData{}
+	s.WriteSell(trade.CostData{Price: 7, Amount: 2}, trade.TradeData{TraderId: trader2, TradeId: 2, StockId: stockId})
+	m.Submit(s)
+	c := &trade.OrderData{}
+	c.Write(trade.CostData{}, trade.TradeData{TraderId: trader1, TradeId: 1, StockId: stockId}, trade.CANCEL)
+	m.Submit(c)
+	verifyDelta(t, db, trade.Delta{Kind: trade.ADD, Side: trade.BUY, StockId: stockId, Seq: 1, Guid: b.Guid, Price: 7, Amount: 5})
+	verifyDelta(t, db, trade.Delta{Kind: trade.LEVEL, Side: trade.BUY, StockId: stockId, Seq: 2, Price: 7, Count: 1, Level: 5})
+	verifyDelta(t, db, trade.Delta{Kind: trade.EXECUTE, Side: trade.BUY, StockId: stockId, Seq: 3, Guid: b.Guid, Price: 7, Amount: 2})
+	verifyDelta(t, db, trade.Delta{Kind: trade.LEVEL, Side: trade.BUY, StockId: stockId, Seq: 4, Price: 7, Count: 1, Level: 3})
+	verifyDelta(t, db, trade.Delta{Kind: trade.DELETE, Side: trade.BUY, StockId: stockId, Seq: 5, Guid: b.Guid, Price: 7, Amount: 3})
+	verifyDelta(t, db, trade.Delta{Kind: trade.LEVEL, Side: trade.BUY, StockId: stockId, Seq: 6, Price: 7, Count: 0, Level: 0})
+	if _, err := db.GetForRead(); err == nil {
+		t.Errorf("Unexpected delta found")
+	}
+}
+
+func TestDeltaRebuild(t *testing.T) {
+	testDeltaRebuild(t, 100, 1, 1, 1)
+	testDeltaRebuild(t, 100, 10, 1, 2)
+	testDeltaRebuild(t, 100, 100, 10, 20)
+	testDeltaRebuild(t, 1000, 100, 100, 2000)
+}
+
+func testDeltaRebuild(t *testing.T, orderPairs, depth int, lowPrice, highPrice int64) {
+	rb := cbuf.New(orderPairs * 16)
+	db := cbuf.NewDelta(orderPairs * 16)
+	m := NewMatcher(orderPairs*2, rb)
+	m.SetDeltaBuffer(db)
+	book := newDeltaBook()
+	orders, err := tdeltaOrderMaker.RndTradeSet(orderPairs, depth, lowPrice, highPrice)
+	if err != nil {
+		panic(err.Error())
+	}
+	for i := range orders {
+		m.Submit(&orders[i])
+		for db.Reads() < db.Writes() {
+			d, _ := db.GetForRead()
+			book.apply(t, d)
+		}
+		rb.Clear()
+	}
+	if len(book.orders) != m.matchTrees.Size() {
+		t.Errorf("Rebuilt book has %d orders, matcher has %d", len(book.orders), m.matchTrees.Size())
+	}
+}
+
+func verifyDelta(t *testing.T, db *cbuf.Delta, expected trade.Delta) {
+	d, err := db.GetForRead()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if *d != expected {
+		t.Errorf("Expecting %v, got %v instead", expected, *d)
+	}
+}
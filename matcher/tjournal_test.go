@@ -0,0 +1,178 @@
+package matcher
+
+import (
+	"bytes"
+	"errors"
+	"github.com/fmstephe/matching_engine/cbuf"
+	"github.com/fmstephe/matching_engine/journal"
+	"github.com/fmstephe/matching_engine/trade"
+	"testing"
+)
+
+var tjournalOrderMaker = trade.NewOrderMaker()
+
+func TestJournalReplay(t *testing.T) {
+	testJournalReplay(t, 100, 10, 1, 20)
+	testJournalReplay(t, 1000, 100, 100, 2000)
+}
+
+func testJournalReplay(t *testing.T, orderPairs, depth int, lowPrice, highPrice int64) {
+	orders, err := tjournalOrderMaker.RndTradeSet(orderPairs, depth, lowPrice, highPrice)
+	if err != nil {
+		panic(err.Error())
+	}
+	var buf bytes.Buffer
+	jw, err := journal.NewWriter(&buf, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rb := cbuf.New(len(orders) * 2)
+	m := NewMatcher(orderPairs*2, rb)
+	m.SetJournal(jw)
+	for i := range orders {
+		m.Submit(&orders[i])
+	}
+	jr, err := journal.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rrb := cbuf.New(len(orders) * 2)
+	rm := NewMatcher(orderPairs*2, rrb)
+	seq, err := rm.Replay(jr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seq != uint64(len(orders)) {
+		t.Errorf("Expecting %d orders replayed, got %d instead", len(orders), seq)
+	}
+	if rm.Size() != m.Size() {
+		t.Errorf("Expecting %d resting orders, got %d instead", m.Size(), rm.Size())
+	}
+	checkBuffers(t, rb, rrb)
+}
+
+type failWriter struct {
+	fail     bool
+	failSync bool
+}
+
+func (w *failWriter) Write(b []byte) (int, error) {
+	if w.fail {
+		return 0, errors.New("Write failed")
+	}
+	return len(b), nil
+}
+
+func (w *failWriter) Sync() error {
+	if w.failSync {
+		return errors.New("Sync failed")
+	}
+	return nil
+}
+
+// An order which can't be journalled is returned as an error and never matched
+func TestJournalError(t *testing.T) {
+	w := &failWriter{}
+	jw, err := journal.NewWriter(w, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rb := cbuf.New(10)
+	m := NewMatcher(10, rb)
+	m.SetJournal(jw)
+	od := &trade.OrderData{}
+	od.Write(trade.CostData{Price: 5, Amount: 1}, trade.TradeData{TraderId: 1, TradeId: 1, StockId: 1}, trade.BUY)
+	w.fail = true
+	if err := m.Submit(od); err == nil {
+		t.Errorf("Expecting an error for a failed write")
+	}
+	od.Seq = 2
+	w.fail = false
+	if _, ok := m.Submit(od).(*journal.SequenceError); !ok {
+		t.Errorf("Expecting a sequence error")
+	}
+	if m.Size() != 0 || m.LastSeq() != 0 || jw.Seq() != 0 {
+		t.Errorf("Expecting nothing matched, got %d resting orders and sequence %d instead", m.Size(), m.LastSeq())
+	}
+	od.Seq = 0
+	if err := m.Submit(od); err != nil || m.Size() != 1 || m.LastSeq() != 1 {
+		t.Errorf("Expecting order 1 to rest, got %v, %d resting orders and sequence %d instead", err, m.Size(), m.LastSeq())
+	}
+}
+
+// Submitting an order leaves it unsequenced, so the same order can be submitted again
+func TestJournalResubmit(t *testing.T) {
+	var buf bytes.Buffer
+	jw, err := journal.NewWriter(&buf, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewMatcher(10, cbuf.New(10))
+	m.SetJournal(jw)
+	od := &trade.OrderData{}
+	od.Write(trade.CostData{Price: 5, Amount: 1}, trade.TradeData{TraderId: 1, TradeId: 1, StockId: 1}, trade.BUY)
+	for seq := uint64(1); seq <= 2; seq++ {
+		if err := m.Submit(od); err != nil {
+			t.Fatal(err)
+		}
+		if od.Seq != 0 || m.LastSeq() != seq {
+			t.Errorf("Expecting sequence %d, got %d and %d instead", seq, od.Seq, m.LastSeq())
+		}
+	}
+	jr, err := journal.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rm := NewMatcher(10, cbuf.New(10))
+	if seq, err := rm.Replay(jr); err != nil || seq != 2 {
+		t.Errorf("Expecting %d orders replayed, got %d and %v instead", 2, seq, err)
+	}
+}
+
+// Only a sync error leaves the order applied
+func TestSubmitErrors(t *testing.T) {
+	w := &failWriter{}
+	jw, err := journal.NewWriter(w, 0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewMatcher(10, cbuf.New(2))
+	m.SetOverflowPolicy(OVERFLOW_REJECT)
+	m.SetJournal(jw)
+	buy := &trade.OrderData{}
+	buy.Write(trade.CostData{Price: 5, Amount: 1}, trade.TradeData{TraderId: 1, TradeId: 1, StockId: 1}, trade.BUY)
+	sell := &trade.OrderData{}
+	sell.Write(trade.CostData{Price: 5, Amount: 1}, trade.TradeData{TraderId: 2, TradeId: 1, StockId: 1}, trade.SELL)
+	// The write fails
+	w.fail = true
+	if err := m.Submit(buy); err == nil {
+		t.Errorf("Expecting an error for a failed write")
+	}
+	if m.Size() != 0 || m.LastSeq() != 0 || jw.Seq() != 0 {
+		t.Errorf("Expecting nothing applied, got %d resting orders and sequence %d instead", m.Size(), m.LastSeq())
+	}
+	// The sync fails
+	w.fail, w.failSync = false, true
+	err = m.Submit(buy)
+	if serr, ok := err.(*SyncError); !ok || serr.Unwrap() == nil {
+		t.Errorf("Expecting a sync error, got %v instead", err)
+	}
+	if m.Size() != 1 || m.LastSeq() != 1 || jw.Seq() != 1 {
+		t.Errorf("Expecting order 1 to rest, got %d resting orders and sequence %d instead", m.Size(), m.LastSeq())
+	}
+	// The response buffer is full
+	w.failSync = false
+	m.Submit(sell)
+	if m.Size() != 0 || m.LastSeq() != 2 {
+		t.Errorf("Expecting order 2 to trade, got %d resting orders and sequence %d instead", m.Size(), m.LastSeq())
+	}
+	if err := m.Submit(sell); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Submit(buy); err != ErrOverflow {
+		t.Errorf("Expecting %v, got %v instead", ErrOverflow, err)
+	}
+	if m.Size() != 1 || m.LastSeq() != 3 || jw.Seq() != 3 {
+		t.Errorf("Expecting only order 3 resting, got %d resting orders and sequence %d instead", m.Size(), m.LastSeq())
+	}
+}
@@ -203,6 +203,27 @@ func TestMidPriceBigSell(t *testing.T) {
 	verifyResponse(t, output, responseVals{price: 7, amount: 1, tradeId: 1, counterParty: trader1})
 }
 
+// A resting buy filled by a bigger sell is FULL, the sell PARTIAL
+func TestBigSellKinds(t *testing.T) {
+	output := cbuf.New(20)
+	m := NewMatcher(100, output)
+	b := &trade.OrderData{}
+	b.WriteBuy(trade.CostData{Price: 9, Amount: 1}, trade.TradeData{TraderId: trader1, TradeId: 1, StockId: stockId})
+	m.Submit(b)
+	s := &trade.OrderData{}
+	s.WriteSell(trade.CostData{Price: 6, Amount: 10}, trade.TradeData{TraderId: trader2, TradeId: 1, StockId: stockId})
+	m.Submit(s)
+	for _, kind := range []trade.ResponseKind{trade.FULL, trade.PARTIAL} {
+		r, err := output.GetForRead()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Kind != kind {
+			t.Errorf("Expecting %v, got %v instead", kind, r.Kind)
+		}
+	}
+}
+
 // Test matches lonely buy/sell pair, buy > quantity, and uses the mid-price point for trade price
 func TestMidPriceBigBuy(t *testing.T) {
 	output := cbuf.New(20)
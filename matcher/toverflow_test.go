@@ -0,0 +1,148 @@
+package matcher
+
+import (
+	"bytes"
+	"github.com/fmstephe/matching_engine/cbuf"
+	"github.com/fmstephe/matching_engine/journal"
+	"github.com/fmstephe/matching_engine/trade"
+	"testing"
+)
+
+var toverflowOrderMaker = trade.NewOrderMaker()
+
+// The capacity reserved for each order is exactly the number of responses it writes
+func TestReserveExact(t *testing.T) {
+	orders, err := toverflowOrderMaker.RndTradeSet(1000, 10, 1, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rb := cbuf.New(len(orders) * 4)
+	m := NewMatcher(len(orders), rb)
+	for i := range orders {
+		o := trade.NewOrderFromData(&orders[i])
+		need := 1
+		if o.Kind() != trade.CANCEL {
+			need = 2 * m.matchTrees.Trades(o)
+		}
+		writes := rb.Writes()
+		m.Submit(&orders[i])
+		if rb.Writes()-writes != need {
+			t.Errorf("Expecting %d responses, got %d instead", need, rb.Writes()-writes)
+		}
+	}
+}
+
+// Three resting sells which a single buy will trade with, causing six responses
+func overflowBook(m *M) *trade.OrderData {
+	od := &trade.OrderData{}
+	for i := uint32(1); i <= 3; i++ {
+		od.WriteSell(trade.CostData{Price: 10, Amount: 1}, trade.TradeData{TraderId: i, TradeId: i, StockId: 1})
+		m.Submit(od)
+	}
+	od.WriteBuy(trade.CostData{Price: 10, Amount: 3}, trade.TradeData{TraderId: 9, TradeId: 9, StockId: 1})
+	return od
+}
+
+func TestOverflowPanic(t *testing.T) {
+	slab := trade.NewSlab(4)
+	m := NewMatcherFromSlab(slab, cbuf.New(4))
+	buy := overflowBook(m)
+	digest := m.Digest()
+	// The only order left in the slab, the panicking buy is allocated it
+	free := slab.Malloc()
+	slab.Free(free)
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("Expecting a panic")
+			}
+		}()
+		m.Submit(buy)
+	}()
+	if m.Size() != 3 || m.Digest() != digest {
+		t.Errorf("Expecting an untouched book")
+	}
+	if slab.Malloc() != free {
+		t.Errorf("Expecting the panicking order to be freed")
+	}
+}
+
+func TestOverflowReject(t *testing.T) {
+	m := NewMatcher(10, cbuf.New(4))
+	var buf bytes.Buffer
+	jw, _ := journal.NewWriter(&buf, 0, 0)
+	m.SetJournal(jw)
+	m.SetOverflowPolicy(OVERFLOW_REJECT)
+	buy := overflowBook(m)
+	digest := m.Digest()
+	if err := m.Submit(buy); err != ErrOverflow {
+		t.Errorf("Expecting %v, got %v instead", ErrOverflow, err)
+	}
+	if m.Size() != 3 || m.Digest() != digest {
+		t.Errorf("Expecting an untouched book")
+	}
+	if jw.Seq() != 3 {
+		t.Errorf("Expecting %d orders journalled, got %d instead", 3, jw.Seq())
+	}
+}
+
+// Room for the deltas and quote an order could cause is reserved along with its responses
+func TestOverflowFeeds(t *testing.T) {
+	m := NewMatcher(10, cbuf.New(16))
+	m.SetOverflowPolicy(OVERFLOW_REJECT)
+	buy := overflowBook(m)
+	digest := m.Digest()
+	// Three trades, and the buy if it rested, write up to eight deltas
+	m.SetDeltaBuffer(cbuf.NewDelta(4))
+	if err := m.Submit(buy); err != ErrOverflow {
+		t.Errorf("Expecting %v, got %v instead", ErrOverflow, err)
+	}
+	m.SetDeltaBuffer(nil)
+	qb := cbuf.NewQuote(2)
+	qb.GetForWrite()
+	qb.GetForWrite()
+	m.SetQuoteBuffer(qb)
+	if err := m.Submit(buy); err != ErrOverflow {
+		t.Errorf("Expecting %v, got %v instead", ErrOverflow, err)
+	}
+	if m.Size() != 3 || m.Digest() != digest {
+		t.Errorf("Expecting an untouched book")
+	}
+	m.SetQuoteBuffer(nil)
+	m.SetDeltaBuffer(cbuf.NewDelta(8))
+	if err := m.Submit(buy); err != nil || m.Size() != 0 {
+		t.Errorf("Expecting the buy to trade, got %v and %d resting orders instead", err, m.Size())
+	}
+}
+
+func TestOverflowSpill(t *testing.T) {
+	rb := cbuf.New(4)
+	m := NewMatcher(10, rb)
+	m.SetOverflowPolicy(OVERFLOW_SPILL)
+	buy := overflowBook(m)
+	if err := m.Submit(buy); err != nil {
+		t.Fatal(err)
+	}
+	if m.Size() != 0 {
+		t.Errorf("Expecting an empty book, got %d orders instead", m.Size())
+	}
+	refrb := cbuf.New(8)
+	ref := NewMatcher(10, refrb)
+	ref.Submit(overflowBook(ref))
+	for {
+		r, err := rb.GetForRead()
+		if err != nil {
+			if m.Flush() == 0 && rb.Reads() == rb.Writes() {
+				break
+			}
+			continue
+		}
+		expected, _ := refrb.GetForRead()
+		if *r != *expected {
+			t.Errorf("Expecting %v, got %v instead", *expected, *r)
+		}
+	}
+	if rb.Reads() != refrb.Writes() {
+		t.Errorf("Expecting %d responses, got %d instead", refrb.Writes(), rb.Reads())
+	}
+}
@@ -0,0 +1,81 @@
+package matcher
+
+import (
+	"github.com/fmstephe/matching_engine/cbuf"
+	"github.com/fmstephe/matching_engine/trade"
+	"testing"
+)
+
+func TestQuote(t *testing.T) {
+	rb := cbuf.New(20)
+	qb := cbuf.NewQuote(20)
+	m := NewMatcher(100, rb)
+	m.SetQuoteBuffer(qb)
+	// New best bid
+	submitQuoteOrder(m, trade.BUY, 5, 10, 1)
+	verifyQuote(t, qb, trade.Quote{StockId: stockId, BidPrice: 5, BidSize: 10})
+	// Worse bid, no change
+	submitQuoteOrder(m, trade.BUY, 4, 10, 2)
+	verifyNoQuote(t, qb)
+	// New best ask
+	submitQuoteOrder(m, trade.SELL, 8, 3, 3)
+	verifyQuote(t, qb, trade.Quote{StockId: stockId, BidPrice: 5, BidSize: 10, AskPrice: 8, AskSize: 3})
+	// Joins the best ask level
+	submitQuoteOrder(m, trade.SELL, 8, 4, 4)
+	verifyQuote(t, qb, trade.Quote{StockId: stockId, BidPrice: 5, BidSize: 10, AskPrice: 8, AskSize: 7})
+	// Sweeps the best bid and part of the next, coalesced into one quote
+	submitQuoteOrder(m, trade.SELL, 4, 15, 5)
+	verifyQuote(t, qb, trade.Quote{StockId: stockId, BidPrice: 4, BidSize: 5, AskPrice: 8, AskSize: 7})
+	verifyNoQuote(t, qb)
+}
+
+// Quotes are kept for each stock, and orders away from the best prices don't publish one
+func TestQuotePerStock(t *testing.T) {
+	rb := cbuf.New(20)
+	qb := cbuf.NewQuote(20)
+	m := NewMatcher(100, rb)
+	m.SetQuoteBuffer(qb)
+	submitStockQuoteOrder(m, 1, trade.BUY, 5, 10, 1)
+	verifyQuote(t, qb, trade.Quote{StockId: 1, BidPrice: 5, BidSize: 10})
+	// The same book is new to stock 2
+	submitStockQuoteOrder(m, 2, trade.BUY, 3, 10, 2)
+	verifyNoQuote(t, qb)
+	submitStockQuoteOrder(m, 2, trade.SELL, 8, 3, 3)
+	verifyQuote(t, qb, trade.Quote{StockId: 2, BidPrice: 5, BidSize: 10, AskPrice: 8, AskSize: 3})
+	// Cancelling away from the best prices
+	submitStockQuoteOrder(m, 2, trade.CANCEL, 0, 0, 2)
+	verifyNoQuote(t, qb)
+	submitStockQuoteOrder(m, 1, trade.CANCEL, 0, 0, 99)
+	verifyNoQuote(t, qb)
+	// Cancelling the best bid
+	submitStockQuoteOrder(m, 1, trade.CANCEL, 0, 0, 1)
+	verifyQuote(t, qb, trade.Quote{StockId: 1, AskPrice: 8, AskSize: 3})
+	verifyNoQuote(t, qb)
+}
+
+func submitQuoteOrder(m *M, kind trade.OrderKind, price int64, amount uint32, tradeId uint32) {
+	submitStockQuoteOrder(m, stockId, kind, price, amount, tradeId)
+}
+
+func submitStockQuoteOrder(m *M, stockId uint32, kind trade.OrderKind, price int64, amount uint32, tradeId uint32) {
+	od := &trade.OrderData{}
+	od.Write(trade.CostData{Price: price, Amount: amount}, trade.TradeData{TraderId: trader1, TradeId: tradeId, StockId: stockId}, kind)
+	m.Submit(od)
+}
+
+func verifyQuote(t *testing.T, qb *cbuf.Quote, expected trade.Quote) {
+	q, err := qb.GetForRead()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if *q != expected {
+		t.Errorf("Expecting %v, got %v instead", expected, *q)
+	}
+}
+
+func verifyNoQuote(t *testing.T, qb *cbuf.Quote) {
+	if q, err := qb.GetForRead(); err == nil {
+		t.Errorf("Unexpected quote %v", *q)
+	}
+}
@@ -0,0 +1,104 @@
+package matcher
+
+import (
+	"bytes"
+	"github.com/fmstephe/matching_engine/cbuf"
+	"github.com/fmstephe/matching_engine/journal"
+	"github.com/fmstephe/matching_engine/trade"
+	"testing"
+)
+
+var tsnapshotOrderMaker = trade.NewOrderMaker()
+
+func TestSnapshotRestore(t *testing.T) {
+	testSnapshotRestore(t, 100, 10, 1, 20)
+	testSnapshotRestore(t, 1000, 100, 100, 2000)
+}
+
+// A snapshot of another version is rejected before its book is read
+func TestSnapshotVersion(t *testing.T) {
+	m := NewMatcher(10, cbuf.New(10))
+	m.Submit(tsnapshotOrderMaker.MkPricedBuyData(1))
+	var sbuf bytes.Buffer
+	if err := m.WriteSnapshot(&sbuf); err != nil {
+		t.Fatal(err)
+	}
+	b := sbuf.Bytes()
+	b[0]++
+	rm := NewMatcher(10, cbuf.New(10))
+	if err := rm.ReadSnapshot(bytes.NewReader(b)); err != ErrSnapshotVersion {
+		t.Errorf("Expecting %v, got %v instead", ErrSnapshotVersion, err)
+	}
+	if rm.Size() != 0 {
+		t.Errorf("Expecting an empty book, got %d resting orders instead", rm.Size())
+	}
+}
+
+// Runs an uninterrupted matcher alongside one restored from a snapshot, plus journal replay, half way through
+func testSnapshotRestore(t *testing.T, orderPairs, depth int, lowPrice, highPrice int64) {
+	orders, err := tsnapshotOrderMaker.RndTradeSet(orderPairs, depth, lowPrice, highPrice)
+	if err != nil {
+		panic(err.Error())
+	}
+	var jbuf bytes.Buffer
+	jw, _ := journal.NewWriter(&jbuf, 0, 0)
+	rb := cbuf.New(len(orders) * 2)
+	db := cbuf.NewDelta(len(orders) * 4)
+	m := NewMatcher(orderPairs*2, rb)
+	m.SetJournal(jw)
+	m.SetDeltaBuffer(db)
+	half := len(orders) / 2
+	for i := 0; i < half; i++ {
+		m.Submit(&orders[i])
+	}
+	var sbuf bytes.Buffer
+	if err := m.WriteSnapshot(&sbuf); err != nil {
+		t.Fatal(err)
+	}
+	for i := half; i < len(orders); i++ {
+		m.Submit(&orders[i])
+	}
+	rrb := cbuf.New(len(orders) * 2)
+	rdb := cbuf.NewDelta(len(orders) * 4)
+	rm := NewMatcher(orderPairs*2, rrb)
+	rm.SetDeltaBuffer(rdb)
+	if err := rm.ReadSnapshot(&sbuf); err != nil {
+		t.Fatal(err)
+	}
+	if rm.LastSeq() != uint64(half) {
+		t.Errorf("Expecting last sequence %d, got %d instead", half, rm.LastSeq())
+	}
+	jr, _ := journal.NewReader(&jbuf)
+	if _, err := rm.Replay(jr); err != nil {
+		t.Fatal(err)
+	}
+	if rm.Size() != m.Size() {
+		t.Errorf("Expecting %d resting orders, got %d instead", m.Size(), rm.Size())
+	}
+	if rm.Digest() != m.Digest() {
+		t.Errorf("Expecting digest %v, got %v instead", m.Digest(), rm.Digest())
+	}
+	// Skip the responses and deltas produced before the snapshot
+	for rb.Writes()-rb.Reads() > rrb.Writes() {
+		rb.GetForRead()
+	}
+	for rrb.Reads() < rrb.Writes() {
+		r, _ := rb.GetForRead()
+		rr, _ := rrb.GetForRead()
+		if *r != *rr {
+			t.Errorf("Expecting response %v, got %v instead", *r, *rr)
+			return
+		}
+	}
+	for db.Writes()-db.Reads() > rdb.Writes() {
+		db.GetForRead()
+	}
+	for rdb.Reads() < rdb.Writes() {
+		d, _ := db.GetForRead()
+		rd, _ := rdb.GetForRead()
+		if *d != *rd {
+			t.Errorf("Expecting delta %v, got %v instead", *d, *rd)
+			return
+		}
+	}
+}
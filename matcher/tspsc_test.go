@@ -0,0 +1,47 @@
+package matcher
+
+import (
+	"github.com/fmstephe/matching_engine/cbuf"
+	"github.com/fmstephe/matching_engine/trade"
+	"testing"
+)
+
+var tspscOrderMaker = trade.NewOrderMaker()
+
+// A matcher publishing into a small SPSC buffer drained by another goroutine writes the same responses as
+// one writing into a buffer large enough to hold them all
+func TestSPSCResponses(t *testing.T) {
+	orders, err := tspscOrderMaker.RndTradeSet(500, 10, 1, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rb := cbuf.New(len(orders) * 4)
+	m := NewMatcher(len(orders), rb)
+	for i := range orders {
+		m.Submit(&orders[i])
+	}
+	spsc := cbuf.NewSPSC(16, cbuf.ParkWait)
+	sm := NewMatcher(len(orders), spsc)
+	go func() {
+		for i := range orders {
+			sm.Submit(&orders[i])
+		}
+		spsc.Close()
+	}()
+	for {
+		sr, err := spsc.WaitForRead()
+		if err != nil {
+			break
+		}
+		r, err := rb.GetForRead()
+		if err != nil {
+			t.Fatalf("Unexpected response %v", *sr)
+		}
+		if *r != *sr {
+			t.Errorf("Expecting %v, got %v instead", *r, *sr)
+		}
+	}
+	if rb.Reads() != rb.Writes() {
+		t.Errorf("Expecting %d responses, got %d instead", rb.Writes(), rb.Reads())
+	}
+}
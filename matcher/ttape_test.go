@@ -0,0 +1,31 @@
+package matcher
+
+import (
+	"github.com/fmstephe/matching_engine/cbuf"
+	"github.com/fmstephe/matching_engine/stats"
+	"github.com/fmstephe/matching_engine/trade"
+	"testing"
+)
+
+func TestTape(t *testing.T) {
+	rb := cbuf.New(20)
+	m := NewMatcher(100, rb)
+	tape := stats.NewTape(100)
+	m.SetTape(tape)
+	m.SetTime(10)
+	submitQuoteOrder(m, trade.SELL, 6, 1, 1)
+	submitQuoteOrder(m, trade.SELL, 8, 1, 2)
+	submitQuoteOrder(m, trade.BUY, 8, 2, 3)
+	m.SetTime(250)
+	submitQuoteOrder(m, trade.SELL, 4, 1, 4)
+	submitQuoteOrder(m, trade.BUY, 4, 1, 5)
+	s, _ := tape.Stats(stockId)
+	expected := stats.Stats{StockId: stockId, Trades: 3, Volume: 3, Turnover: 7 + 8 + 4, Last: 4, High: 8, Low: 4}
+	if s != expected {
+		t.Errorf("Expecting %v, got %v instead", expected, s)
+	}
+	bars := tape.Bars(stockId)
+	if len(bars) != 2 || bars[0].Start != 0 || bars[1].Start != 200 {
+		t.Errorf("Unexpected bars %v", bars)
+	}
+}
@@ -0,0 +1,115 @@
+package ouch
+
+import (
+	"github.com/fmstephe/matching_engine/soupbin"
+	"net"
+	"testing"
+	"time"
+)
+
+// A minimal OUCH client for driving a gateway in tests
+type client struct {
+	t    *testing.T
+	addr string
+	conn net.Conn
+	r    *soupbin.Reader
+	w    *soupbin.Writer
+}
+
+func dial(t *testing.T, addr string) *client {
+	c := &client{t: t, addr: addr}
+	c.redial()
+	return c
+}
+
+func (c *client) redial() {
+	conn, err := net.Dial("tcp", c.addr)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	c.conn = conn
+	c.r = soupbin.NewReader(conn)
+	c.w = soupbin.NewWriter(conn)
+}
+
+// Logs in as username, returning the sequence number of the next message, or the reason the login was rejected
+func (c *client) login(username, password, session string, seq uint64) (uint64, byte) {
+	l := soupbin.Login{Username: username, Password: password, Session: session, Sequence: seq}
+	c.write(soupbin.LOGIN_REQUEST, l.Encode(nil))
+	kind, payload := c.expectPacket(soupbin.LOGIN_ACCEPTED, soupbin.LOGIN_REJECTED)
+	if kind == soupbin.LOGIN_REJECTED {
+		return 0, payload[0]
+	}
+	a := soupbin.LoginAccepted{}
+	if err := a.Decode(payload); err != nil {
+		c.t.Fatal(err)
+	}
+	return a.Sequence, 0
+}
+
+// Sends a Logout Request and waits for the gateway to close the connection
+func (c *client) logout() {
+	c.write(soupbin.LOGOUT_REQUEST, nil)
+	c.closed()
+}
+
+func (c *client) send(m interface{ Encode([]byte) []byte }) {
+	c.write(soupbin.UNSEQUENCED_DATA, m.Encode(nil))
+}
+
+func (c *client) write(kind byte, payload []byte) {
+	if err := c.w.Write(kind, payload); err != nil {
+		c.t.Fatal(err)
+	}
+	if err := c.w.Flush(); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// Reads the next sequenced message, which must be an OUCH message of msgType
+func (c *client) expect(msgType byte) []byte {
+	c.t.Helper()
+	_, p := c.expectPacket(soupbin.SEQUENCED_DATA)
+	if Type(p) != msgType {
+		c.t.Fatalf("Expecting %c, got %c instead", msgType, Type(p))
+	}
+	return p
+}
+
+// Reads the next packet, which must be one of kinds. Heartbeats are skipped unless one is expected.
+func (c *client) expectPacket(kinds ...byte) (byte, []byte) {
+	c.t.Helper()
+	for {
+		kind, payload, err := c.read()
+		if err != nil {
+			c.t.Fatalf("Expecting %c, got %v instead", kinds[0], err)
+		}
+		for _, k := range kinds {
+			if k == kind {
+				return kind, payload
+			}
+		}
+		if kind != soupbin.SERVER_HEARTBEAT {
+			c.t.Fatalf("Expecting %c, got %c instead", kinds[0], kind)
+		}
+	}
+}
+
+func (c *client) read() (byte, []byte, error) {
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	kind, payload, err := c.r.Read()
+	return kind, append([]byte(nil), payload...), err
+}
+
+// Reads until the gateway closes the connection
+func (c *client) closed() {
+	for {
+		if _, _, err := c.read(); err != nil {
+			return
+		}
+	}
+}
+
+func enter(ref uint32, token string, side byte, qty uint32, price int64) *EnterOrder {
+	return &EnterOrder{UserRefNum: ref, Side: side, Quantity: qty, Symbol: "ACME", Price: price, TimeInForce: '0', Display: 'Y', Capacity: 'A', ISE: 'N', CrossType: 'N', ClOrdId: token}
+}
@@ -0,0 +1,96 @@
+package ouch
+
+import (
+	"github.com/fmstephe/matching_engine/gateway"
+	"github.com/fmstephe/matching_engine/soupbin"
+	"github.com/fmstephe/matching_engine/trade"
+	"net"
+	"time"
+)
+
+type User struct {
+	Password string
+	TraderId uint32
+}
+
+type Config struct {
+	Session string            // The SoupBinTCP session name, at most 10 characters
+	Users   map[string]User   // By SoupBinTCP username
+	Symbols map[string]uint32 // The StockId of each Symbol
+}
+
+// An OUCH 5.0 order entry gateway carried over SoupBinTCP. Each user is a trader, its orders and sequenced
+// messages last for the gateway's session and a user logging in again may have them replayed from any of
+// the last REPLAY_HISTORY sequence numbers. Orders are passed to submit, which must be safe to call from several goroutines, and the
+// engine's responses must be passed to Handle. Engine prices are OUCH prices, in ten thousandths.
+type Gateway struct {
+	cfg     Config
+	hub     *gateway.Hub
+	users   map[string]*session
+	unit    time.Duration // The heartbeat interval, a second outside of tests
+	matches uint64
+}
+
+func NewGateway(cfg Config, submit func(od *trade.OrderData)) *Gateway {
+	return newGateway(cfg, submit, time.Second)
+}
+
+func newGateway(cfg Config, submit func(od *trade.OrderData), unit time.Duration) *Gateway {
+	g := &Gateway{cfg: cfg, hub: gateway.NewHub(submit), users: make(map[string]*session), unit: unit}
+	for username, user := range cfg.Users {
+		s := newSession(g, user.TraderId, g.hub.Add(user.TraderId))
+		g.users[username] = s
+		g.hub.Go(s.run)
+	}
+	return g
+}
+
+// Accepts connections until ln is closed
+func (g *Gateway) Serve(ln net.Listener) error {
+	return gateway.Serve(ln, g.accept)
+}
+
+// Passes a response to the session of the trader it is for, see gateway.Hub. May be called from any
+// goroutine.
+func (g *Gateway) Handle(r *trade.Response) {
+	g.hub.Handle(r)
+}
+
+// Ends the session, every connected user is sent an End of Session
+func (g *Gateway) Close() {
+	g.hub.Close()
+}
+
+// The first packet on a connection must be a Login Request from a known user for this session
+func (g *Gateway) accept(conn net.Conn) {
+	r := soupbin.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(15 * g.unit))
+	kind, payload, err := r.Read()
+	login := soupbin.Login{}
+	if err != nil || kind != soupbin.LOGIN_REQUEST || login.Decode(payload) != nil {
+		conn.Close()
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+	s, ok := g.users[login.Username]
+	if !ok || g.cfg.Users[login.Username].Password != login.Password {
+		reject(conn, soupbin.NOT_AUTHORIZED)
+		return
+	}
+	if login.Session != "" && login.Session != g.cfg.Session {
+		reject(conn, soupbin.SESSION_NOT_AVAILABLE)
+		return
+	}
+	select {
+	case s.logins <- inbound{conn: conn, r: r, seq: login.Sequence}:
+	case <-g.hub.Done():
+		conn.Close()
+	}
+}
+
+func reject(conn net.Conn, reason byte) {
+	w := soupbin.NewWriter(conn)
+	w.Write(soupbin.LOGIN_REJECTED, []byte{reason})
+	w.Flush()
+	conn.Close()
+}
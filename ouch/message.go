@@ -0,0 +1,422 @@
+package ouch
+
+import (
+	"encoding/binary"
+	"errors"
+	"strings"
+)
+
+// Inbound message types
+const (
+	ENTER_ORDER   = 'O'
+	REPLACE_ORDER = 'U'
+	CANCEL_ORDER  = 'X'
+)
+
+// Outbound message types
+const (
+	ORDER_ACCEPTED = 'A'
+	ORDER_REPLACED = 'U'
+	ORDER_CANCELED = 'C'
+	ORDER_EXECUTED = 'E'
+	ORDER_REJECTED = 'J'
+	CANCEL_REJECT  = 'I'
+)
+
+const (
+	symbolLen  = 8
+	clOrdIdLen = 14
+)
+
+var (
+	ErrMalformed   = errors.New("Malformed OUCH message")
+	ErrUnknownType = errors.New("Unknown OUCH message type")
+)
+
+// Returns the type of the message in p, 0 if p is empty
+func Type(p []byte) byte {
+	if len(p) == 0 {
+		return 0
+	}
+	return p[0]
+}
+
+// Message layouts follow OUCH 5.0, all integers are big endian and prices have four implied decimal places.
+// Optional appendages are skipped when read and never written.
+
+type EnterOrder struct {
+	UserRefNum  uint32
+	Side        byte
+	Quantity    uint32
+	Symbol      string
+	Price       int64
+	TimeInForce byte
+	Display     byte
+	Capacity    byte
+	ISE         byte // Intermarket sweep eligibility
+	CrossType   byte
+	ClOrdId     string
+}
+
+func (m *EnterOrder) Encode(b []byte) []byte {
+	b = append(b, ENTER_ORDER)
+	b = binary.BigEndian.AppendUint32(b, m.UserRefNum)
+	b = append(b, m.Side)
+	b = binary.BigEndian.AppendUint32(b, m.Quantity)
+	b = putAlpha(b, m.Symbol, symbolLen)
+	b = binary.BigEndian.AppendUint64(b, uint64(m.Price))
+	b = append(b, m.TimeInForce, m.Display, m.Capacity, m.ISE, m.CrossType)
+	b = putAlpha(b, m.ClOrdId, clOrdIdLen)
+	return binary.BigEndian.AppendUint16(b, 0)
+}
+
+func (m *EnterOrder) Decode(p []byte) error {
+	d := decoder{p: p}
+	d.kind(ENTER_ORDER)
+	m.UserRefNum = d.u32()
+	m.Side = d.u8()
+	m.Quantity = d.u32()
+	m.Symbol = d.alpha(symbolLen)
+	m.Price = int64(d.u64())
+	m.TimeInForce = d.u8()
+	m.Display = d.u8()
+	m.Capacity = d.u8()
+	m.ISE = d.u8()
+	m.CrossType = d.u8()
+	m.ClOrdId = d.alpha(clOrdIdLen)
+	return d.end()
+}
+
+type ReplaceOrder struct {
+	OrigUserRefNum uint32
+	UserRefNum     uint32
+	Quantity       uint32
+	Price          int64
+	TimeInForce    byte
+	Display        byte
+	ISE            byte
+	ClOrdId        string
+}
+
+func (m *ReplaceOrder) Encode(b []byte) []byte {
+	b = append(b, REPLACE_ORDER)
+	b = binary.BigEndian.AppendUint32(b, m.OrigUserRefNum)
+	b = binary.BigEndian.AppendUint32(b, m.UserRefNum)
+	b = binary.BigEndian.AppendUint32(b, m.Quantity)
+	b = binary.BigEndian.AppendUint64(b, uint64(m.Price))
+	b = append(b, m.TimeInForce, m.Display, m.ISE)
+	b = putAlpha(b, m.ClOrdId, clOrdIdLen)
+	return binary.BigEndian.AppendUint16(b, 0)
+}
+
+func (m *ReplaceOrder) Decode(p []byte) error {
+	d := decoder{p: p}
+	d.kind(REPLACE_ORDER)
+	m.OrigUserRefNum = d.u32()
+	m.UserRefNum = d.u32()
+	m.Quantity = d.u32()
+	m.Price = int64(d.u64())
+	m.TimeInForce = d.u8()
+	m.Display = d.u8()
+	m.ISE = d.u8()
+	m.ClOrdId = d.alpha(clOrdIdLen)
+	return d.end()
+}
+
+// Quantity is the order's new open quantity, 0 cancels all of it
+type CancelOrder struct {
+	UserRefNum uint32
+	Quantity   uint32
+}
+
+func (m *CancelOrder) Encode(b []byte) []byte {
+	b = append(b, CANCEL_ORDER)
+	b = binary.BigEndian.AppendUint32(b, m.UserRefNum)
+	b = binary.BigEndian.AppendUint32(b, m.Quantity)
+	return binary.BigEndian.AppendUint16(b, 0)
+}
+
+func (m *CancelOrder) Decode(p []byte) error {
+	d := decoder{p: p}
+	d.kind(CANCEL_ORDER)
+	m.UserRefNum = d.u32()
+	m.Quantity = d.u32()
+	return d.end()
+}
+
+type OrderAccepted struct {
+	Timestamp   uint64 // Nanoseconds since midnight
+	UserRefNum  uint32
+	Side        byte
+	Quantity    uint32
+	Symbol      string
+	Price       int64
+	TimeInForce byte
+	Display     byte
+	OrderRef    uint64
+	Capacity    byte
+	ISE         byte
+	CrossType   byte
+	OrderState  byte
+	ClOrdId     string
+}
+
+func (m *OrderAccepted) Encode(b []byte) []byte {
+	b = append(b, ORDER_ACCEPTED)
+	b = binary.BigEndian.AppendUint64(b, m.Timestamp)
+	b = binary.BigEndian.AppendUint32(b, m.UserRefNum)
+	b = append(b, m.Side)
+	b = binary.BigEndian.AppendUint32(b, m.Quantity)
+	b = putAlpha(b, m.Symbol, symbolLen)
+	b = binary.BigEndian.AppendUint64(b, uint64(m.Price))
+	b = append(b, m.TimeInForce, m.Display)
+	b = binary.BigEndian.AppendUint64(b, m.OrderRef)
+	b = append(b, m.Capacity, m.ISE, m.CrossType, m.OrderState)
+	b = putAlpha(b, m.ClOrdId, clOrdIdLen)
+	return binary.BigEndian.AppendUint16(b, 0)
+}
+
+func (m *OrderAccepted) Decode(p []byte) error {
+	d := decoder{p: p}
+	d.kind(ORDER_ACCEPTED)
+	m.Timestamp = d.u64()
+	m.UserRefNum = d.u32()
+	m.Side = d.u8()
+	m.Quantity = d.u32()
+	m.Symbol = d.alpha(symbolLen)
+	m.Price = int64(d.u64())
+	m.TimeInForce = d.u8()
+	m.Display = d.u8()
+	m.OrderRef = d.u64()
+	m.Capacity = d.u8()
+	m.ISE = d.u8()
+	m.CrossType = d.u8()
+	m.OrderState = d.u8()
+	m.ClOrdId = d.alpha(clOrdIdLen)
+	return d.end()
+}
+
+type OrderReplaced struct {
+	Timestamp      uint64
+	OrigUserRefNum uint32
+	UserRefNum     uint32
+	Side           byte
+	Quantity       uint32
+	Symbol         string
+	Price          int64
+	TimeInForce    byte
+	Display        byte
+	OrderRef       uint64
+	Capacity       byte
+	ISE            byte
+	CrossType      byte
+	OrderState     byte
+	ClOrdId        string
+}
+
+func (m *OrderReplaced) Encode(b []byte) []byte {
+	b = append(b, ORDER_REPLACED)
+	b = binary.BigEndian.AppendUint64(b, m.Timestamp)
+	b = binary.BigEndian.AppendUint32(b, m.OrigUserRefNum)
+	b = binary.BigEndian.AppendUint32(b, m.UserRefNum)
+	b = append(b, m.Side)
+	b = binary.BigEndian.AppendUint32(b, m.Quantity)
+	b = putAlpha(b, m.Symbol, symbolLen)
+	b = binary.BigEndian.AppendUint64(b, uint64(m.Price))
+	b = append(b, m.TimeInForce, m.Display)
+	b = binary.BigEndian.AppendUint64(b, m.OrderRef)
+	b = append(b, m.Capacity, m.ISE, m.CrossType, m.OrderState)
+	b = putAlpha(b, m.ClOrdId, clOrdIdLen)
+	return binary.BigEndian.AppendUint16(b, 0)
+}
+
+func (m *OrderReplaced) Decode(p []byte) error {
+	d := decoder{p: p}
+	d.kind(ORDER_REPLACED)
+	m.Timestamp = d.u64()
+	m.OrigUserRefNum = d.u32()
+	m.UserRefNum = d.u32()
+	m.Side = d.u8()
+	m.Quantity = d.u32()
+	m.Symbol = d.alpha(symbolLen)
+	m.Price = int64(d.u64())
+	m.TimeInForce = d.u8()
+	m.Display = d.u8()
+	m.OrderRef = d.u64()
+	m.Capacity = d.u8()
+	m.ISE = d.u8()
+	m.CrossType = d.u8()
+	m.OrderState = d.u8()
+	m.ClOrdId = d.alpha(clOrdIdLen)
+	return d.end()
+}
+
+// Quantity is the number of shares cancelled
+type OrderCanceled struct {
+	Timestamp  uint64
+	UserRefNum uint32
+	Quantity   uint32
+	Reason     byte
+}
+
+func (m *OrderCanceled) Encode(b []byte) []byte {
+	b = append(b, ORDER_CANCELED)
+	b = binary.BigEndian.AppendUint64(b, m.Timestamp)
+	b = binary.BigEndian.AppendUint32(b, m.UserRefNum)
+	b = binary.BigEndian.AppendUint32(b, m.Quantity)
+	b = append(b, m.Reason)
+	return binary.BigEndian.AppendUint16(b, 0)
+}
+
+func (m *OrderCanceled) Decode(p []byte) error {
+	d := decoder{p: p}
+	d.kind(ORDER_CANCELED)
+	m.Timestamp = d.u64()
+	m.UserRefNum = d.u32()
+	m.Quantity = d.u32()
+	m.Reason = d.u8()
+	return d.end()
+}
+
+type OrderExecuted struct {
+	Timestamp     uint64
+	UserRefNum    uint32
+	Quantity      uint32
+	Price         int64
+	LiquidityFlag byte
+	MatchNumber   uint64
+}
+
+func (m *OrderExecuted) Encode(b []byte) []byte {
+	b = append(b, ORDER_EXECUTED)
+	b = binary.BigEndian.AppendUint64(b, m.Timestamp)
+	b = binary.BigEndian.AppendUint32(b, m.UserRefNum)
+	b = binary.BigEndian.AppendUint32(b, m.Quantity)
+	b = binary.BigEndian.AppendUint64(b, uint64(m.Price))
+	b = append(b, m.LiquidityFlag)
+	b = binary.BigEndian.AppendUint64(b, m.MatchNumber)
+	return binary.BigEndian.AppendUint16(b, 0)
+}
+
+func (m *OrderExecuted) Decode(p []byte) error {
+	d := decoder{p: p}
+	d.kind(ORDER_EXECUTED)
+	m.Timestamp = d.u64()
+	m.UserRefNum = d.u32()
+	m.Quantity = d.u32()
+	m.Price = int64(d.u64())
+	m.LiquidityFlag = d.u8()
+	m.MatchNumber = d.u64()
+	return d.end()
+}
+
+type OrderRejected struct {
+	Timestamp  uint64
+	UserRefNum uint32
+	Reason     uint16
+	ClOrdId    string
+}
+
+func (m *OrderRejected) Encode(b []byte) []byte {
+	b = append(b, ORDER_REJECTED)
+	b = binary.BigEndian.AppendUint64(b, m.Timestamp)
+	b = binary.BigEndian.AppendUint32(b, m.UserRefNum)
+	b = binary.BigEndian.AppendUint16(b, m.Reason)
+	return putAlpha(b, m.ClOrdId, clOrdIdLen)
+}
+
+func (m *OrderRejected) Decode(p []byte) error {
+	d := decoder{p: p}
+	d.kind(ORDER_REJECTED)
+	m.Timestamp = d.u64()
+	m.UserRefNum = d.u32()
+	m.Reason = d.u16()
+	m.ClOrdId = d.alpha(clOrdIdLen)
+	return d.end()
+}
+
+type CancelReject struct {
+	Timestamp  uint64
+	UserRefNum uint32
+}
+
+func (m *CancelReject) Encode(b []byte) []byte {
+	b = append(b, CANCEL_REJECT)
+	b = binary.BigEndian.AppendUint64(b, m.Timestamp)
+	return binary.BigEndian.AppendUint32(b, m.UserRefNum)
+}
+
+func (m *CancelReject) Decode(p []byte) error {
+	d := decoder{p: p}
+	d.kind(CANCEL_REJECT)
+	m.Timestamp = d.u64()
+	m.UserRefNum = d.u32()
+	return d.end()
+}
+
+func putAlpha(b []byte, s string, width int) []byte {
+	if len(s) > width {
+		s = s[:width]
+	}
+	b = append(b, s...)
+	for i := len(s); i < width; i++ {
+		b = append(b, ' ')
+	}
+	return b
+}
+
+// Reads fields in order, remembering the first error. Fields read after an error are zero.
+type decoder struct {
+	p   []byte
+	err error
+}
+
+func (d *decoder) next(n int) []byte {
+	if d.err != nil || len(d.p) < n {
+		if d.err == nil {
+			d.err = ErrMalformed
+		}
+		return make([]byte, n)
+	}
+	b := d.p[:n]
+	d.p = d.p[n:]
+	return b
+}
+
+func (d *decoder) kind(k byte) {
+	if len(d.p) > 0 && d.p[0] != k {
+		d.err = ErrUnknownType
+	}
+	d.next(1)
+}
+
+func (d *decoder) u8() byte {
+	return d.next(1)[0]
+}
+
+func (d *decoder) u16() uint16 {
+	return binary.BigEndian.Uint16(d.next(2))
+}
+
+func (d *decoder) u32() uint32 {
+	return binary.BigEndian.Uint32(d.next(4))
+}
+
+func (d *decoder) u64() uint64 {
+	return binary.BigEndian.Uint64(d.next(8))
+}
+
+func (d *decoder) alpha(n int) string {
+	return strings.TrimRight(string(d.next(n)), " ")
+}
+
+// Skips an appendage, if there is one, and checks nothing is left over
+func (d *decoder) end() error {
+	if d.err == nil && len(d.p) > 0 {
+		d.next(int(d.u16()))
+	}
+	if d.err == nil && len(d.p) > 0 {
+		d.err = ErrMalformed
+	}
+	return d.err
+}
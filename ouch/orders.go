@@ -0,0 +1,360 @@
+package ouch
+
+import (
+	"github.com/fmstephe/matching_engine/trade"
+	"sync/atomic"
+	"time"
+)
+
+// Side values, every kind of sell is a sell to the engine
+const (
+	SIDE_BUY               = 'B'
+	SIDE_SELL              = 'S'
+	SIDE_SELL_SHORT        = 'T'
+	SIDE_SELL_SHORT_EXEMPT = 'E'
+)
+
+// The state of an accepted order
+const STATE_LIVE = 'L'
+
+// Why an order was cancelled
+const (
+	CANCEL_USER_REQUESTED = 'U'
+	CANCEL_SYSTEM         = 'Z' // The rest of a reduced order couldn't be queued for the engine
+)
+
+// The number of completed orders each session remembers, so their tokens can't be reused. Older completed
+// orders are forgotten.
+const COMPLETED_ORDERS = 1024
+
+// The engine doesn't say which side of a trade added liquidity
+const LIQUIDITY_UNKNOWN = ' '
+
+// Why an order, or a replacement, was rejected
+const (
+	REJECT_INVALID_SYMBOL   = 1
+	REJECT_INVALID_SIDE     = 2
+	REJECT_INVALID_QUANTITY = 3
+	REJECT_INVALID_PRICE    = 4
+	REJECT_INVALID_REF      = 5  // UserRefNum wasn't higher than every one before it
+	REJECT_DUPLICATE_TOKEN  = 6  // ClOrdID has already been used
+	REJECT_UNKNOWN_ORDER    = 7  // The order to replace is unknown or done
+	REJECT_PENDING          = 8  // The order to replace is already being cancelled or replaced
+	REJECT_TOO_LATE         = 9  // The order to replace was filled first
+	REJECT_OVERRUN          = 10 // The session has as many orders waiting for the engine as it can queue
+)
+
+type order struct {
+	userRefNum uint32
+	clOrdId    string
+	tradeId    uint32
+	side       byte
+	symbol     string
+	stockId    uint32
+	qty        uint32 // The open quantity when the order was submitted
+	executed   uint32 // Executed since it was submitted
+	price      int64
+	tif        byte
+	display    byte
+	capacity   byte
+	ise        byte
+	crossType  byte
+	done       bool
+	pending    *pending
+}
+
+func (o *order) open() uint32 {
+	return o.qty - o.executed
+}
+
+// A replacement, or a reduction to qty, waiting for the engine to cancel an order
+type pending struct {
+	replace *ReplaceOrder
+	qty     uint32
+}
+
+// Maps a user's orders between OUCH and the engine. Orders are known to the user by UserRefNum and ClOrdID,
+// the token, and to the engine by a guid whose TradeId the gateway assigns. A replace, or a cancel which
+// leaves some of an order open, is a cancel followed, once the engine has cancelled the original, by a new
+// order.
+type orders struct {
+	s         *session
+	byTradeId map[uint32]*order
+	byRef     map[uint32]*order
+	byToken   map[string]*order
+	lastRef   uint32
+	lastTrade uint32
+	od        trade.OrderData
+	completed [COMPLETED_ORDERS]*order // The last completed orders, which are only kept in byToken
+	next      int                      // The next slot of completed to fill
+}
+
+func (os *orders) init(s *session) {
+	os.s = s
+	os.byTradeId = make(map[uint32]*order)
+	os.byRef = make(map[uint32]*order)
+	os.byToken = make(map[string]*order)
+}
+
+// Handles an inbound OUCH message, returning false if it can't be read
+func (os *orders) receive(p []byte) bool {
+	switch Type(p) {
+	case ENTER_ORDER:
+		m := EnterOrder{}
+		if m.Decode(p) != nil {
+			return false
+		}
+		os.enter(&m)
+	case REPLACE_ORDER:
+		m := ReplaceOrder{}
+		if m.Decode(p) != nil {
+			return false
+		}
+		os.replace(&m)
+	case CANCEL_ORDER:
+		m := CancelOrder{}
+		if m.Decode(p) != nil {
+			return false
+		}
+		os.cancel(&m)
+	default:
+		return false
+	}
+	return true
+}
+
+func (os *orders) enter(m *EnterOrder) {
+	reason := os.check(m.UserRefNum, m.ClOrdId, m.Quantity, m.Price)
+	stockId, ok := os.s.g.cfg.Symbols[m.Symbol]
+	switch {
+	case reason != 0:
+	case !ok:
+		reason = REJECT_INVALID_SYMBOL
+	case m.Side != SIDE_BUY && m.Side != SIDE_SELL && m.Side != SIDE_SELL_SHORT && m.Side != SIDE_SELL_SHORT_EXEMPT:
+		reason = REJECT_INVALID_SIDE
+	}
+	if reason != 0 {
+		os.reject(m.UserRefNum, reason, m.ClOrdId)
+		return
+	}
+	o := &order{userRefNum: m.UserRefNum, clOrdId: m.ClOrdId, side: m.Side, symbol: m.Symbol, stockId: stockId, qty: m.Quantity, price: m.Price}
+	o.tif, o.display, o.capacity, o.ise, o.crossType = m.TimeInForce, m.Display, m.Capacity, m.ISE, m.CrossType
+	if !os.add(o) {
+		os.reject(m.UserRefNum, REJECT_OVERRUN, m.ClOrdId)
+		os.s.overrun()
+		return
+	}
+	a := OrderAccepted{Timestamp: timestamp(), UserRefNum: o.userRefNum, Side: o.side, Quantity: o.qty, Symbol: o.symbol, Price: o.price}
+	a.TimeInForce, a.Display, a.OrderRef, a.Capacity, a.ISE, a.CrossType = o.tif, o.display, os.guid(o), o.capacity, o.ise, o.crossType
+	a.OrderState, a.ClOrdId = STATE_LIVE, o.clOrdId
+	os.s.send(a.Encode(nil))
+}
+
+// Returns the reason a new order, or a replacement, must be rejected, 0 if it is valid. Its UserRefNum is
+// used up either way.
+func (os *orders) check(userRefNum uint32, clOrdId string, qty uint32, price int64) uint16 {
+	if userRefNum <= os.lastRef {
+		return REJECT_INVALID_REF
+	}
+	os.lastRef = userRefNum
+	if _, ok := os.byToken[clOrdId]; ok && clOrdId != "" {
+		return REJECT_DUPLICATE_TOKEN
+	}
+	if qty == 0 {
+		return REJECT_INVALID_QUANTITY
+	}
+	if price <= 0 {
+		return REJECT_INVALID_PRICE
+	}
+	return 0
+}
+
+// Gives o the next trade id and submits its open quantity. Returns false, and o is forgotten, if it can't be
+// queued for the engine.
+func (os *orders) add(o *order) bool {
+	o.tradeId = os.lastTrade + 1
+	kind := trade.SELL
+	if o.side == SIDE_BUY {
+		kind = trade.BUY
+	}
+	os.od.Write(trade.CostData{Price: o.price, Amount: o.qty}, os.tradeData(o), kind)
+	if !os.s.q.Submit(&os.od) {
+		return false
+	}
+	os.lastTrade++
+	os.byTradeId[o.tradeId] = o
+	os.byRef[o.userRefNum] = o
+	if o.clOrdId != "" {
+		os.byToken[o.clOrdId] = o
+	}
+	return true
+}
+
+func (os *orders) replace(m *ReplaceOrder) {
+	reason := os.check(m.UserRefNum, m.ClOrdId, m.Quantity, m.Price)
+	o, ok := os.byRef[m.OrigUserRefNum]
+	switch {
+	case reason != 0:
+	case !ok || o.done:
+		reason = REJECT_UNKNOWN_ORDER
+	case o.pending != nil:
+		reason = REJECT_PENDING
+	}
+	if reason != 0 {
+		os.reject(m.UserRefNum, reason, m.ClOrdId)
+		return
+	}
+	if !os.submitCancel(o) {
+		os.reject(m.UserRefNum, REJECT_OVERRUN, m.ClOrdId)
+		os.s.overrun()
+		return
+	}
+	// Hold the token until the replacement is added
+	if m.ClOrdId != "" {
+		os.byToken[m.ClOrdId] = o
+	}
+	r := *m
+	o.pending = &pending{replace: &r}
+}
+
+// A cancel which would leave the order at least as large as it is does nothing
+func (os *orders) cancel(m *CancelOrder) {
+	o, ok := os.byRef[m.UserRefNum]
+	if !ok || o.done || o.pending != nil {
+		os.s.send((&CancelReject{Timestamp: timestamp(), UserRefNum: m.UserRefNum}).Encode(nil))
+		return
+	}
+	if m.Quantity >= o.open() {
+		return
+	}
+	if !os.submitCancel(o) {
+		os.s.send((&CancelReject{Timestamp: timestamp(), UserRefNum: m.UserRefNum}).Encode(nil))
+		os.s.overrun()
+		return
+	}
+	o.pending = &pending{qty: m.Quantity}
+}
+
+// Returns false if the cancel can't be queued for the engine
+func (os *orders) submitCancel(o *order) bool {
+	os.od.Write(trade.CostData{}, os.tradeData(o), trade.CANCEL)
+	return os.s.q.Submit(&os.od)
+}
+
+func (os *orders) tradeData(o *order) trade.TradeData {
+	return trade.TradeData{TraderId: os.s.traderId, TradeId: o.tradeId, StockId: o.stockId}
+}
+
+func (os *orders) guid(o *order) uint64 {
+	return uint64(os.s.traderId)<<32 | uint64(o.tradeId)
+}
+
+// Turns a response from the engine into OUCH messages
+func (os *orders) respond(r *trade.Response) {
+	o, ok := os.byTradeId[r.TradeId]
+	if !ok {
+		return
+	}
+	defer os.complete(o)
+	switch r.Kind {
+	case trade.PARTIAL, trade.FULL:
+		price := r.Price
+		if price < 0 {
+			price = -price
+		}
+		o.executed += r.Amount
+		o.done = r.Kind == trade.FULL
+		e := OrderExecuted{Timestamp: timestamp(), UserRefNum: o.userRefNum, Quantity: r.Amount, Price: price, LiquidityFlag: LIQUIDITY_UNKNOWN}
+		e.MatchNumber = atomic.AddUint64(&os.s.g.matches, 1)
+		os.s.send(e.Encode(nil))
+	case trade.CANCELLED:
+		o.done = true
+		p := o.pending
+		o.pending = nil
+		if p == nil {
+			return
+		}
+		if p.replace != nil {
+			os.replaced(o, p.replace)
+			return
+		}
+		open := o.open()
+		left := min(p.qty, open)
+		overrun := false
+		if left > 0 {
+			n := *o
+			n.qty, n.executed, n.done = left, 0, false
+			overrun = !os.add(&n)
+		}
+		if open > left {
+			os.canceled(o, open-left, CANCEL_USER_REQUESTED)
+		}
+		if overrun {
+			os.canceled(o, left, CANCEL_SYSTEM)
+			os.s.overrun()
+		}
+	case trade.NOT_CANCELLED:
+		p := o.pending
+		o.pending = nil
+		switch {
+		case p == nil:
+		case p.replace != nil:
+			delete(os.byToken, p.replace.ClOrdId)
+			os.reject(p.replace.UserRefNum, REJECT_TOO_LATE, p.replace.ClOrdId)
+		default:
+			os.s.send((&CancelReject{Timestamp: timestamp(), UserRefNum: o.userRefNum}).Encode(nil))
+		}
+	}
+}
+
+// Once o is done, and has no cancel or replacement waiting for the engine, the engine won't mention it
+// again. It is dropped from byTradeId and byRef, unless a reduction has taken its place, and kept in byToken
+// until COMPLETED_ORDERS more orders complete.
+func (os *orders) complete(o *order) {
+	if !o.done || o.pending != nil {
+		return
+	}
+	delete(os.byTradeId, o.tradeId)
+	if os.byRef[o.userRefNum] == o {
+		delete(os.byRef, o.userRefNum)
+	}
+	if old := os.completed[os.next]; old != nil && os.byToken[old.clOrdId] == old {
+		delete(os.byToken, old.clOrdId)
+	}
+	os.completed[os.next] = o
+	os.next = (os.next + 1) % COMPLETED_ORDERS
+}
+
+// Adds the replacement for o, which the engine has cancelled
+func (os *orders) replaced(o *order, m *ReplaceOrder) {
+	n := &order{userRefNum: m.UserRefNum, clOrdId: m.ClOrdId, side: o.side, symbol: o.symbol, stockId: o.stockId, qty: m.Quantity, price: m.Price}
+	n.tif, n.display, n.capacity, n.ise, n.crossType = m.TimeInForce, m.Display, o.capacity, m.ISE, o.crossType
+	if !os.add(n) {
+		delete(os.byToken, m.ClOrdId)
+		os.canceled(o, o.open(), CANCEL_SYSTEM)
+		os.reject(m.UserRefNum, REJECT_OVERRUN, m.ClOrdId)
+		os.s.overrun()
+		return
+	}
+	r := OrderReplaced{Timestamp: timestamp(), OrigUserRefNum: o.userRefNum, UserRefNum: n.userRefNum, Side: n.side, Quantity: n.qty, Symbol: n.symbol, Price: n.price}
+	r.TimeInForce, r.Display, r.OrderRef, r.Capacity, r.ISE, r.CrossType = n.tif, n.display, os.guid(n), n.capacity, n.ise, n.crossType
+	r.OrderState, r.ClOrdId = STATE_LIVE, n.clOrdId
+	os.s.send(r.Encode(nil))
+}
+
+func (os *orders) canceled(o *order, qty uint32, reason byte) {
+	c := OrderCanceled{Timestamp: timestamp(), UserRefNum: o.userRefNum, Quantity: qty, Reason: reason}
+	os.s.send(c.Encode(nil))
+}
+
+func (os *orders) reject(userRefNum uint32, reason uint16, clOrdId string) {
+	r := OrderRejected{Timestamp: timestamp(), UserRefNum: userRefNum, Reason: reason, ClOrdId: clOrdId}
+	os.s.send(r.Encode(nil))
+}
+
+// Nanoseconds since midnight
+func timestamp() uint64 {
+	now := time.Now()
+	y, m, d := now.Date()
+	return uint64(now.Sub(time.Date(y, m, d, 0, 0, 0, 0, now.Location())))
+}
@@ -0,0 +1,195 @@
+package ouch
+
+import (
+	"github.com/fmstephe/matching_engine/gateway"
+	"github.com/fmstephe/matching_engine/soupbin"
+	"net"
+	"time"
+)
+
+// The number of sequenced messages each session can always replay, a login asking for an older message is
+// rejected
+const REPLAY_HISTORY = 1024
+
+// A login, a packet read from a connection, or the error which ended it
+type inbound struct {
+	conn    net.Conn
+	r       *soupbin.Reader
+	seq     uint64 // The sequence number a login asks to start from
+	kind    byte
+	payload []byte
+	err     error
+}
+
+// The SoupBinTCP session of one user. Its goroutine owns the session's state, its connection and its
+// orders.
+type session struct {
+	g        *Gateway
+	traderId uint32
+	logins   chan inbound
+	inbound  chan inbound
+	q        *gateway.Queues
+	sent     [][]byte // The sequenced messages from sentFrom on
+	sentFrom uint64   // The sequence number of sent[0]
+	// Connection state
+	conn     net.Conn
+	w        *soupbin.Writer
+	lastSent time.Time
+	lastRecv time.Time
+	buf      []byte
+	orders   orders
+}
+
+func newSession(g *Gateway, traderId uint32, q *gateway.Queues) *session {
+	s := &session{g: g, traderId: traderId, logins: make(chan inbound), inbound: make(chan inbound), q: q, sentFrom: 1}
+	s.orders.init(s)
+	return s
+}
+
+func (s *session) run() {
+	ticker := time.NewTicker(s.g.unit / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case in := <-s.logins:
+			s.login(in)
+		case in := <-s.inbound:
+			if in.conn != s.conn {
+				continue
+			}
+			if in.err != nil {
+				s.disconnect()
+				continue
+			}
+			s.receive(in.kind, in.payload)
+		case r := <-s.q.Responses:
+			s.orders.respond(&r)
+		case now := <-ticker.C:
+			s.tick(now)
+		case <-s.g.hub.Done():
+			if s.conn != nil {
+				s.write(soupbin.END_OF_SESSION, nil)
+				s.disconnect()
+			}
+			return
+		}
+	}
+}
+
+// Accepts a login, replaying sequenced messages from the one it asks for. A sequence number of 0, or one
+// past the end, asks for new messages only. A login asking for a message which is no longer kept is
+// rejected. A user may only be logged in once.
+func (s *session) login(in inbound) {
+	if s.conn != nil {
+		reject(in.conn, soupbin.NOT_AUTHORIZED)
+		return
+	}
+	end := s.sentFrom + uint64(len(s.sent))
+	next := in.seq
+	if next == 0 || next > end {
+		next = end
+	}
+	if next < s.sentFrom {
+		reject(in.conn, soupbin.SESSION_NOT_AVAILABLE)
+		return
+	}
+	s.conn = in.conn
+	s.w = soupbin.NewWriter(in.conn)
+	s.lastRecv = time.Now()
+	go s.read(in.conn, in.r)
+	accepted := soupbin.LoginAccepted{Session: s.g.cfg.Session, Sequence: next}
+	s.buf = accepted.Encode(s.buf[:0])
+	s.write(soupbin.LOGIN_ACCEPTED, s.buf)
+	for _, p := range s.sent[next-s.sentFrom:] {
+		s.write(soupbin.SEQUENCED_DATA, p)
+	}
+}
+
+func (s *session) read(conn net.Conn, r *soupbin.Reader) {
+	for {
+		kind, payload, err := r.Read()
+		in := inbound{conn: conn, kind: kind, payload: append([]byte(nil), payload...), err: err}
+		select {
+		case s.inbound <- in:
+		case <-s.g.hub.Done():
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Disconnects a client sending orders faster than the engine takes them, once the request which found the
+// queue full has been rejected
+func (s *session) overrun() {
+	s.disconnect()
+}
+
+func (s *session) disconnect() {
+	if s.conn == nil {
+		return
+	}
+	s.conn.Close()
+	s.conn = nil
+	s.w = nil
+}
+
+// Anything a logged in client shouldn't send ends the connection
+func (s *session) receive(kind byte, payload []byte) {
+	s.lastRecv = time.Now()
+	switch kind {
+	case soupbin.CLIENT_HEARTBEAT:
+	case soupbin.LOGOUT_REQUEST:
+		s.disconnect()
+	case soupbin.UNSEQUENCED_DATA:
+		if !s.orders.receive(payload) {
+			s.disconnect()
+		}
+	default:
+		s.disconnect()
+	}
+}
+
+// Sends a heartbeat after a quiet interval and disconnects a client which has been silent for fifteen
+func (s *session) tick(now time.Time) {
+	if s.conn == nil {
+		return
+	}
+	if now.Sub(s.lastRecv) >= 15*s.g.unit {
+		s.disconnect()
+		return
+	}
+	if now.Sub(s.lastSent) >= s.g.unit {
+		s.write(soupbin.SERVER_HEARTBEAT, nil)
+	}
+}
+
+// Sends an OUCH message as the next sequenced message. It is kept for replay and sequenced even while the
+// user is logged out. At least the last REPLAY_HISTORY messages are kept.
+func (s *session) send(p []byte) {
+	if len(s.sent) == 2*REPLAY_HISTORY {
+		n := copy(s.sent, s.sent[REPLAY_HISTORY:])
+		clear(s.sent[n:])
+		s.sent = s.sent[:n]
+		s.sentFrom += REPLAY_HISTORY
+	}
+	s.sent = append(s.sent, p)
+	s.write(soupbin.SEQUENCED_DATA, p)
+}
+
+func (s *session) write(kind byte, payload []byte) {
+	if s.conn == nil {
+		return
+	}
+	s.lastSent = time.Now()
+	// A client which stops reading is disconnected, rather than holding up the session
+	s.conn.SetWriteDeadline(time.Now().Add(15 * s.g.unit))
+	if err := s.w.Write(kind, payload); err != nil {
+		s.disconnect()
+		return
+	}
+	if err := s.w.Flush(); err != nil {
+		s.disconnect()
+	}
+}
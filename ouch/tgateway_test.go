@@ -0,0 +1,283 @@
+package ouch
+
+import (
+	"github.com/fmstephe/matching_engine/gateway"
+	"github.com/fmstephe/matching_engine/soupbin"
+	"github.com/fmstephe/matching_engine/trade"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// Runs a gateway in front of a matcher, returning its address and a function to shut everything down
+func newExchange(t *testing.T, unit time.Duration) (string, func()) {
+	var g *Gateway
+	l := gateway.NewEngine(1000, func(r *trade.Response) {
+		g.Handle(r)
+	})
+	users := map[string]User{"A": {Password: "pa", TraderId: 1}, "B": {Password: "pb", TraderId: 2}}
+	g = newGateway(Config{Session: "S1", Users: users, Symbols: map[string]uint32{"ACME": 1}}, l.Submit, unit)
+	l.Start()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go g.Serve(ln)
+	return ln.Addr().String(), func() {
+		ln.Close()
+		g.Close()
+		l.Stop()
+	}
+}
+
+func decode(t *testing.T, p []byte, m codec) {
+	if err := m.Decode(p); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGatewayOrders(t *testing.T) {
+	addr, stop := newExchange(t, time.Second)
+	defer stop()
+	a := dial(t, addr)
+	a.login("A", "pa", "", 0)
+	b := dial(t, addr)
+	b.login("B", "pb", "S1", 0)
+	a.send(enter(1, "a1", SIDE_BUY, 10, 100))
+	accepted := OrderAccepted{}
+	decode(t, a.expect(ORDER_ACCEPTED), &accepted)
+	if accepted.UserRefNum != 1 || accepted.OrderRef != 1<<32|1 || accepted.Quantity != 10 || accepted.ClOrdId != "a1" {
+		t.Errorf("Expecting order 1 with guid %d, got %v instead", 1<<32|1, accepted)
+	}
+	b.send(enter(1, "b1", SIDE_SELL_SHORT, 4, 100))
+	b.expect(ORDER_ACCEPTED)
+	executed := OrderExecuted{}
+	decode(t, b.expect(ORDER_EXECUTED), &executed)
+	if executed.UserRefNum != 1 || executed.Quantity != 4 || executed.Price != 100 {
+		t.Errorf("Expecting 4 at 100, got %v instead", executed)
+	}
+	decode(t, a.expect(ORDER_EXECUTED), &executed)
+	if executed.UserRefNum != 1 || executed.Quantity != 4 || executed.Price != 100 {
+		t.Errorf("Expecting 4 at 100, got %v instead", executed)
+	}
+	// Replace the rest of order 1 with a larger, higher priced, order then reduce it
+	a.send(&ReplaceOrder{OrigUserRefNum: 1, UserRefNum: 2, Quantity: 8, Price: 101, ClOrdId: "a2"})
+	replaced := OrderReplaced{}
+	decode(t, a.expect(ORDER_REPLACED), &replaced)
+	if replaced.OrigUserRefNum != 1 || replaced.UserRefNum != 2 || replaced.Quantity != 8 || replaced.Price != 101 || replaced.OrderRef != 1<<32|2 || replaced.Side != SIDE_BUY {
+		t.Errorf("Expecting 2 replacing 1, got %v instead", replaced)
+	}
+	a.send(&CancelOrder{UserRefNum: 2, Quantity: 3})
+	canceled := OrderCanceled{}
+	decode(t, a.expect(ORDER_CANCELED), &canceled)
+	if canceled.UserRefNum != 2 || canceled.Quantity != 5 {
+		t.Errorf("Expecting 5 of 2 cancelled, got %v instead", canceled)
+	}
+	b.send(enter(2, "b2", SIDE_SELL, 5, 101))
+	b.expect(ORDER_ACCEPTED)
+	decode(t, b.expect(ORDER_EXECUTED), &executed)
+	decode(t, a.expect(ORDER_EXECUTED), &executed)
+	if executed.UserRefNum != 2 || executed.Quantity != 3 || executed.Price != 101 {
+		t.Errorf("Expecting 3 at 101, got %v instead", executed)
+	}
+	// A filled order, or an unknown one, can't be cancelled
+	a.send(&CancelOrder{UserRefNum: 2})
+	a.expect(CANCEL_REJECT)
+	a.send(&CancelOrder{UserRefNum: 99})
+	a.expect(CANCEL_REJECT)
+	a.send(enter(3, "a3", SIDE_BUY, 7, 50))
+	a.expect(ORDER_ACCEPTED)
+	a.send(&CancelOrder{UserRefNum: 3})
+	decode(t, a.expect(ORDER_CANCELED), &canceled)
+	if canceled.UserRefNum != 3 || canceled.Quantity != 7 {
+		t.Errorf("Expecting 7 of 3 cancelled, got %v instead", canceled)
+	}
+	// Invalid orders
+	rejected := OrderRejected{}
+	bad := []struct {
+		m      codec
+		reason uint16
+	}{
+		{enter(4, "a1", SIDE_BUY, 1, 100), REJECT_DUPLICATE_TOKEN},
+		{enter(4, "a4", SIDE_BUY, 1, 100), REJECT_INVALID_REF},
+		{&EnterOrder{UserRefNum: 5, Side: SIDE_BUY, Quantity: 1, Symbol: "NOPE", Price: 100}, REJECT_INVALID_SYMBOL},
+		{enter(6, "a6", 'Q', 1, 100), REJECT_INVALID_SIDE},
+		{enter(7, "a7", SIDE_BUY, 0, 100), REJECT_INVALID_QUANTITY},
+		{enter(8, "a8", SIDE_BUY, 1, 0), REJECT_INVALID_PRICE},
+		{&ReplaceOrder{OrigUserRefNum: 3, UserRefNum: 9, Quantity: 1, Price: 1}, REJECT_UNKNOWN_ORDER},
+	}
+	for _, tc := range bad {
+		a.send(tc.m)
+		decode(t, a.expect(ORDER_REJECTED), &rejected)
+		if rejected.Reason != tc.reason {
+			t.Errorf("Expecting %d, got %v instead", tc.reason, rejected)
+		}
+	}
+	// A message which can't be read ends the connection
+	a.write(soupbin.UNSEQUENCED_DATA, []byte{'Q'})
+	a.closed()
+}
+
+func TestGatewayReplay(t *testing.T) {
+	addr, stop := newExchange(t, time.Second)
+	a := dial(t, addr)
+	if next, _ := a.login("A", "pa", "", 0); next != 1 {
+		t.Errorf("Expecting %d, got %d instead", 1, next)
+	}
+	a.send(enter(1, "a1", SIDE_BUY, 5, 50))
+	a.expect(ORDER_ACCEPTED)
+	a.send(enter(2, "a2", SIDE_BUY, 5, 40))
+	a.expect(ORDER_ACCEPTED)
+	a.logout()
+	// Fills while logged out are sequenced and replayed from any point
+	b := dial(t, addr)
+	b.login("B", "pb", "", 0)
+	b.send(enter(1, "b1", SIDE_SELL, 5, 40))
+	b.expect(ORDER_ACCEPTED)
+	b.expect(ORDER_EXECUTED)
+	a.redial()
+	if next, _ := a.login("A", "pa", "S1", 2); next != 2 {
+		t.Errorf("Expecting %d, got %d instead", 2, next)
+	}
+	accepted := OrderAccepted{}
+	decode(t, a.expect(ORDER_ACCEPTED), &accepted)
+	if accepted.UserRefNum != 2 {
+		t.Errorf("Expecting %d, got %d instead", 2, accepted.UserRefNum)
+	}
+	executed := OrderExecuted{}
+	decode(t, a.expect(ORDER_EXECUTED), &executed)
+	if executed.UserRefNum != 1 || executed.Quantity != 5 || executed.Price != 45 {
+		t.Errorf("Expecting 5 of 1 at 45, got %v instead", executed)
+	}
+	// A user can only be logged in once
+	if _, reason := dial(t, addr).login("A", "pa", "", 0); reason != soupbin.NOT_AUTHORIZED {
+		t.Errorf("Expecting %c, got %c instead", soupbin.NOT_AUTHORIZED, reason)
+	}
+	a.logout()
+	a.redial()
+	if next, _ := a.login("A", "pa", "", 0); next != 4 {
+		t.Errorf("Expecting %d, got %d instead", 4, next)
+	}
+	if _, reason := dial(t, addr).login("B", "pa", "", 0); reason != soupbin.NOT_AUTHORIZED {
+		t.Errorf("Expecting %c, got %c instead", soupbin.NOT_AUTHORIZED, reason)
+	}
+	if _, reason := dial(t, addr).login("X", "pa", "", 0); reason != soupbin.NOT_AUTHORIZED {
+		t.Errorf("Expecting %c, got %c instead", soupbin.NOT_AUTHORIZED, reason)
+	}
+	if _, reason := dial(t, addr).login("B", "pb", "S0", 0); reason != soupbin.SESSION_NOT_AVAILABLE {
+		t.Errorf("Expecting %c, got %c instead", soupbin.SESSION_NOT_AVAILABLE, reason)
+	}
+	stop()
+	a.expectPacket(soupbin.END_OF_SESSION)
+}
+
+// Completed orders and sent messages are only kept for so long
+func TestGatewayForgets(t *testing.T) {
+	addr, stop := newExchange(t, time.Second)
+	defer stop()
+	a := dial(t, addr)
+	a.login("A", "pa", "", 0)
+	ref := uint32(1)
+	for i := 0; i <= COMPLETED_ORDERS; i++ {
+		a.send(enter(ref, "a"+strconv.Itoa(i), SIDE_BUY, 1, 100))
+		a.expect(ORDER_ACCEPTED)
+		a.send(&CancelOrder{UserRefNum: ref})
+		a.expect(ORDER_CANCELED)
+		ref++
+	}
+	a.send(enter(ref, "a0", SIDE_BUY, 1, 100))
+	a.expect(ORDER_ACCEPTED)
+	rejected := OrderRejected{}
+	a.send(enter(ref+1, "a"+strconv.Itoa(COMPLETED_ORDERS), SIDE_BUY, 1, 100))
+	decode(t, a.expect(ORDER_REJECTED), &rejected)
+	if rejected.Reason != REJECT_DUPLICATE_TOKEN {
+		t.Errorf("Expecting %d, got %v instead", REJECT_DUPLICATE_TOKEN, rejected)
+	}
+	a.logout()
+	// More than 2*REPLAY_HISTORY messages have been sent, the first REPLAY_HISTORY have been dropped
+	if _, reason := dial(t, addr).login("A", "pa", "", 1); reason != soupbin.SESSION_NOT_AVAILABLE {
+		t.Errorf("Expecting %c, got %c instead", soupbin.SESSION_NOT_AVAILABLE, reason)
+	}
+	a.redial()
+	if next, _ := a.login("A", "pa", "", REPLAY_HISTORY+1); next != REPLAY_HISTORY+1 {
+		t.Errorf("Expecting %d, got %d instead", REPLAY_HISTORY+1, next)
+	}
+	a.expect(ORDER_ACCEPTED)
+}
+
+func TestGatewayHeartbeat(t *testing.T) {
+	addr, stop := newExchange(t, 20*time.Millisecond)
+	defer stop()
+	a := dial(t, addr)
+	a.login("A", "pa", "", 0)
+	// A silent client is sent heartbeats, then disconnected
+	a.expectPacket(soupbin.SERVER_HEARTBEAT)
+	start := time.Now()
+	a.closed()
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("Expecting a disconnect after 15 heartbeats, got one after %v instead", elapsed)
+	}
+}
+
+// Orders sent faster than the engine takes them are rejected once the session's queue is full, and the
+// client is disconnected
+func TestGatewayOverrun(t *testing.T) {
+	release := make(chan struct{})
+	users := map[string]User{"A": {Password: "pa", TraderId: 1}}
+	g := newGateway(Config{Session: "S1", Users: users, Symbols: map[string]uint32{"ACME": 1}}, func(od *trade.OrderData) { <-release }, time.Second)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go g.Serve(ln)
+	defer func() {
+		ln.Close()
+		close(release)
+		g.Close()
+	}()
+	a := dial(t, ln.Addr().String())
+	a.login("A", "pa", "", 0)
+	for i := 1; i <= gateway.QUEUE_LEN+2; i++ {
+		a.send(enter(uint32(i), "a"+strconv.Itoa(i), SIDE_BUY, 1, 100))
+	}
+	accepted := 0
+	for {
+		_, p := a.expectPacket(soupbin.SEQUENCED_DATA)
+		if Type(p) == ORDER_REJECTED {
+			rejected := OrderRejected{}
+			decode(t, p, &rejected)
+			if rejected.Reason != REJECT_OVERRUN {
+				t.Errorf("Expecting %d, got %v instead", REJECT_OVERRUN, rejected)
+			}
+			break
+		}
+		accepted++
+	}
+	// The first order may already be with the engine
+	if accepted != gateway.QUEUE_LEN && accepted != gateway.QUEUE_LEN+1 {
+		t.Errorf("Expecting %d orders accepted, got %d instead", gateway.QUEUE_LEN, accepted)
+	}
+	a.closed()
+}
+
+// A replacement rejected because its order was filled first gives up its token
+func TestReplaceTooLate(t *testing.T) {
+	g := newGateway(Config{Session: "S1", Symbols: map[string]uint32{"ACME": 1}}, func(od *trade.OrderData) {}, time.Second)
+	defer g.Close()
+	s := newSession(g, 1, g.hub.Add(1))
+	os := &s.orders
+	os.enter(enter(1, "a1", SIDE_BUY, 5, 100))
+	os.replace(&ReplaceOrder{OrigUserRefNum: 1, UserRefNum: 2, Quantity: 5, Price: 101, ClOrdId: "a2"})
+	os.respond(&trade.Response{Kind: trade.FULL, Price: -100, Amount: 5, TraderId: 1, TradeId: 1})
+	os.respond(&trade.Response{Kind: trade.NOT_CANCELLED, TraderId: 1, TradeId: 1})
+	rejected := OrderRejected{}
+	decode(t, s.sent[len(s.sent)-1], &rejected)
+	if rejected.UserRefNum != 2 || rejected.Reason != REJECT_TOO_LATE {
+		t.Errorf("Expecting 2 rejected with %d, got %v instead", REJECT_TOO_LATE, rejected)
+	}
+	os.enter(enter(3, "a2", SIDE_BUY, 5, 100))
+	if Type(s.sent[len(s.sent)-1]) != ORDER_ACCEPTED {
+		t.Errorf("Expecting %c, got %c instead", ORDER_ACCEPTED, Type(s.sent[len(s.sent)-1]))
+	}
+}
@@ -0,0 +1,60 @@
+package ouch
+
+import (
+	"testing"
+)
+
+type codec interface {
+	Encode([]byte) []byte
+	Decode([]byte) error
+}
+
+func TestMessages(t *testing.T) {
+	messages := []struct {
+		m     codec
+		empty codec
+		size  int
+	}{
+		{enter(1, "T1", SIDE_BUY, 100, 1234500), &EnterOrder{}, 47},
+		{&ReplaceOrder{OrigUserRefNum: 1, UserRefNum: 2, Quantity: 5, Price: 10000, TimeInForce: '0', Display: 'Y', ISE: 'N', ClOrdId: "T2"}, &ReplaceOrder{}, 40},
+		{&CancelOrder{UserRefNum: 2, Quantity: 0}, &CancelOrder{}, 11},
+		{&OrderAccepted{Timestamp: 7, UserRefNum: 1, Side: SIDE_SELL, Quantity: 3, Symbol: "ACME", Price: 5, OrderRef: 1<<32 | 1, OrderState: STATE_LIVE, ClOrdId: "T1"}, &OrderAccepted{}, 64},
+		{&OrderReplaced{Timestamp: 7, OrigUserRefNum: 1, UserRefNum: 2, Quantity: 3, Symbol: "ACME", Price: 5, OrderRef: 2, ClOrdId: "T2"}, &OrderReplaced{}, 68},
+		{&OrderCanceled{Timestamp: 7, UserRefNum: 2, Quantity: 3, Reason: CANCEL_USER_REQUESTED}, &OrderCanceled{}, 20},
+		{&OrderExecuted{Timestamp: 7, UserRefNum: 2, Quantity: 3, Price: 5, LiquidityFlag: LIQUIDITY_UNKNOWN, MatchNumber: 9}, &OrderExecuted{}, 36},
+		{&OrderRejected{Timestamp: 7, UserRefNum: 3, Reason: REJECT_INVALID_SYMBOL, ClOrdId: "T3"}, &OrderRejected{}, 29},
+		{&CancelReject{Timestamp: 7, UserRefNum: 4}, &CancelReject{}, 13},
+	}
+	for _, tc := range messages {
+		p := tc.m.Encode(nil)
+		if len(p) != tc.size {
+			t.Errorf("Expecting %d bytes, got %d instead for %c", tc.size, len(p), p[0])
+		}
+		if err := tc.empty.Decode(p); err != nil {
+			t.Errorf("Expecting no error, got %v instead for %c", err, p[0])
+		}
+		if string(tc.empty.Encode(nil)) != string(p) {
+			t.Errorf("Expecting %v, got %v instead", tc.m, tc.empty)
+		}
+	}
+}
+
+func TestDecode(t *testing.T) {
+	p := (&CancelOrder{UserRefNum: 1}).Encode(nil)
+	m := CancelOrder{}
+	if err := m.Decode(p[:len(p)-3]); err != ErrMalformed {
+		t.Errorf("Expecting %v, got %v instead", ErrMalformed, err)
+	}
+	if err := (&EnterOrder{}).Decode(p); err != ErrUnknownType {
+		t.Errorf("Expecting %v, got %v instead", ErrUnknownType, err)
+	}
+	// An appendage is skipped, anything after it is malformed
+	p[len(p)-1] = 2
+	p = append(p, 0, 0)
+	if err := m.Decode(p); err != nil || m.UserRefNum != 1 {
+		t.Errorf("Expecting %d, got %d (%v) instead", 1, m.UserRefNum, err)
+	}
+	if err := m.Decode(append(p, 0)); err != ErrMalformed {
+		t.Errorf("Expecting %v, got %v instead", ErrMalformed, err)
+	}
+}
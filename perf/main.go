@@ -86,7 +86,15 @@ func getData() []trade.OrderData {
 }
 
 func getItchData() []*trade.Order {
-	ir := itch.NewItchReader(*filePath)
+	f, err := os.Open(*filePath)
+	if err != nil {
+		panic(err.Error())
+	}
+	defer f.Close()
+	ir, err := itch.NewItchReader(f)
+	if err != nil {
+		panic(err.Error())
+	}
 	orders, err := ir.ReadAll()
 	if err != nil {
 		panic(err.Error())
@@ -0,0 +1,498 @@
+package raft
+
+import (
+	"errors"
+	"fmt"
+	"github.com/fmstephe/matching_engine/matcher"
+	"github.com/fmstephe/matching_engine/trade"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+var (
+	ErrStopped        = errors.New("Raft node stopped")
+	ErrLeadershipLost = errors.New("Leadership was lost before the order was committed, it may or may not be committed later")
+)
+
+type NotLeaderError struct {
+	Leader int // The last known leader, -1 if unknown
+}
+
+func (e *NotLeaderError) Error() string {
+	return fmt.Sprintf("Not the leader, last known leader is %d", e.Leader)
+}
+
+type MessageKind int
+
+const (
+	voteRequest = MessageKind(iota)
+	voteResponse
+	appendRequest
+	appendResponse
+)
+
+type Message struct {
+	Kind MessageKind
+	From int
+	To   int
+	Term uint64
+	// voteRequest
+	LastIndex uint64
+	LastTerm  uint64
+	// voteResponse
+	Granted bool
+	// appendRequest
+	PrevIndex uint64
+	PrevTerm  uint64
+	Entries   []Entry
+	Commit    uint64
+	// appendResponse
+	Success bool
+	Match   uint64 // The follower's last matching index, on failure a hint for where to retry
+}
+
+type Entry struct {
+	Term  uint64
+	Noop  bool // Appended by each new leader so entries from earlier terms can be committed
+	Order trade.OrderData
+}
+
+const (
+	follower = iota
+	candidate
+	leader
+)
+
+const maxAppend = 256
+
+type Config struct {
+	Id             int
+	Nodes          int
+	Tick           time.Duration
+	Ticks          <-chan time.Time // If set the node ticks on each receive instead of every Tick
+	ElectionTicks  int              // Elections start after between ElectionTicks and 2*ElectionTicks without a leader
+	HeartbeatTicks int
+}
+
+type proposal struct {
+	od    trade.OrderData
+	index uint64
+	term  uint64
+	done  chan error
+}
+
+// A member of a cluster which agrees on a single sequence of orders. Committed orders are applied, in
+// log order, to the node's own matcher so every node's matcher passes through identical states.
+type Node struct {
+	cfg       Config
+	transport Transport
+	inbox     <-chan Message
+	storage   Storage
+	proposals chan *proposal
+	stop      chan struct{}
+	done      chan struct{}
+	rnd       *rand.Rand
+	// State owned by the run goroutine
+	state    int
+	term     uint64
+	votedFor int
+	log      []Entry // log[0] is a sentinel, real entries begin at index 1
+	commit   uint64
+	votes    int
+	next     []uint64
+	match    []uint64
+	elapsed  int
+	timeout  int
+	leaderId int
+	waiting  []*proposal
+	dirty    bool   // term or votedFor has changed since it was saved
+	unsaved  uint64 // The first log index changed since the log was saved, 0 if none
+	// State shared with other goroutines
+	mu       sync.Mutex
+	m        *matcher.M
+	applied  uint64 // Last log index applied to m
+	orders   uint64 // Orders applied to m, the matcher sequence number
+	isLeader bool
+}
+
+// The matcher must be new, it will only be fed committed orders. A node restarting after a crash must be
+// given the storage it was using, the log it recovers is applied to m again as it is committed. The node never
+// reads m's responses, m must write them to a buffer the caller drains or to a cbuf.Discard.
+func NewNode(cfg Config, transport Transport, inbox <-chan Message, storage Storage, m *matcher.M) (*Node, error) {
+	n := &Node{
+		cfg:       cfg,
+		transport: transport,
+		inbox:     inbox,
+		storage:   storage,
+		proposals: make(chan *proposal),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+		rnd:       rand.New(rand.NewSource(int64(cfg.Id) + 1)),
+		votedFor:  -1,
+		log:       make([]Entry, 1),
+		next:      make([]uint64, cfg.Nodes),
+		match:     make([]uint64, cfg.Nodes),
+		leaderId:  -1,
+		m:         m,
+	}
+	term, votedFor, entries, err := storage.Load()
+	if err != nil {
+		return nil, err
+	}
+	n.term, n.votedFor = term, votedFor
+	n.log = append(n.log, entries...)
+	n.resetTimeout()
+	return n, nil
+}
+
+func (n *Node) Start() {
+	go n.run()
+}
+
+// Stops the node, as if it had crashed. Waiting proposals fail with ErrStopped.
+func (n *Node) Stop() {
+	close(n.stop)
+	<-n.done
+}
+
+// Submits od to the cluster, returning once it has been committed. Only the leader accepts proposals,
+// other nodes return a *NotLeaderError.
+func (n *Node) Propose(od *trade.OrderData) error {
+	p := &proposal{od: *od, done: make(chan error, 1)}
+	select {
+	case n.proposals <- p:
+	case <-n.done:
+		return ErrStopped
+	}
+	return <-p.done
+}
+
+func (n *Node) IsLeader() bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.isLeader
+}
+
+// The number of orders applied to this node's matcher, and its digest
+func (n *Node) Applied() (uint64, trade.Digest) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.orders, n.m.Digest()
+}
+
+func (n *Node) run() {
+	defer close(n.done)
+	ticks := n.cfg.Ticks
+	if ticks == nil {
+		ticker := time.NewTicker(n.cfg.Tick)
+		defer ticker.Stop()
+		ticks = ticker.C
+	}
+	for {
+		select {
+		case <-n.stop:
+			n.failWaiting(ErrStopped)
+			return
+		case m := <-n.inbox:
+			n.step(&m)
+		case p := <-n.proposals:
+			n.propose(p)
+		case <-ticks:
+			n.tick()
+		}
+	}
+}
+
+func (n *Node) tick() {
+	n.elapsed++
+	if n.state == leader {
+		if n.elapsed >= n.cfg.HeartbeatTicks {
+			n.elapsed = 0
+			n.broadcastAppend()
+		}
+		return
+	}
+	if n.elapsed >= n.timeout {
+		n.campaign()
+	}
+}
+
+func (n *Node) resetTimeout() {
+	n.elapsed = 0
+	n.timeout = n.cfg.ElectionTicks + n.rnd.Intn(n.cfg.ElectionTicks)
+}
+
+func (n *Node) lastIndex() uint64 {
+	return uint64(len(n.log) - 1)
+}
+
+func (n *Node) campaign() {
+	n.state = candidate
+	n.term++
+	n.votedFor = n.cfg.Id
+	n.dirty = true
+	n.votes = 1
+	n.leaderId = -1
+	n.resetTimeout()
+	if n.votes > n.cfg.Nodes/2 {
+		n.becomeLeader()
+		return
+	}
+	for id := 0; id < n.cfg.Nodes; id++ {
+		if id != n.cfg.Id {
+			n.send(Message{Kind: voteRequest, To: id, LastIndex: n.lastIndex(), LastTerm: n.log[n.lastIndex()].Term})
+		}
+	}
+}
+
+func (n *Node) becomeFollower(term uint64) {
+	if n.state == leader {
+		n.failWaiting(ErrLeadershipLost)
+		n.setLeader(false)
+	}
+	n.state = follower
+	if term > n.term {
+		n.term = term
+		n.votedFor = -1
+		n.dirty = true
+	}
+	n.resetTimeout()
+}
+
+func (n *Node) becomeLeader() {
+	n.state = leader
+	n.leaderId = n.cfg.Id
+	n.appendEntry(Entry{Term: n.term, Noop: true})
+	for id := range n.next {
+		n.next[id] = n.lastIndex() + 1
+		n.match[id] = 0
+	}
+	n.match[n.cfg.Id] = n.lastIndex()
+	n.setLeader(true)
+	n.elapsed = 0
+	n.maybeCommit()
+	n.broadcastAppend()
+}
+
+func (n *Node) setLeader(isLeader bool) {
+	n.mu.Lock()
+	n.isLeader = isLeader
+	n.mu.Unlock()
+}
+
+func (n *Node) propose(p *proposal) {
+	if n.state != leader {
+		p.done <- &NotLeaderError{Leader: n.leaderId}
+		return
+	}
+	n.appendEntry(Entry{Term: n.term, Order: p.od})
+	p.index = n.lastIndex()
+	p.term = n.term
+	n.waiting = append(n.waiting, p)
+	n.match[n.cfg.Id] = n.lastIndex()
+	n.maybeCommit()
+	n.broadcastAppend()
+}
+
+func (n *Node) step(m *Message) {
+	if m.Term > n.term {
+		n.becomeFollower(m.Term)
+	}
+	switch m.Kind {
+	case voteRequest:
+		upToDate := m.LastTerm > n.log[n.lastIndex()].Term || (m.LastTerm == n.log[n.lastIndex()].Term && m.LastIndex >= n.lastIndex())
+		granted := m.Term == n.term && (n.votedFor == -1 || n.votedFor == m.From) && upToDate
+		if granted {
+			n.votedFor = m.From
+			n.dirty = true
+			n.resetTimeout()
+		}
+		n.send(Message{Kind: voteResponse, To: m.From, Granted: granted})
+	case voteResponse:
+		if n.state == candidate && m.Term == n.term && m.Granted {
+			n.votes++
+			if n.votes > n.cfg.Nodes/2 {
+				n.becomeLeader()
+			}
+		}
+	case appendRequest:
+		n.handleAppend(m)
+	case appendResponse:
+		n.handleAppendResponse(m)
+	}
+}
+
+func (n *Node) handleAppend(m *Message) {
+	if m.Term < n.term {
+		n.send(Message{Kind: appendResponse, To: m.From, Success: false, Match: n.lastIndex()})
+		return
+	}
+	if n.state != follower {
+		n.becomeFollower(m.Term)
+	}
+	n.leaderId = m.From
+	n.resetTimeout()
+	if m.PrevIndex > n.lastIndex() || n.log[m.PrevIndex].Term != m.PrevTerm {
+		hint := n.lastIndex()
+		if m.PrevIndex <= hint {
+			hint = m.PrevIndex - 1
+		}
+		n.send(Message{Kind: appendResponse, To: m.From, Success: false, Match: hint})
+		return
+	}
+	for i, e := range m.Entries {
+		idx := m.PrevIndex + 1 + uint64(i)
+		if idx <= n.lastIndex() {
+			if n.log[idx].Term == e.Term {
+				continue
+			}
+			n.log = n.log[:idx]
+		}
+		n.appendEntry(e)
+	}
+	last := m.PrevIndex + uint64(len(m.Entries))
+	if m.Commit > n.commit {
+		commit := m.Commit
+		if commit > last {
+			commit = last
+		}
+		if commit > n.commit {
+			n.commit = commit
+			n.apply()
+		}
+	}
+	n.send(Message{Kind: appendResponse, To: m.From, Success: true, Match: last})
+}
+
+func (n *Node) handleAppendResponse(m *Message) {
+	if n.state != leader || m.Term != n.term {
+		return
+	}
+	if m.Success {
+		if m.Match > n.match[m.From] {
+			n.match[m.From] = m.Match
+		}
+		n.next[m.From] = n.match[m.From] + 1
+		n.maybeCommit()
+		if n.next[m.From] <= n.lastIndex() {
+			n.sendAppend(m.From)
+		}
+		return
+	}
+	next := m.Match + 1
+	if next >= n.next[m.From] {
+		next = n.next[m.From] - 1
+	}
+	if next < 1 {
+		next = 1
+	}
+	n.next[m.From] = next
+	n.sendAppend(m.From)
+}
+
+func (n *Node) appendEntry(e Entry) {
+	n.log = append(n.log, e)
+	if idx := n.lastIndex(); n.unsaved == 0 || idx < n.unsaved {
+		n.unsaved = idx
+	}
+}
+
+// Saves whatever has changed since the last save, nothing leaves the node, and the leader doesn't count its
+// own entries towards a commit, before this
+func (n *Node) persist() {
+	if n.dirty {
+		if err := n.storage.SaveState(n.term, n.votedFor); err != nil {
+			panic(err.Error())
+		}
+		n.dirty = false
+	}
+	if n.unsaved != 0 {
+		if err := n.storage.SaveEntries(n.unsaved, n.log[n.unsaved:]); err != nil {
+			panic(err.Error())
+		}
+		n.unsaved = 0
+	}
+}
+
+// Commits the highest index of the current term which is held by a majority
+func (n *Node) maybeCommit() {
+	n.persist()
+	for idx := n.lastIndex(); idx > n.commit; idx-- {
+		if n.log[idx].Term != n.term {
+			return
+		}
+		count := 0
+		for _, match := range n.match {
+			if match >= idx {
+				count++
+			}
+		}
+		if count > n.cfg.Nodes/2 {
+			n.commit = idx
+			n.apply()
+			return
+		}
+	}
+}
+
+func (n *Node) apply() {
+	n.mu.Lock()
+	for n.applied < n.commit {
+		n.applied++
+		e := &n.log[n.applied]
+		if e.Noop {
+			continue
+		}
+		n.orders++
+		if err := n.m.Apply(n.orders, &e.Order); err != nil {
+			panic(err.Error())
+		}
+	}
+	n.mu.Unlock()
+	waiting := n.waiting[:0]
+	for _, p := range n.waiting {
+		switch {
+		case p.index > n.commit:
+			waiting = append(waiting, p)
+		case n.log[p.index].Term == p.term:
+			p.done <- nil
+		default:
+			p.done <- ErrLeadershipLost
+		}
+	}
+	n.waiting = waiting
+}
+
+func (n *Node) failWaiting(err error) {
+	for _, p := range n.waiting {
+		p.done <- err
+	}
+	n.waiting = nil
+}
+
+func (n *Node) broadcastAppend() {
+	for id := 0; id < n.cfg.Nodes; id++ {
+		if id != n.cfg.Id {
+			n.sendAppend(id)
+		}
+	}
+}
+
+func (n *Node) sendAppend(to int) {
+	prev := n.next[to] - 1
+	end := n.lastIndex() + 1
+	if end-prev-1 > maxAppend {
+		end = prev + 1 + maxAppend
+	}
+	entries := make([]Entry, end-prev-1)
+	copy(entries, n.log[prev+1:end])
+	n.send(Message{Kind: appendRequest, To: to, PrevIndex: prev, PrevTerm: n.log[prev].Term, Entries: entries, Commit: n.commit})
+}
+
+func (n *Node) send(m Message) {
+	n.persist()
+	m.From = n.cfg.Id
+	m.Term = n.term
+	n.transport.Send(m)
+}
@@ -0,0 +1,51 @@
+package raft
+
+import (
+	"sync"
+)
+
+// Durable storage for the state a node must not lose in a crash. A node saves its term, vote and log
+// before sending any message which depends on them, so a restarted node can't vote twice in a term or
+// forget entries it has acknowledged. An error saving is fatal to the node.
+type Storage interface {
+	// Saves the current term and the node voted for in it, -1 if none
+	SaveState(term uint64, votedFor int) error
+	// Replaces every saved entry from index from on, log indices begin at 1
+	SaveEntries(from uint64, entries []Entry) error
+	// Returns everything saved, votedFor is -1 and the log is empty if nothing has been
+	Load() (term uint64, votedFor int, entries []Entry, err error)
+}
+
+// A Storage which outlives the nodes using it, but not the process. Simulates a node's disk in a single
+// process cluster.
+type MemoryStorage struct {
+	mu       sync.Mutex
+	term     uint64
+	votedFor int
+	entries  []Entry
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{votedFor: -1}
+}
+
+func (s *MemoryStorage) SaveState(term uint64, votedFor int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.term = term
+	s.votedFor = votedFor
+	return nil
+}
+
+func (s *MemoryStorage) SaveEntries(from uint64, entries []Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = append(s.entries[:from-1], entries...)
+	return nil
+}
+
+func (s *MemoryStorage) Load() (uint64, int, []Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.term, s.votedFor, append([]Entry(nil), s.entries...), nil
+}
@@ -0,0 +1,278 @@
+package raft
+
+import (
+	"github.com/fmstephe/matching_engine/cbuf"
+	"github.com/fmstephe/matching_engine/matcher"
+	"github.com/fmstephe/matching_engine/trade"
+	"runtime"
+	"testing"
+	"time"
+)
+
+var traftOrderMaker = trade.NewOrderMaker()
+
+// The number of ticks a test waits for a cluster to make progress
+const maxTicks = 10000
+
+// A cluster whose nodes only tick when the test ticks them
+type cluster struct {
+	net      *Network
+	nodes    []*Node
+	ticks    []chan time.Time
+	storages []*MemoryStorage
+	down     []bool
+	stopped  []bool
+}
+
+func newCluster(size, orders int) *cluster {
+	net := NewNetwork(size, 1024)
+	c := &cluster{net: net, nodes: make([]*Node, size), down: make([]bool, size), stopped: make([]bool, size)}
+	for id := 0; id < size; id++ {
+		c.ticks = append(c.ticks, make(chan time.Time))
+		c.storages = append(c.storages, NewMemoryStorage())
+	}
+	for id := 0; id < size; id++ {
+		c.start(id, orders)
+	}
+	return c
+}
+
+// Starts node id with a new matcher discarding its responses, and whatever its storage holds
+func (c *cluster) start(id, orders int) {
+	cfg := Config{Id: id, Nodes: len(c.nodes), Ticks: c.ticks[id], ElectionTicks: 10, HeartbeatTicks: 2}
+	m := matcher.NewMatcher(orders, &cbuf.Discard{})
+	n, err := NewNode(cfg, c.net, c.net.Inbox(id), c.storages[id], m)
+	if err != nil {
+		panic(err.Error())
+	}
+	c.nodes[id] = n
+	c.stopped[id] = false
+	n.Start()
+}
+
+func (c *cluster) stop() {
+	for id, n := range c.nodes {
+		if !c.stopped[id] {
+			n.Stop()
+		}
+	}
+}
+
+func (c *cluster) crash(id int) {
+	c.net.SetDown(id, true)
+	c.nodes[id].Stop()
+	c.down[id] = true
+	c.stopped[id] = true
+}
+
+// Ticks every running node once, then lets them handle the messages the tick caused
+func (c *cluster) tick() {
+	for id, ticks := range c.ticks {
+		if !c.stopped[id] {
+			ticks <- time.Time{}
+		}
+	}
+	runtime.Gosched()
+}
+
+func (c *cluster) leader(t *testing.T) int {
+	for i := 0; i < maxTicks; i++ {
+		for id, n := range c.nodes {
+			if !c.down[id] && n.IsLeader() {
+				return id
+			}
+		}
+		c.tick()
+	}
+	t.Fatal("No leader elected")
+	return -1
+}
+
+// Ticks the cluster until done delivers
+func (c *cluster) await(t *testing.T, done <-chan error) error {
+	for i := 0; i < maxTicks; i++ {
+		select {
+		case err := <-done:
+			return err
+		default:
+			c.tick()
+		}
+	}
+	t.Fatal("Proposal never completed")
+	return nil
+}
+
+// Proposes od to the current leader, retrying when a node turns out not to be the leader
+func (c *cluster) propose(t *testing.T, od *trade.OrderData) {
+	for {
+		n := c.nodes[c.leader(t)]
+		done := make(chan error, 1)
+		go func() { done <- n.Propose(od) }()
+		err := c.await(t, done)
+		if err == nil {
+			return
+		}
+		if _, ok := err.(*NotLeaderError); !ok {
+			t.Fatalf("Unexpected proposal failure %s", err.Error())
+		}
+	}
+}
+
+// Waits for every running node to apply orders orders and checks each matcher's digest against expected
+func (c *cluster) checkApplied(t *testing.T, orders uint64, expected trade.Digest) {
+	for id, n := range c.nodes {
+		if c.down[id] {
+			continue
+		}
+		applied, digest := n.Applied()
+		for i := 0; applied < orders && i < maxTicks; i++ {
+			c.tick()
+			applied, digest = n.Applied()
+		}
+		if applied != orders {
+			t.Errorf("Expecting %d orders applied on node %d, got %d instead", orders, id, applied)
+		}
+		if digest != expected {
+			t.Errorf("Expecting digest %v on node %d, got %v instead", expected, id, digest)
+		}
+	}
+}
+
+func newReference(orders int) *matcher.M {
+	return matcher.NewMatcher(orders, cbuf.New(orders*4))
+}
+
+func TestLeaderCrash(t *testing.T) {
+	orders, err := traftOrderMaker.RndTradeSet(200, 20, 1, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newCluster(5, len(orders))
+	defer c.stop()
+	ref := newReference(len(orders))
+	half := len(orders) / 2
+	for i := 0; i < half; i++ {
+		c.propose(t, &orders[i])
+		ref.Submit(&orders[i])
+	}
+	// Every acknowledged order survives the loss of the leader
+	c.crash(c.leader(t))
+	for i := half; i < len(orders); i++ {
+		c.propose(t, &orders[i])
+		ref.Submit(&orders[i])
+	}
+	c.checkApplied(t, uint64(len(orders)), ref.Digest())
+}
+
+func TestPartitionedLeader(t *testing.T) {
+	orders, err := traftOrderMaker.RndTradeSet(100, 20, 1, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newCluster(3, len(orders))
+	defer c.stop()
+	ref := newReference(len(orders))
+	half := len(orders) / 2
+	for i := 0; i < half; i++ {
+		c.propose(t, &orders[i])
+		ref.Submit(&orders[i])
+	}
+	// An isolated leader can't commit, its proposal is replaced by the majority's log
+	old := c.leader(t)
+	c.net.SetDown(old, true)
+	stranded := make(chan error, 1)
+	go func() { stranded <- c.nodes[old].Propose(&orders[half]) }()
+	c.down[old] = true
+	for i := half + 1; i < len(orders); i++ {
+		c.propose(t, &orders[i])
+		ref.Submit(&orders[i])
+	}
+	c.net.SetDown(old, false)
+	c.down[old] = false
+	if err := c.await(t, stranded); err != ErrLeadershipLost {
+		t.Errorf("Expecting %v, got %v instead", ErrLeadershipLost, err)
+	}
+	c.checkApplied(t, uint64(len(orders)-1), ref.Digest())
+}
+
+func TestNotLeader(t *testing.T) {
+	c := newCluster(3, 10)
+	defer c.stop()
+	l := c.leader(t)
+	f := (l + 1) % 3
+	od := &trade.OrderData{}
+	od.WriteBuy(trade.CostData{Price: 1, Amount: 1}, trade.TradeData{TraderId: 1, TradeId: 1, StockId: 1})
+	if _, ok := c.nodes[f].Propose(od).(*NotLeaderError); !ok {
+		t.Errorf("Expecting a NotLeaderError from a follower")
+	}
+}
+
+// A node restarted from its storage keeps its log, and rejoins the cluster
+func TestRestart(t *testing.T) {
+	orders, err := traftOrderMaker.RndTradeSet(50, 10, 1, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newCluster(3, len(orders))
+	defer c.stop()
+	ref := newReference(len(orders))
+	for i := range orders {
+		c.propose(t, &orders[i])
+		ref.Submit(&orders[i])
+	}
+	for id := range c.nodes {
+		c.crash(id)
+	}
+	for id := range c.nodes {
+		c.net.SetDown(id, false)
+		c.down[id] = false
+		c.start(id, len(orders))
+	}
+	// Entries from an earlier term are only applied once the new leader commits one of its own
+	c.leader(t)
+	c.checkApplied(t, uint64(len(orders)), ref.Digest())
+}
+
+// A recording transport, for driving a single node
+type outbox chan Message
+
+func (o outbox) Send(m Message) {
+	o <- m
+}
+
+// A node restarted in the same term won't vote for a second candidate, or forget its log
+func TestRestartVote(t *testing.T) {
+	storage := NewMemoryStorage()
+	out := make(outbox, 16)
+	start := func() (*Node, chan Message) {
+		inbox := make(chan Message)
+		cfg := Config{Id: 0, Nodes: 3, Ticks: make(chan time.Time), ElectionTicks: 10, HeartbeatTicks: 2}
+		n, err := NewNode(cfg, out, inbox, storage, newReference(10))
+		if err != nil {
+			t.Fatal(err)
+		}
+		n.Start()
+		return n, inbox
+	}
+	expect := func(kind MessageKind, ok bool) {
+		m := <-out
+		if m.Kind != kind || m.Granted != ok && kind == voteResponse || m.Success != ok && kind == appendResponse {
+			t.Errorf("Expecting message %d (%v), got %+v instead", kind, ok, m)
+		}
+	}
+	n, inbox := start()
+	inbox <- Message{Kind: voteRequest, From: 1, To: 0, Term: 5}
+	expect(voteResponse, true)
+	inbox <- Message{Kind: appendRequest, From: 1, To: 0, Term: 5, Entries: []Entry{{Term: 5, Noop: true}}}
+	expect(appendResponse, true)
+	n.Stop()
+	n, inbox = start()
+	defer n.Stop()
+	inbox <- Message{Kind: voteRequest, From: 2, To: 0, Term: 5, LastIndex: 1, LastTerm: 5}
+	expect(voteResponse, false)
+	// A candidate in a later term without the entry isn't up to date
+	inbox <- Message{Kind: voteRequest, From: 2, To: 0, Term: 6}
+	expect(voteResponse, false)
+	inbox <- Message{Kind: voteRequest, From: 2, To: 0, Term: 7, LastIndex: 1, LastTerm: 5}
+	expect(voteResponse, true)
+}
@@ -0,0 +1,50 @@
+package raft
+
+import (
+	"sync"
+)
+
+type Transport interface {
+	// Delivers m to node m.To. Delivery is not guaranteed, messages may be dropped.
+	Send(m Message)
+}
+
+// An in-memory Transport connecting the nodes of a single process cluster. Nodes can be taken down and
+// brought back to simulate crashes and partitions.
+type Network struct {
+	mu      sync.Mutex
+	inboxes []chan Message
+	down    []bool
+}
+
+func NewNetwork(nodes, inboxSize int) *Network {
+	inboxes := make([]chan Message, nodes)
+	for i := range inboxes {
+		inboxes[i] = make(chan Message, inboxSize)
+	}
+	return &Network{inboxes: inboxes, down: make([]bool, nodes)}
+}
+
+func (n *Network) Send(m Message) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.down[m.From] || n.down[m.To] {
+		return
+	}
+	select {
+	case n.inboxes[m.To] <- m:
+	default:
+		// A full inbox behaves like a lossy link
+	}
+}
+
+// While a node is down nothing it sends is delivered, and nothing is delivered to it
+func (n *Network) SetDown(id int, down bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.down[id] = down
+}
+
+func (n *Network) Inbox(id int) <-chan Message {
+	return n.inboxes[id]
+}
@@ -0,0 +1,151 @@
+package replication
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/fmstephe/matching_engine/journal"
+	"github.com/fmstephe/matching_engine/matcher"
+	"github.com/fmstephe/matching_engine/trade"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+type DivergedError struct {
+	Checkpoint Checkpoint
+	Digest     trade.Digest
+}
+
+func (e *DivergedError) Error() string {
+	return fmt.Sprintf("Backup diverged from primary at sequence %d, primary %v backup %v", e.Checkpoint.Seq, e.Checkpoint.Digest, e.Digest)
+}
+
+// Applies the order stream of a primary to its own matcher. The matcher must not have a journal of its own,
+// it is fed only through Apply.
+type Backup struct {
+	m        *matcher.M
+	seq      uint64     // Last sequence number applied, read atomically
+	mu       sync.Mutex // Guards verified and diverged, which Run writes
+	verified Checkpoint
+	diverged *DivergedError
+}
+
+// The backup never reads m's responses, m must write them to a buffer the caller drains or to a
+// cbuf.Discard, otherwise a backup applying a long stream will overflow it.
+func NewBackup(m *matcher.M) *Backup {
+	return &Backup{m: m, seq: m.LastSeq()}
+}
+
+// The sequence number of the last order applied, safe to call while Run is active
+func (b *Backup) Seq() uint64 {
+	return atomic.LoadUint64(&b.seq)
+}
+
+// The last checkpoint which matched this backup's digest
+func (b *Backup) Verified() Checkpoint {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.verified
+}
+
+func (b *Backup) divergence() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.diverged == nil {
+		return nil
+	}
+	return b.diverged
+}
+
+// Connects to a primary at addr and applies its stream until the connection fails. A sequence gap is
+// returned as a *journal.SequenceError and a digest mismatch as a *DivergedError, after which the backup
+// can't be promoted. In all other cases Run can be called again to reconnect and catch up.
+func (b *Backup) Run(addr string) error {
+	if err := b.divergence(); err != nil {
+		return err
+	}
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := writeHello(conn, b.m.LastSeq()+1); err != nil {
+		return err
+	}
+	r := bufio.NewReader(conn)
+	var buf [journal.RecordLen]byte
+	od := &trade.OrderData{}
+	var c Checkpoint
+	for {
+		t, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		switch t {
+		case orderFrame:
+			if _, err := io.ReadFull(r, buf[:journal.RecordLen]); err != nil {
+				return err
+			}
+			seq, err := journal.DecodeRecord(buf[:], od)
+			if err != nil {
+				return err
+			}
+			if err := b.m.Apply(seq, od); err != nil {
+				return err
+			}
+			atomic.StoreUint64(&b.seq, seq)
+		case checkpointFrame:
+			if _, err := io.ReadFull(r, buf[:checkpointLen]); err != nil {
+				return err
+			}
+			decodeCheckpoint(buf[:], &c)
+			if err := b.check(&c); err != nil {
+				return err
+			}
+		default:
+			return &FrameError{Type: t}
+		}
+	}
+}
+
+// Checkpoints always follow the order they describe, so they can be checked immediately
+func (b *Backup) check(c *Checkpoint) error {
+	if c.Seq != b.m.LastSeq() {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if d := b.m.Digest(); d != c.Digest {
+		b.diverged = &DivergedError{Checkpoint: *c, Digest: d}
+		return b.diverged
+	}
+	b.verified = *c
+	return nil
+}
+
+// Takes over from a failed primary. Any orders in the primary's journal, if provided, which never reached
+// this backup are applied first. A damaged final record is ignored as the primary can't have acknowledged
+// it. Promotion fails if any checkpoint received did not match, or if final is not nil and does not match
+// the state after catching up. Returns the sequence number the new primary's journal must continue from.
+func (b *Backup) Promote(primaryJournal io.Reader, final *Checkpoint) (uint64, error) {
+	if err := b.divergence(); err != nil {
+		return 0, err
+	}
+	if primaryJournal != nil {
+		jr, err := journal.NewReader(primaryJournal)
+		if err != nil {
+			return 0, err
+		}
+		if _, err := b.m.Replay(jr); err != nil && err != io.ErrUnexpectedEOF {
+			return 0, err
+		}
+		atomic.StoreUint64(&b.seq, b.m.LastSeq())
+	}
+	if final != nil {
+		if err := b.check(final); err != nil {
+			return 0, err
+		}
+	}
+	return b.m.LastSeq(), nil
+}
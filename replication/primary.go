@@ -0,0 +1,231 @@
+package replication
+
+import (
+	"bufio"
+	"github.com/fmstephe/matching_engine/journal"
+	"github.com/fmstephe/matching_engine/matcher"
+	"github.com/fmstephe/matching_engine/trade"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// The number of frames a backup can fall behind the live stream before it is dropped
+const QUEUE_LEN = 4096
+
+// How long a write to a backup may block before the backup is dropped
+const WRITE_TIMEOUT = 5 * time.Second
+
+const maxFrameLen = max(1+journal.RecordLen, 1+checkpointLen)
+
+type frame struct {
+	buf [maxFrameLen]byte
+	n   int
+}
+
+// Each backup is written to by its own goroutine, draining a queue of frames, so a slow or dead backup
+// never holds up Submit
+type backupConn struct {
+	conn    net.Conn
+	w       *bufio.Writer
+	frames  chan frame
+	dropped bool // Guarded by Primary.mu
+}
+
+// Wraps a journalling matcher and ships every order it accepts to connected backups. A backup which
+// connects late, or reconnects, is first caught up from the journal.
+type Primary struct {
+	mu              sync.Mutex
+	m               *matcher.M
+	openJournal     func() (io.ReadCloser, error)
+	checkpointEvery uint64
+	backups         []*backupConn
+	buf             [1 + journal.RecordLen]byte
+	cbuf            [1 + checkpointLen]byte
+}
+
+// m must have a journal, openJournal must open that same journal for reading from its beginning. A
+// checkpoint digest is sent after every checkpointEvery orders, 0 sends none.
+func NewPrimary(m *matcher.M, openJournal func() (io.ReadCloser, error), checkpointEvery uint64) *Primary {
+	return &Primary{m: m, openJournal: openJournal, checkpointEvery: checkpointEvery}
+}
+
+// Submits od to the matcher and queues it for every backup. Backups which can't be written to within
+// WRITE_TIMEOUT, or which fall QUEUE_LEN frames behind, are dropped. Orders rejected by the matcher are not
+// shipped. An order whose journal record couldn't be synced has been applied, so it is shipped before its
+// *matcher.SyncError is returned.
+func (p *Primary) Submit(od *trade.OrderData) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	err := p.m.Submit(od)
+	if _, ok := err.(*matcher.SyncError); err != nil && !ok {
+		return err
+	}
+	seq := p.m.LastSeq()
+	p.queue(encodeOrder(p.buf[:], seq, od))
+	if p.checkpointEvery > 0 && seq%p.checkpointEvery == 0 {
+		p.queue(encodeCheckpoint(p.cbuf[:], &Checkpoint{Seq: seq, Digest: p.m.Digest()}))
+	}
+	return err
+}
+
+// Queues a checkpoint of the current state for every backup, e.g. before a planned failover
+func (p *Primary) Checkpoint() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.queue(encodeCheckpoint(p.cbuf[:], &Checkpoint{Seq: p.m.LastSeq(), Digest: p.m.Digest()}))
+}
+
+// Must be called holding p.mu
+func (p *Primary) queue(b []byte) {
+	f := frame{n: len(b)}
+	copy(f.buf[:], b)
+	live := p.backups[:0]
+	for _, bc := range p.backups {
+		select {
+		case bc.frames <- f:
+			live = append(live, bc)
+		default:
+			p.drop(bc)
+		}
+	}
+	p.backups = live
+}
+
+// Must be called holding p.mu, the caller removes bc from p.backups
+func (p *Primary) drop(bc *backupConn) {
+	bc.dropped = true
+	close(bc.frames)
+	bc.conn.Close()
+}
+
+// The number of backups currently receiving the live stream
+func (p *Primary) Backups() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.backups)
+}
+
+// Accepts backups until ln is closed
+func (p *Primary) Serve(ln net.Listener) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go p.addBackup(conn)
+	}
+}
+
+// Closes every backup connection
+func (p *Primary) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, b := range p.backups {
+		p.drop(b)
+	}
+	p.backups = nil
+}
+
+func (p *Primary) addBackup(conn net.Conn) {
+	next, err := readHello(conn)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	b := &backupConn{conn: conn, w: bufio.NewWriter(conn), frames: make(chan frame, QUEUE_LEN)}
+	// Catch up most of the way, then go live and catch up the rest, the live stream is queued meanwhile
+	p.mu.Lock()
+	last := p.m.LastSeq()
+	p.mu.Unlock()
+	if next, err = p.catchUp(b, next, last); err != nil {
+		conn.Close()
+		return
+	}
+	p.mu.Lock()
+	last = p.m.LastSeq()
+	p.backups = append(p.backups, b)
+	p.mu.Unlock()
+	if _, err = p.catchUp(b, next, last); err != nil {
+		p.remove(b)
+		return
+	}
+	p.write(b)
+}
+
+// Writes b's queued frames until it is dropped or a write fails
+func (p *Primary) write(b *backupConn) {
+	for f := range b.frames {
+		if err := b.send(f.buf[:f.n]); err != nil {
+			p.remove(b)
+			return
+		}
+		// Flush once the queue is empty, a backup which has fallen behind catches up in large writes
+		if len(b.frames) == 0 {
+			if err := b.flush(); err != nil {
+				p.remove(b)
+				return
+			}
+		}
+	}
+}
+
+func (p *Primary) remove(b *backupConn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if b.dropped {
+		return
+	}
+	p.drop(b)
+	for i, bc := range p.backups {
+		if bc == b {
+			p.backups = append(p.backups[:i], p.backups[i+1:]...)
+			return
+		}
+	}
+}
+
+// Sends journal records from next up to and including last, returns the next sequence number to send
+func (p *Primary) catchUp(b *backupConn, next, last uint64) (uint64, error) {
+	if next > last {
+		return next, nil
+	}
+	f, err := p.openJournal()
+	if err != nil {
+		return next, err
+	}
+	defer f.Close()
+	jr, err := journal.NewReader(f)
+	if err != nil {
+		return next, err
+	}
+	var buf [1 + journal.RecordLen]byte
+	od := &trade.OrderData{}
+	for next <= last {
+		seq, err := jr.Next(od)
+		if err != nil {
+			return next, err
+		}
+		if seq < next {
+			continue
+		}
+		if err := b.send(encodeOrder(buf[:], seq, od)); err != nil {
+			return next, err
+		}
+		next = seq + 1
+	}
+	return next, b.flush()
+}
+
+// A write which fills the buffer writes to conn, so every write is given a deadline
+func (b *backupConn) send(f []byte) error {
+	b.conn.SetWriteDeadline(time.Now().Add(WRITE_TIMEOUT))
+	_, err := b.w.Write(f)
+	return err
+}
+
+func (b *backupConn) flush() error {
+	b.conn.SetWriteDeadline(time.Now().Add(WRITE_TIMEOUT))
+	return b.w.Flush()
+}
@@ -0,0 +1,70 @@
+package replication
+
+import (
+	"encoding/binary"
+	"github.com/fmstephe/matching_engine/journal"
+	"github.com/fmstephe/matching_engine/trade"
+	"io"
+)
+
+// Frames exchanged between a primary and its backups, each is a single type byte followed by a fixed size body
+const (
+	helloFrame      = byte('H') // Backup to primary, the next sequence number the backup needs
+	orderFrame      = byte('O') // A journal record
+	checkpointFrame = byte('C') // The primary's digest after applying the order with sequence number Seq
+	helloLen        = 8
+	checkpointLen   = 24
+)
+
+// The primary's digest after applying every order up to and including Seq
+type Checkpoint struct {
+	Seq    uint64
+	Digest trade.Digest
+}
+
+func writeHello(w io.Writer, next uint64) error {
+	var b [1 + helloLen]byte
+	b[0] = helloFrame
+	binary.LittleEndian.PutUint64(b[1:], next)
+	_, err := w.Write(b[:])
+	return err
+}
+
+func readHello(r io.Reader) (next uint64, err error) {
+	var b [1 + helloLen]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return 0, err
+	}
+	if b[0] != helloFrame {
+		return 0, &FrameError{Type: b[0]}
+	}
+	return binary.LittleEndian.Uint64(b[1:]), nil
+}
+
+func encodeOrder(b []byte, seq uint64, od *trade.OrderData) []byte {
+	b[0] = orderFrame
+	journal.EncodeRecord(b[1:], seq, od)
+	return b[:1+journal.RecordLen]
+}
+
+func encodeCheckpoint(b []byte, c *Checkpoint) []byte {
+	b[0] = checkpointFrame
+	binary.LittleEndian.PutUint64(b[1:], c.Seq)
+	binary.LittleEndian.PutUint64(b[9:], c.Digest.Book)
+	binary.LittleEndian.PutUint64(b[17:], c.Digest.Stream)
+	return b[:1+checkpointLen]
+}
+
+func decodeCheckpoint(b []byte, c *Checkpoint) {
+	c.Seq = binary.LittleEndian.Uint64(b[0:])
+	c.Digest.Book = binary.LittleEndian.Uint64(b[8:])
+	c.Digest.Stream = binary.LittleEndian.Uint64(b[16:])
+}
+
+type FrameError struct {
+	Type byte
+}
+
+func (e *FrameError) Error() string {
+	return "Unknown replication frame type " + string([]byte{e.Type})
+}
@@ -0,0 +1,231 @@
+package replication
+
+import (
+	"errors"
+	"github.com/fmstephe/matching_engine/cbuf"
+	"github.com/fmstephe/matching_engine/journal"
+	"github.com/fmstephe/matching_engine/matcher"
+	"github.com/fmstephe/matching_engine/trade"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var treplicationOrderMaker = trade.NewOrderMaker()
+
+type primaryFixture struct {
+	p     *Primary
+	m     *matcher.M
+	ln    net.Listener
+	jpath string
+	w     *failSyncer
+}
+
+// A journal file whose syncs do nothing, or fail
+type failSyncer struct {
+	*os.File
+	fail bool
+}
+
+func (w *failSyncer) Sync() error {
+	if w.fail {
+		return errors.New("Sync failed")
+	}
+	return nil
+}
+
+func newPrimaryFixture(t *testing.T, size int) *primaryFixture {
+	jpath := filepath.Join(t.TempDir(), "journal")
+	f, err := os.Create(jpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	w := &failSyncer{File: f}
+	jw, err := journal.NewWriter(w, 0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := matcher.NewMatcher(size, cbuf.New(size*4))
+	m.SetJournal(jw)
+	open := func() (io.ReadCloser, error) { return os.Open(jpath) }
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := NewPrimary(m, open, 10)
+	go p.Serve(ln)
+	return &primaryFixture{p: p, m: m, ln: ln, jpath: jpath, w: w}
+}
+
+func (pf *primaryFixture) stop() {
+	pf.ln.Close()
+	pf.p.Close()
+}
+
+func TestFailover(t *testing.T) {
+	orders, err := treplicationOrderMaker.RndTradeSet(200, 20, 1, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pf := newPrimaryFixture(t, len(orders))
+	third := len(orders) / 3
+	// Orders before the backup connects are caught up from the journal
+	for i := 0; i < third; i++ {
+		pf.p.Submit(&orders[i])
+	}
+	bm := matcher.NewMatcher(len(orders), cbuf.New(len(orders)*4))
+	b := NewBackup(bm)
+	done := make(chan error, 1)
+	go func() { done <- b.Run(pf.ln.Addr().String()) }()
+	waitFor(t, b, uint64(third))
+	for i := third; i < 2*third; i++ {
+		pf.p.Submit(&orders[i])
+	}
+	waitFor(t, b, uint64(2*third))
+	// The backup loses its connection, it misses the remaining orders
+	pf.stop()
+	<-done
+	for i := 2 * third; i < len(orders); i++ {
+		pf.m.Submit(&orders[i])
+	}
+	if b.Verified().Seq == 0 {
+		t.Errorf("Expecting a verified checkpoint")
+	}
+	jf, err := os.Open(pf.jpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jf.Close()
+	final := &Checkpoint{Seq: pf.m.LastSeq(), Digest: pf.m.Digest()}
+	seq, err := b.Promote(jf, final)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seq != uint64(len(orders)) {
+		t.Errorf("Expecting promotion at %d, got %d instead", len(orders), seq)
+	}
+}
+
+func TestDiverged(t *testing.T) {
+	orders, _ := treplicationOrderMaker.RndTradeSet(20, 5, 1, 100)
+	pf := newPrimaryFixture(t, len(orders))
+	defer pf.stop()
+	// The backup's matcher has an order the primary has never seen
+	bm := matcher.NewMatcher(len(orders), cbuf.New(len(orders)*4))
+	bm.Submit(treplicationOrderMaker.MkPricedBuyData(1))
+	b := NewBackup(bm)
+	done := make(chan error, 1)
+	go func() { done <- b.Run(pf.ln.Addr().String()) }()
+	deadline := time.Now().Add(5 * time.Second)
+	for pf.p.Backups() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Backup never connected")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	for i := range orders {
+		pf.p.Submit(&orders[i])
+	}
+	if _, ok := (<-done).(*DivergedError); !ok {
+		t.Errorf("Expecting a *DivergedError")
+	}
+	if _, err := b.Promote(nil, nil); err == nil {
+		t.Errorf("Expecting promotion of a diverged backup to fail")
+	}
+}
+
+// An order whose journal record couldn't be synced has been applied, so backups are sent it
+func TestSyncError(t *testing.T) {
+	orders, _ := treplicationOrderMaker.RndTradeSet(5, 2, 1, 100)
+	pf := newPrimaryFixture(t, len(orders))
+	defer pf.stop()
+	b := NewBackup(matcher.NewMatcher(len(orders), cbuf.New(len(orders)*4)))
+	done := make(chan error, 1)
+	go func() { done <- b.Run(pf.ln.Addr().String()) }()
+	deadline := time.Now().Add(5 * time.Second)
+	for pf.p.Backups() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Backup never connected")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	pf.w.fail = true
+	if err := pf.p.Submit(&orders[0]); err == nil {
+		t.Errorf("Expecting a sync error")
+	}
+	pf.w.fail = false
+	for i := 1; i < len(orders); i++ {
+		if err := pf.p.Submit(&orders[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor(t, b, uint64(len(orders)))
+	select {
+	case err := <-done:
+		t.Errorf("Expecting the backup to keep running, got %v instead", err)
+	default:
+	}
+}
+
+// A backup whose matcher discards its responses applies a long stream and agrees with its primary
+func TestDiscardResponses(t *testing.T) {
+	orders, _ := treplicationOrderMaker.RndTradeSet(500, 20, 1, 100)
+	pf := newPrimaryFixture(t, len(orders))
+	defer pf.stop()
+	bm := matcher.NewMatcher(len(orders), &cbuf.Discard{})
+	b := NewBackup(bm)
+	go b.Run(pf.ln.Addr().String())
+	for i := range orders {
+		pf.p.Submit(&orders[i])
+	}
+	waitFor(t, b, uint64(len(orders)))
+	if bm.Digest() != pf.m.Digest() {
+		t.Errorf("Expecting %v, got %v instead", pf.m.Digest(), bm.Digest())
+	}
+}
+
+// A backup which is sent an order out of sequence stops with a sequence error
+func TestSequenceGap(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	orders, _ := treplicationOrderMaker.RndTradeSet(2, 1, 1, 100)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if _, err := readHello(conn); err != nil {
+			return
+		}
+		var buf [1 + journal.RecordLen]byte
+		conn.Write(encodeOrder(buf[:], 1, &orders[0]))
+		conn.Write(encodeOrder(buf[:], 3, &orders[1]))
+		io.Copy(io.Discard, conn)
+	}()
+	b := NewBackup(matcher.NewMatcher(len(orders), cbuf.New(len(orders)*4)))
+	err = b.Run(ln.Addr().String())
+	if se, ok := err.(*journal.SequenceError); !ok || se.Expected != 2 || se.Found != 3 {
+		t.Errorf("Expecting a sequence error expecting 2 and finding 3, got %v instead", err)
+	}
+	if b.Seq() != 1 {
+		t.Errorf("Expecting sequence %d, got %d instead", 1, b.Seq())
+	}
+}
+
+func waitFor(t *testing.T, b *Backup, seq uint64) {
+	deadline := time.Now().Add(5 * time.Second)
+	for b.Seq() < seq {
+		if time.Now().After(deadline) {
+			t.Fatalf("Backup reached %d, expecting %d", b.Seq(), seq)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,63 @@
+package sequencer
+
+import (
+	"github.com/fmstephe/matching_engine/matcher"
+	"github.com/fmstephe/matching_engine/trade"
+	"sync"
+	"time"
+)
+
+// Stands in front of a matcher, stamping every order with a gapless global sequence number and the time it
+// was received before submitting it. The matcher carries both stamps into every response the order causes
+// and into its journal record. Safe for use by many gateways at once, orders are sequenced in the order they
+// acquire the sequencer.
+type Sequencer struct {
+	mu    sync.Mutex
+	m     *matcher.M
+	clock func() int64
+	seq   uint64
+	last  int64
+}
+
+// Sequence numbers follow the last order submitted to m, so a matcher restored from a snapshot or journal
+// continues its sequence. clock returns the current time in nanoseconds, if nil the wall clock is used.
+func New(m *matcher.M, clock func() int64) *Sequencer {
+	if clock == nil {
+		clock = func() int64 { return time.Now().UnixNano() }
+	}
+	return &Sequencer{m: m, clock: clock, seq: m.LastSeq()}
+}
+
+// Stamps od and submits it to the matcher, returning its sequence number. Receive times never go
+// backwards, even if the clock does. An order rejected by the matcher doesn't use up a sequence number. A
+// *matcher.SyncError is returned with the order's sequence number, as the order has been applied, and should
+// be treated as fatal since the journal may not hold it.
+func (s *Sequencer) Submit(od *trade.OrderData) (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.clock()
+	if now < s.last {
+		now = s.last
+	}
+	s.last = now
+	s.seq++
+	od.Seq = s.seq
+	od.RecvTime = now
+	err := s.m.Submit(od)
+	if _, ok := err.(*matcher.SyncError); ok {
+		return s.seq, err
+	}
+	if err != nil {
+		s.seq--
+		od.Seq = 0
+		return 0, err
+	}
+	return s.seq, nil
+}
+
+// The sequence number of the last order submitted
+func (s *Sequencer) Seq() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.seq
+}
@@ -0,0 +1,168 @@
+package sequencer
+
+import (
+	"bytes"
+	"errors"
+	"github.com/fmstephe/matching_engine/cbuf"
+	"github.com/fmstephe/matching_engine/journal"
+	"github.com/fmstephe/matching_engine/matcher"
+	"github.com/fmstephe/matching_engine/trade"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+var tsequencerOrderMaker = trade.NewOrderMaker()
+
+func TestStamps(t *testing.T) {
+	orders, err := tsequencerOrderMaker.RndTradeSet(100, 10, 1, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rb := cbuf.New(len(orders) * 4)
+	m := matcher.NewMatcher(len(orders), rb)
+	var buf bytes.Buffer
+	jw, _ := journal.NewWriter(&buf, 0, 0)
+	m.SetJournal(jw)
+	now := int64(1000)
+	s := New(m, func() int64 { now -= 3; return now })
+	for i := range orders {
+		seq, err := s.Submit(&orders[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seq != uint64(i+1) {
+			t.Errorf("Expecting sequence %d, got %d instead", i+1, seq)
+		}
+		// The clock runs backwards, receive times stand still
+		if orders[i].RecvTime != 997 {
+			t.Errorf("Expecting receive time %d, got %d instead", 997, orders[i].RecvTime)
+		}
+		for {
+			r, err := rb.GetForRead()
+			if err != nil {
+				break
+			}
+			if r.Seq != seq || r.RecvTime != orders[i].RecvTime {
+				t.Errorf("Expecting response stamped %d/%d, got %d/%d instead", seq, orders[i].RecvTime, r.Seq, r.RecvTime)
+			}
+		}
+	}
+	jr, _ := journal.NewReader(&buf)
+	od := &trade.OrderData{}
+	for i := range orders {
+		if _, err := jr.Next(od); err != nil {
+			t.Fatal(err)
+		}
+		if *od != orders[i] {
+			t.Errorf("Expecting %v, got %v instead", orders[i], *od)
+		}
+	}
+}
+
+func TestConcurrentGateways(t *testing.T) {
+	const gateways = 4
+	orders, err := tsequencerOrderMaker.RndTradeSet(100, 10, 1, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rb := cbuf.New(len(orders) * gateways * 4)
+	m := matcher.NewMatcher(len(orders)*gateways, rb)
+	var buf bytes.Buffer
+	jw, _ := journal.NewWriter(&buf, 0, 0)
+	m.SetJournal(jw)
+	var clock int64
+	s := New(m, func() int64 { return atomic.AddInt64(&clock, 1) })
+	var wg sync.WaitGroup
+	for g := 0; g < gateways; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range orders {
+				od := orders[i]
+				s.Submit(&od)
+			}
+		}()
+	}
+	wg.Wait()
+	if s.Seq() != uint64(len(orders)*gateways) {
+		t.Errorf("Expecting sequence %d, got %d instead", len(orders)*gateways, s.Seq())
+	}
+	// The journal is gapless and in receive order, replaying it rebuilds an identical matcher
+	b := buf.Bytes()
+	jr, _ := journal.NewReader(bytes.NewReader(b))
+	od := &trade.OrderData{}
+	var last int64
+	for i := 0; i < len(orders)*gateways; i++ {
+		seq, err := jr.Next(od)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seq != uint64(i+1) || od.RecvTime <= last {
+			t.Errorf("Expecting sequence %d after time %d, got %d at %d instead", i+1, last, seq, od.RecvTime)
+		}
+		last = od.RecvTime
+	}
+	replayed := matcher.NewMatcher(len(orders)*gateways, cbuf.New(len(orders)*gateways*4))
+	jr, _ = journal.NewReader(bytes.NewReader(b))
+	if _, err := replayed.Replay(jr); err != nil {
+		t.Fatal(err)
+	}
+	if replayed.Digest() != m.Digest() {
+		t.Errorf("Expecting digest %v, got %v instead", m.Digest(), replayed.Digest())
+	}
+}
+
+// A rejected order is left unsequenced, and its sequence number is given to the next order
+func TestReject(t *testing.T) {
+	m := matcher.NewMatcher(10, cbuf.New(4))
+	m.SetOverflowPolicy(matcher.OVERFLOW_REJECT)
+	s := New(m, nil)
+	od := &trade.OrderData{}
+	for i := uint32(1); i <= 3; i++ {
+		od.WriteSell(trade.CostData{Price: 10, Amount: 1}, trade.TradeData{TraderId: i, TradeId: i, StockId: 1})
+		if _, err := s.Submit(od); err != nil {
+			t.Fatal(err)
+		}
+	}
+	od.WriteBuy(trade.CostData{Price: 10, Amount: 3}, trade.TradeData{TraderId: 9, TradeId: 9, StockId: 1})
+	if _, err := s.Submit(od); err != matcher.ErrOverflow || od.Seq != 0 {
+		t.Errorf("Expecting %v and sequence %d, got %v and %d instead", matcher.ErrOverflow, 0, err, od.Seq)
+	}
+	od.WriteBuy(trade.CostData{Price: 10, Amount: 1}, trade.TradeData{TraderId: 9, TradeId: 10, StockId: 1})
+	if seq, err := s.Submit(od); err != nil || seq != 4 {
+		t.Errorf("Expecting sequence %d, got %d and %v instead", 4, seq, err)
+	}
+}
+
+type failSyncer struct {
+	bytes.Buffer
+	fail bool
+}
+
+func (w *failSyncer) Sync() error {
+	if w.fail {
+		return errors.New("Sync failed")
+	}
+	return nil
+}
+
+// An order whose journal record can't be synced has been applied, so it keeps its sequence number
+func TestSyncError(t *testing.T) {
+	w := &failSyncer{fail: true}
+	jw, _ := journal.NewWriter(w, 0, 1)
+	m := matcher.NewMatcher(10, cbuf.New(4))
+	m.SetJournal(jw)
+	s := New(m, nil)
+	od := &trade.OrderData{}
+	od.WriteBuy(trade.CostData{Price: 10, Amount: 1}, trade.TradeData{TraderId: 1, TradeId: 1, StockId: 1})
+	seq, err := s.Submit(od)
+	if _, ok := err.(*matcher.SyncError); !ok || seq != 1 || od.Seq != 1 || m.Size() != 1 {
+		t.Errorf("Expecting a sync error for order 1 resting, got %v for order %d with %d resting instead", err, seq, m.Size())
+	}
+	w.fail = false
+	od.WriteBuy(trade.CostData{Price: 10, Amount: 1}, trade.TradeData{TraderId: 1, TradeId: 2, StockId: 1})
+	if seq, err := s.Submit(od); err != nil || seq != 2 {
+		t.Errorf("Expecting sequence %d, got %d and %v instead", 2, seq, err)
+	}
+}
@@ -0,0 +1,189 @@
+package soupbin
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Packet types sent by clients
+const (
+	LOGIN_REQUEST    = 'L'
+	UNSEQUENCED_DATA = 'U'
+	CLIENT_HEARTBEAT = 'R'
+	LOGOUT_REQUEST   = 'O'
+)
+
+// Packet types sent by servers
+const (
+	LOGIN_ACCEPTED   = 'A'
+	LOGIN_REJECTED   = 'J'
+	SEQUENCED_DATA   = 'S'
+	SERVER_HEARTBEAT = 'H'
+	END_OF_SESSION   = 'Z'
+	DEBUG            = '+'
+)
+
+// Login rejection reasons
+const (
+	NOT_AUTHORIZED        = 'A'
+	SESSION_NOT_AVAILABLE = 'S'
+)
+
+const (
+	usernameLen = 6
+	passwordLen = 10
+	sessionLen  = 10
+	sequenceLen = 20
+	LoginLen    = usernameLen + passwordLen + sessionLen + sequenceLen
+	AcceptedLen = sessionLen + sequenceLen
+)
+
+var ErrMalformed = errors.New("Malformed SoupBinTCP packet")
+
+// Reads packets from a stream
+type Reader struct {
+	r   *bufio.Reader
+	buf []byte
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReader(r)}
+}
+
+// Reads the next packet, returning its type and payload. The payload is only valid until the next Read.
+func (sr *Reader) Read() (byte, []byte, error) {
+	var h [2]byte
+	if _, err := io.ReadFull(sr.r, h[:]); err != nil {
+		return 0, nil, err
+	}
+	n := int(binary.BigEndian.Uint16(h[:]))
+	if n == 0 {
+		return 0, nil, ErrMalformed
+	}
+	if cap(sr.buf) < n {
+		sr.buf = make([]byte, n)
+	}
+	sr.buf = sr.buf[:n]
+	if _, err := io.ReadFull(sr.r, sr.buf); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, nil, err
+	}
+	return sr.buf[0], sr.buf[1:], nil
+}
+
+// Writes packets to a stream, nothing is sent until Flush
+type Writer struct {
+	w *bufio.Writer
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: bufio.NewWriter(w)}
+}
+
+func (sw *Writer) Write(kind byte, payload []byte) error {
+	if len(payload)+1 > 0xFFFF {
+		return ErrMalformed
+	}
+	var h [3]byte
+	binary.BigEndian.PutUint16(h[:], uint16(len(payload)+1))
+	h[2] = kind
+	if _, err := sw.w.Write(h[:]); err != nil {
+		return err
+	}
+	_, err := sw.w.Write(payload)
+	return err
+}
+
+func (sw *Writer) Flush() error {
+	return sw.w.Flush()
+}
+
+// The payload of a login request. A blank Session asks for the current session, a Sequence of 0 asks for
+// only the messages sent from now on.
+type Login struct {
+	Username string
+	Password string
+	Session  string
+	Sequence uint64
+}
+
+func (l *Login) Encode(b []byte) []byte {
+	b = padRight(b, l.Username, usernameLen)
+	b = padRight(b, l.Password, passwordLen)
+	b = padLeft(b, l.Session, sessionLen)
+	return padLeft(b, strconv.FormatUint(l.Sequence, 10), sequenceLen)
+}
+
+func (l *Login) Decode(p []byte) error {
+	if len(p) != LoginLen {
+		return ErrMalformed
+	}
+	l.Username = strings.TrimRight(string(p[:usernameLen]), " ")
+	p = p[usernameLen:]
+	l.Password = strings.TrimRight(string(p[:passwordLen]), " ")
+	p = p[passwordLen:]
+	l.Session = strings.TrimLeft(string(p[:sessionLen]), " ")
+	seq, err := parseSequence(p[sessionLen:])
+	l.Sequence = seq
+	return err
+}
+
+// The payload of a login acceptance, Sequence is the sequence number of the next message the server sends
+type LoginAccepted struct {
+	Session  string
+	Sequence uint64
+}
+
+func (a *LoginAccepted) Encode(b []byte) []byte {
+	b = padLeft(b, a.Session, sessionLen)
+	return padLeft(b, strconv.FormatUint(a.Sequence, 10), sequenceLen)
+}
+
+func (a *LoginAccepted) Decode(p []byte) error {
+	if len(p) != AcceptedLen {
+		return ErrMalformed
+	}
+	a.Session = strings.TrimLeft(string(p[:sessionLen]), " ")
+	seq, err := parseSequence(p[sessionLen:])
+	a.Sequence = seq
+	return err
+}
+
+func parseSequence(p []byte) (uint64, error) {
+	s := strings.TrimLeft(string(p), " ")
+	if s == "" {
+		return 0, nil
+	}
+	seq, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, ErrMalformed
+	}
+	return seq, nil
+}
+
+func padRight(b []byte, s string, width int) []byte {
+	if len(s) > width {
+		s = s[:width]
+	}
+	b = append(b, s...)
+	for i := len(s); i < width; i++ {
+		b = append(b, ' ')
+	}
+	return b
+}
+
+func padLeft(b []byte, s string, width int) []byte {
+	if len(s) > width {
+		s = s[:width]
+	}
+	for i := len(s); i < width; i++ {
+		b = append(b, ' ')
+	}
+	return append(b, s...)
+}
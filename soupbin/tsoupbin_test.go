@@ -0,0 +1,60 @@
+package soupbin
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestPackets(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.Write(SEQUENCED_DATA, []byte("abc"))
+	w.Write(SERVER_HEARTBEAT, nil)
+	w.Flush()
+	expected := []byte{0, 4, 'S', 'a', 'b', 'c', 0, 1, 'H'}
+	if !bytes.Equal(buf.Bytes(), expected) {
+		t.Errorf("Expecting %v, got %v instead", expected, buf.Bytes())
+	}
+	r := NewReader(&buf)
+	kind, payload, err := r.Read()
+	if err != nil || kind != SEQUENCED_DATA || string(payload) != "abc" {
+		t.Errorf("Expecting %c %s, got %c %s (%v) instead", SEQUENCED_DATA, "abc", kind, payload, err)
+	}
+	kind, payload, err = r.Read()
+	if err != nil || kind != SERVER_HEARTBEAT || len(payload) != 0 {
+		t.Errorf("Expecting %c, got %c %s (%v) instead", SERVER_HEARTBEAT, kind, payload, err)
+	}
+	if _, _, err := r.Read(); err != io.EOF {
+		t.Errorf("Expecting %v, got %v instead", io.EOF, err)
+	}
+	r = NewReader(bytes.NewReader([]byte{0, 4, 'S', 'a'}))
+	if _, _, err := r.Read(); err != io.ErrUnexpectedEOF {
+		t.Errorf("Expecting %v, got %v instead", io.ErrUnexpectedEOF, err)
+	}
+}
+
+func TestLogin(t *testing.T) {
+	l := Login{Username: "ABC", Password: "secret", Session: "", Sequence: 17}
+	p := l.Encode(nil)
+	expected := "ABC   " + "secret    " + strings.Repeat(" ", 10) + strings.Repeat(" ", 18) + "17"
+	if string(p) != expected {
+		t.Errorf("Expecting %q, got %q instead", expected, p)
+	}
+	var decoded Login
+	if err := decoded.Decode(p); err != nil {
+		t.Fatal(err)
+	}
+	if decoded != l {
+		t.Errorf("Expecting %v, got %v instead", l, decoded)
+	}
+	a := LoginAccepted{Session: "20261018", Sequence: 5}
+	var da LoginAccepted
+	if err := da.Decode(a.Encode(nil)); err != nil || da != a {
+		t.Errorf("Expecting %v, got %v (%v) instead", a, da, err)
+	}
+	if err := decoded.Decode(p[1:]); err != ErrMalformed {
+		t.Errorf("Expecting %v, got %v instead", ErrMalformed, err)
+	}
+}
@@ -0,0 +1,155 @@
+package stats
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+)
+
+// Session statistics for a single stock
+type Stats struct {
+	StockId  uint32
+	Trades   uint64
+	Volume   uint64
+	Turnover int64 // The sum of price * amount for every trade
+	Last     int64
+	High     int64
+	Low      int64
+}
+
+// The volume weighted average price, zero if no trades have been recorded
+func (s *Stats) VWAP() int64 {
+	if s.Volume == 0 {
+		return 0
+	}
+	return s.Turnover / int64(s.Volume)
+}
+
+// Open/high/low/close/volume for the interval beginning at Start
+type Bar struct {
+	Start  int64
+	Open   int64
+	High   int64
+	Low    int64
+	Close  int64
+	Volume uint64
+}
+
+type stock struct {
+	Stats Stats
+	VWAP  int64
+	Bars  []Bar
+}
+
+// Records every trade, indexed by stock id. Bars are cut at multiples of interval on the engine clock,
+// intervals without any trades produce no bar. An interval of 0 turns bars off. A trade recorded at a time
+// before the start of the last bar is merged into the last bar.
+type Tape struct {
+	interval int64
+	stocks   map[uint32]*stock
+}
+
+func NewTape(interval int64) *Tape {
+	return &Tape{interval: interval, stocks: make(map[uint32]*stock)}
+}
+
+func (t *Tape) Interval() int64 {
+	return t.interval
+}
+
+func (t *Tape) Record(stockId uint32, price int64, amount uint32, now int64) {
+	st := t.stocks[stockId]
+	if st == nil {
+		st = &stock{Stats: Stats{StockId: stockId, High: price, Low: price}}
+		t.stocks[stockId] = st
+	}
+	s := &st.Stats
+	s.Trades++
+	s.Volume += uint64(amount)
+	s.Turnover += price * int64(amount)
+	s.Last = price
+	if price > s.High {
+		s.High = price
+	}
+	if price < s.Low {
+		s.Low = price
+	}
+	if t.interval <= 0 {
+		return
+	}
+	start := now - now%t.interval
+	if now%t.interval < 0 {
+		start -= t.interval
+	}
+	if n := len(st.Bars); n > 0 && start < st.Bars[n-1].Start {
+		start = st.Bars[n-1].Start
+	}
+	if len(st.Bars) == 0 || st.Bars[len(st.Bars)-1].Start != start {
+		st.Bars = append(st.Bars, Bar{Start: start, Open: price, High: price, Low: price})
+	}
+	b := &st.Bars[len(st.Bars)-1]
+	b.Close = price
+	b.Volume += uint64(amount)
+	if price > b.High {
+		b.High = price
+	}
+	if price < b.Low {
+		b.Low = price
+	}
+}
+
+func (t *Tape) Stats(stockId uint32) (Stats, bool) {
+	st := t.stocks[stockId]
+	if st == nil {
+		return Stats{}, false
+	}
+	return st.Stats, true
+}
+
+// The bars recorded for stockId, oldest first. The returned slice must not be modified.
+func (t *Tape) Bars(stockId uint32) []Bar {
+	st := t.stocks[stockId]
+	if st == nil {
+		return nil
+	}
+	return st.Bars
+}
+
+func (t *Tape) StockIds() []uint32 {
+	ids := make([]uint32, 0, len(t.stocks))
+	for id := range t.stocks {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+type report struct {
+	Interval int64
+	Stocks   []*stock
+}
+
+// Writes a JSON end of day report which can be read back with ReadReport
+func (t *Tape) WriteReport(w io.Writer) error {
+	r := report{Interval: t.interval}
+	for _, id := range t.StockIds() {
+		st := *t.stocks[id]
+		st.VWAP = st.Stats.VWAP()
+		r.Stocks = append(r.Stocks, &st)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	return enc.Encode(&r)
+}
+
+func ReadReport(r io.Reader) (*Tape, error) {
+	var rep report
+	if err := json.NewDecoder(r).Decode(&rep); err != nil {
+		return nil, err
+	}
+	t := NewTape(rep.Interval)
+	for _, st := range rep.Stocks {
+		t.stocks[st.Stats.StockId] = st
+	}
+	return t, nil
+}
@@ -0,0 +1,96 @@
+package stats
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestStats(t *testing.T) {
+	tape := NewTape(10)
+	tape.Record(1, 100, 2, 0)
+	tape.Record(1, 110, 1, 5)
+	tape.Record(1, 90, 1, 9)
+	tape.Record(2, 50, 4, 9)
+	tape.Record(1, 105, 4, 31)
+	s, ok := tape.Stats(1)
+	if !ok {
+		t.Fatalf("No stats found for stock 1")
+	}
+	expected := Stats{StockId: 1, Trades: 4, Volume: 8, Turnover: 200 + 110 + 90 + 420, Last: 105, High: 110, Low: 90}
+	if s != expected {
+		t.Errorf("Expecting %v, got %v instead", expected, s)
+	}
+	if s.VWAP() != 102 {
+		t.Errorf("Expecting VWAP 102, got %d instead", s.VWAP())
+	}
+	if _, ok := tape.Stats(3); ok {
+		t.Errorf("Unexpected stats found for stock 3")
+	}
+}
+
+func TestBars(t *testing.T) {
+	tape := NewTape(10)
+	tape.Record(1, 100, 2, 0)
+	tape.Record(1, 110, 1, 5)
+	tape.Record(1, 90, 1, 9)
+	tape.Record(1, 105, 4, 31)
+	expected := []Bar{
+		{Start: 0, Open: 100, High: 110, Low: 90, Close: 90, Volume: 4},
+		{Start: 30, Open: 105, High: 105, Low: 105, Close: 105, Volume: 4},
+	}
+	if !reflect.DeepEqual(tape.Bars(1), expected) {
+		t.Errorf("Expecting %v, got %v instead", expected, tape.Bars(1))
+	}
+	if NewTape(0).Bars(1) != nil {
+		t.Errorf("Unexpected bars found")
+	}
+}
+
+// Negative times are floored to their interval, a time before the last bar is merged into it
+func TestBarsOutOfOrder(t *testing.T) {
+	tape := NewTape(10)
+	tape.Record(1, 100, 1, -15)
+	tape.Record(1, 101, 1, -1)
+	tape.Record(1, 102, 1, 3)
+	tape.Record(1, 103, 1, -12)
+	expected := []Bar{
+		{Start: -20, Open: 100, High: 100, Low: 100, Close: 100, Volume: 1},
+		{Start: -10, Open: 101, High: 101, Low: 101, Close: 101, Volume: 1},
+		{Start: 0, Open: 102, High: 103, Low: 102, Close: 103, Volume: 2},
+	}
+	if !reflect.DeepEqual(tape.Bars(1), expected) {
+		t.Errorf("Expecting %v, got %v instead", expected, tape.Bars(1))
+	}
+}
+
+func TestReport(t *testing.T) {
+	tape := NewTape(10)
+	tape.Record(1, 100, 2, 0)
+	tape.Record(2, 50, 4, 9)
+	tape.Record(1, 105, 4, 31)
+	var buf bytes.Buffer
+	if err := tape.WriteReport(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if tape.stocks[1].VWAP != 0 {
+		t.Errorf("Expecting the tape to be unchanged, got VWAP %d instead", tape.stocks[1].VWAP)
+	}
+	read, err := ReadReport(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read.Interval() != tape.Interval() {
+		t.Errorf("Expecting interval %d, got %d instead", tape.Interval(), read.Interval())
+	}
+	for _, id := range tape.StockIds() {
+		s, _ := tape.Stats(id)
+		rs, _ := read.Stats(id)
+		if s != rs {
+			t.Errorf("Expecting %v, got %v instead", s, rs)
+		}
+		if !reflect.DeepEqual(tape.Bars(id), read.Bars(id)) {
+			t.Errorf("Expecting %v, got %v instead", tape.Bars(id), read.Bars(id))
+		}
+	}
+}
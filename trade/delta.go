@@ -0,0 +1,61 @@
+package trade
+
+type DeltaKind int32
+
+const (
+	ADD     = DeltaKind(1)
+	EXECUTE = DeltaKind(2)
+	DELETE  = DeltaKind(3)
+	LEVEL   = DeltaKind(4)
+)
+
+func (k DeltaKind) String() string {
+	switch k {
+	case ADD:
+		return "ADD"
+	case EXECUTE:
+		return "EXECUTE"
+	case DELETE:
+		return "DELETE"
+	case LEVEL:
+		return "LEVEL"
+	}
+	panic("Unreachable")
+}
+
+// Describes a single change to a resting book
+type Delta struct {
+	Kind    DeltaKind
+	Side    OrderKind // BUY or SELL
+	StockId uint32
+	Seq     uint64 // Per stock sequence number, the first delta for a stock is 1
+	Guid    int64  // Unused for LEVEL
+	Price   int64
+	Amount  uint32 // Added, executed or deleted amount, unused for LEVEL
+	Count   uint32 // The number of orders resting at Price, only for LEVEL
+	Level   uint64 // The total amount resting at Price, only for LEVEL
+}
+
+func (d *Delta) WriteOrder(kind DeltaKind, seq uint64, o *Order, amount uint32) {
+	d.Kind = kind
+	d.Side = o.Kind()
+	d.StockId = o.StockId()
+	d.Seq = seq
+	d.Guid = o.Guid()
+	d.Price = o.Price()
+	d.Amount = amount
+	d.Count = 0
+	d.Level = 0
+}
+
+func (d *Delta) WriteLevel(seq uint64, side OrderKind, stockId uint32, price int64, count uint32, level uint64) {
+	d.Kind = LEVEL
+	d.Side = side
+	d.StockId = stockId
+	d.Seq = seq
+	d.Guid = 0
+	d.Price = price
+	d.Amount = 0
+	d.Count = count
+	d.Level = level
+}
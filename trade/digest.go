@@ -0,0 +1,49 @@
+package trade
+
+// A cheap hash of engine state which two engines fed the same input can compare. Book is the sum of a hash
+// of every resting order together with the order queued ahead of it, so two books only agree if each order
+// has the same place in its queue, and is kept up to date as orders are added and removed. Stream is a
+// rolling hash of every response written, in order.
+type Digest struct {
+	Book   uint64
+	Stream uint64
+}
+
+// Adds o, queued behind ahead, to Book. ahead is nil if o is at the head of its queue.
+func (d *Digest) AddOrder(o, ahead *Order) {
+	d.Book += queuedHash(o, ahead)
+}
+
+// Removes o, queued behind ahead, from Book
+func (d *Digest) RemoveOrder(o, ahead *Order) {
+	d.Book -= queuedHash(o, ahead)
+}
+
+func (d *Digest) AddResponse(r *Response) {
+	h := mix(d.Stream ^ uint64(r.Kind))
+	h = mix(h ^ uint64(r.Price))
+	h = mix(h ^ (uint64(r.Amount)<<32 | uint64(r.TraderId)))
+	h = mix(h ^ (uint64(r.TradeId)<<32 | uint64(r.CounterParty)))
+	d.Stream = h
+}
+
+func queuedHash(o, ahead *Order) uint64 {
+	h := mix(uint64(o.Guid()))
+	h = mix(h ^ uint64(o.Price()))
+	h = mix(h ^ (uint64(o.amount)<<32 | uint64(o.stockId)))
+	h = mix(h ^ uint64(o.kind))
+	if ahead != nil {
+		h = mix(h ^ uint64(ahead.Guid()))
+	}
+	return h
+}
+
+// The splitmix64 finaliser
+func mix(h uint64) uint64 {
+	h ^= h >> 30
+	h *= 0xbf58476d1ce4e5b9
+	h ^= h >> 27
+	h *= 0x94d049bb133111eb
+	h ^= h >> 31
+	return h
+}
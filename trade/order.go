@@ -72,11 +72,13 @@ type TradeData struct {
 
 // Flat description of an incoming order
 type OrderData struct {
-	Price   int64
-	Guid    int64
-	Amount  uint32
-	StockId uint32
-	Kind    OrderKind
+	Seq      uint64 // Global input sequence number, 0 if the order was not sequenced
+	RecvTime int64  // Time the order was received by its gateway, set along with Seq
+	Price    int64
+	Guid     int64
+	Amount   uint32
+	StockId  uint32
+	Kind     OrderKind
 }
 
 func (od *OrderData) WriteBuy(costData CostData, tradeData TradeData) {
@@ -92,6 +94,8 @@ func (od *OrderData) WriteCancel(o *Order) {
 }
 
 func (od *OrderData) Write(costData CostData, tradeData TradeData, kind OrderKind) {
+	od.Seq = 0
+	od.RecvTime = 0
 	od.Price = costData.Price
 	od.Guid = mkGuid(tradeData.TraderId, tradeData.TradeId)
 	od.Amount = costData.Amount
@@ -195,6 +199,8 @@ func (o *Order) String() string {
 }
 
 type Response struct {
+	Seq          uint64 // Sequence number of the order which caused this response
+	RecvTime     int64  // Receive time of the order which caused this response
 	Kind         ResponseKind
 	Price        int64  // The actual trade price, will be negative if a purchase was made
 	Amount       uint32 // The number of units actually bought or sold
@@ -212,8 +218,19 @@ func (r *Response) WriteTrade(kind ResponseKind, price int64, amount, traderId,
 	r.CounterParty = counterParty
 }
 
+// Price, Amount and CounterParty are zeroed, a reused buffer slot would otherwise carry them over from an
+// earlier response into the cancel and the digest
 func (r *Response) WriteCancel(kind ResponseKind, traderId, tradeId uint32) {
 	r.Kind = kind
+	r.Price = 0
+	r.Amount = 0
 	r.TraderId = traderId
 	r.TradeId = tradeId
+	r.CounterParty = 0
+}
+
+// Stamps r with the sequence number and receive time of the order which caused it
+func (r *Response) WriteStamp(in *OrderData) {
+	r.Seq = in.Seq
+	r.RecvTime = in.RecvTime
 }
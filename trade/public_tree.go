@@ -2,27 +2,42 @@ package trade
 
 import ()
 
+// The aggregate of all orders resting at a single price
+type Level struct {
+	Price int64
+	Count uint32
+	Size  uint64
+}
+
 type MatchTrees struct {
 	buyTree  tree
 	sellTree tree
 	orders   tree
 	size     int
+	digest   Digest
 }
 
 func (m *MatchTrees) Size() int {
 	return m.size
 }
 
+// A hash of every resting order and its place in its queue, only Digest.Book is set
+func (m *MatchTrees) Digest() Digest {
+	return m.digest
+}
+
 func (m *MatchTrees) PushBuy(b *Order) {
 	m.size++
 	m.buyTree.push(&b.priceNode)
 	m.orders.push(&b.guidNode)
+	m.digest.AddOrder(b, b.priceNode.ahead().getOrder())
 }
 
 func (m *MatchTrees) PushSell(s *Order) {
 	m.size++
 	m.sellTree.push(&s.priceNode)
 	m.orders.push(&s.guidNode)
+	m.digest.AddOrder(s, s.priceNode.ahead().getOrder())
 }
 
 func (m *MatchTrees) PeekBuy() *Order {
@@ -33,20 +48,104 @@ func (m *MatchTrees) PeekSell() *Order {
 	return m.sellTree.peekMin().getOrder()
 }
 
+func (m *MatchTrees) BuyLevel(price int64) (count uint32, size uint64) {
+	return m.buyTree.level(price)
+}
+
+func (m *MatchTrees) SellLevel(price int64) (count uint32, size uint64) {
+	return m.sellTree.level(price)
+}
+
+// Appends up to n buy levels to levels, best price first
+func (m *MatchTrees) BuyLevels(levels []Level, n int) []Level {
+	return m.buyTree.root.levels(levels, n, true)
+}
+
+// Appends up to n sell levels to levels, best price first
+func (m *MatchTrees) SellLevels(levels []Level, n int) []Level {
+	return m.sellTree.root.levels(levels, n, false)
+}
+
+// The number of resting orders o would trade with if it were matched now
+func (m *MatchTrees) Trades(o *Order) int {
+	var trades int
+	switch o.kind {
+	case BUY:
+		trades, _ = m.sellTree.root.trades(o.Price(), uint64(o.amount), false, 0)
+	case SELL:
+		trades, _ = m.buyTree.root.trades(o.Price(), uint64(o.amount), true, 0)
+	}
+	return trades
+}
+
 func (m *MatchTrees) PopBuy() *Order {
-	m.size--
-	return m.buyTree.popMax().getOrder()
+	if n := m.buyTree.peekMax(); n != nil {
+		m.unlink(n)
+	}
+	b := m.buyTree.popMax().getOrder()
+	if b != nil {
+		m.size--
+	}
+	return b
 }
 
 func (m *MatchTrees) PopSell() *Order {
-	m.size--
-	return m.sellTree.popMin().getOrder()
+	if n := m.sellTree.peekMin(); n != nil {
+		m.unlink(n)
+	}
+	s := m.sellTree.popMin().getOrder()
+	if s != nil {
+		m.size--
+	}
+	return s
+}
+
+// Removes the order queued at n from the digest, the order behind it moves up to n's place
+func (m *MatchTrees) unlink(n *node) {
+	ahead := n.ahead().getOrder()
+	m.digest.RemoveOrder(n.order, ahead)
+	if b := n.behind(); b != nil {
+		m.digest.RemoveOrder(b.order, n.order)
+		m.digest.AddOrder(b.order, ahead)
+	}
+}
+
+// Reduces the amount of a resting order
+func (m *MatchTrees) Reduce(o *Order, amount uint32) {
+	ahead := o.priceNode.ahead().getOrder()
+	m.digest.RemoveOrder(o, ahead)
+	m.head(o).size -= uint64(amount)
+	o.ReduceAmount(amount)
+	m.digest.AddOrder(o, ahead)
+}
+
+// The head of the queue a resting order is in
+func (m *MatchTrees) head(o *Order) *node {
+	if o.priceNode.isHead() {
+		return &o.priceNode
+	}
+	if o.kind == BUY {
+		return m.buyTree.get(o.Price())
+	}
+	return m.sellTree.get(o.Price())
+}
+
+// Returns the resting order with guid, or nil if there is none
+func (m *MatchTrees) Get(guid int64) *Order {
+	return m.orders.get(guid).getOrder()
 }
 
 func (m *MatchTrees) Cancel(o *Order) *Order {
-	po := m.orders.cancel(o.Guid()).getOrder()
-	if po != nil {
-		m.size--
+	po := m.orders.get(o.Guid()).getOrder()
+	if po == nil {
+		return nil
 	}
+	if h := m.head(po); h != &po.priceNode {
+		h.count--
+		h.size -= uint64(po.amount)
+	}
+	m.unlink(&po.priceNode)
+	m.orders.cancel(o.Guid())
+	m.size--
 	return po
 }
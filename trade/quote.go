@@ -0,0 +1,29 @@
+package trade
+
+// The best bid and offer of a book, a zero price and size means that side is empty
+type Quote struct {
+	StockId  uint32
+	BidPrice int64
+	BidSize  uint64
+	AskPrice int64
+	AskSize  uint64
+}
+
+func (q *Quote) WriteBest(stockId uint32, m *MatchTrees) {
+	q.StockId = stockId
+	q.BidPrice, q.BidSize = 0, 0
+	q.AskPrice, q.AskSize = 0, 0
+	if b := m.PeekBuy(); b != nil {
+		q.BidPrice = b.Price()
+		_, q.BidSize = m.BuyLevel(b.Price())
+	}
+	if s := m.PeekSell(); s != nil {
+		q.AskPrice = s.Price()
+		_, q.AskSize = m.SellLevel(s.Price())
+	}
+}
+
+// Returns true if either side's best price or size differs, StockId is ignored
+func (q *Quote) Changed(other *Quote) bool {
+	return q.BidPrice != other.BidPrice || q.BidSize != other.BidSize || q.AskPrice != other.AskPrice || q.AskSize != other.AskSize
+}
@@ -0,0 +1,112 @@
+package trade
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+const (
+	snapshotMagic   = "MESN"
+	snapshotVersion = uint32(1)
+)
+
+var ErrSnapshot = errors.New("Not a book snapshot, or an unsupported snapshot version")
+
+type snapshotHeader struct {
+	Magic   [4]byte
+	Version uint32
+	Buys    uint64
+	Sells   uint64
+}
+
+type snapshotOrder struct {
+	Price   int64
+	Guid    int64
+	Amount  uint32
+	StockId uint32
+	Kind    OrderKind
+}
+
+// Writes every resting order in priority order, best price first and in time order within each price.
+// Restoring the snapshot produces a book which behaves identically, the guid index is rebuilt on restore.
+func (m *MatchTrees) WriteSnapshot(w io.Writer) error {
+	h := snapshotHeader{Version: snapshotVersion, Buys: uint64(m.buyTree.count()), Sells: uint64(m.sellTree.count())}
+	copy(h.Magic[:], snapshotMagic)
+	if err := binary.Write(w, binary.LittleEndian, &h); err != nil {
+		return err
+	}
+	if err := m.buyTree.root.writeOrders(w, true); err != nil {
+		return err
+	}
+	return m.sellTree.root.writeOrders(w, false)
+}
+
+// Restores a snapshot into m, which must be empty. Orders are allocated from slab.
+func (m *MatchTrees) ReadSnapshot(r io.Reader, slab *Slab) error {
+	if m.size != 0 {
+		return errors.New("Cannot restore a snapshot into a non-empty book")
+	}
+	var h snapshotHeader
+	if err := binary.Read(r, binary.LittleEndian, &h); err != nil {
+		return err
+	}
+	if string(h.Magic[:]) != snapshotMagic || h.Version != snapshotVersion {
+		return ErrSnapshot
+	}
+	var so snapshotOrder
+	var od OrderData
+	for i := uint64(0); i < h.Buys+h.Sells; i++ {
+		if err := binary.Read(r, binary.LittleEndian, &so); err != nil {
+			return err
+		}
+		od = OrderData{Price: so.Price, Guid: so.Guid, Amount: so.Amount, StockId: so.StockId, Kind: so.Kind}
+		o := slab.Malloc()
+		o.CopyFrom(&od)
+		if i < h.Buys {
+			m.PushBuy(o)
+		} else {
+			m.PushSell(o)
+		}
+	}
+	return nil
+}
+
+func (b *tree) count() int {
+	count := 0
+	b.root.each(false, func(n *node) {
+		count += int(n.count)
+	})
+	return count
+}
+
+// Visits every queue head, in descending order if descending is true
+func (n *node) each(descending bool, f func(*node)) {
+	if n == nil {
+		return
+	}
+	first, second := n.left, n.right
+	if descending {
+		first, second = n.right, n.left
+	}
+	first.each(descending, f)
+	f(n)
+	second.each(descending, f)
+}
+
+func (n *node) writeOrders(w io.Writer, descending bool) (err error) {
+	n.each(descending, func(h *node) {
+		// A queue's head is its oldest order, following prev visits the rest in time order
+		q := h
+		for err == nil {
+			o := q.order
+			so := snapshotOrder{Price: o.Price(), Guid: o.Guid(), Amount: o.amount, StockId: o.stockId, Kind: o.kind}
+			err = binary.Write(w, binary.LittleEndian, &so)
+			q = q.prev
+			if q == h {
+				break
+			}
+		}
+	})
+	return err
+}
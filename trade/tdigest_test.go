@@ -0,0 +1,143 @@
+package trade
+
+import (
+	"math/rand"
+	"testing"
+)
+
+var tdigestOrderMaker = NewOrderMaker()
+
+func TestDigestBook(t *testing.T) {
+	a := &MatchTrees{}
+	b := &MatchTrees{}
+	orders := tdigestOrderMaker.MkBuys(tdigestOrderMaker.ValRangeFlat(100, 1, 50))
+	for i := range orders {
+		orders[i].Amount = 10
+		a.PushBuy(NewOrderFromData(&orders[i]))
+	}
+	// Same orders, with an extra order added and cancelled
+	extra := NewOrderFromData(&OrderData{Price: 5, Guid: 1000, Amount: 3, StockId: 1, Kind: SELL})
+	b.PushSell(extra)
+	for i := range orders {
+		b.PushBuy(NewOrderFromData(&orders[i]))
+	}
+	b.Cancel(extra)
+	if a.Digest() != b.Digest() {
+		t.Errorf("Expecting equal digests, got %v and %v", a.Digest(), b.Digest())
+	}
+	a.Reduce(a.Get(orders[0].Guid), 4)
+	b.Reduce(b.Get(orders[0].Guid), 4)
+	if a.Digest() != b.Digest() {
+		t.Errorf("Expecting equal digests, got %v and %v", a.Digest(), b.Digest())
+	}
+	if (&MatchTrees{}).Digest() == a.Digest() {
+		t.Errorf("Expecting a non-empty book to change the digest")
+	}
+}
+
+// The same orders in a different queue order have different digests
+func TestDigestPriority(t *testing.T) {
+	o1 := &OrderData{Price: 5, Guid: 1, Amount: 3, StockId: 1, Kind: BUY}
+	o2 := &OrderData{Price: 5, Guid: 2, Amount: 3, StockId: 1, Kind: BUY}
+	a := &MatchTrees{}
+	a.PushBuy(NewOrderFromData(o1))
+	a.PushBuy(NewOrderFromData(o2))
+	b := &MatchTrees{}
+	b.PushBuy(NewOrderFromData(o2))
+	b.PushBuy(NewOrderFromData(o1))
+	if a.Digest() == b.Digest() {
+		t.Errorf("Expecting queue order to change the book digest")
+	}
+	// Cancelling and re-adding the first order moves it to the back of its queue
+	a.Cancel(NewOrderFromData(o1))
+	a.PushBuy(NewOrderFromData(o1))
+	if a.Digest() != b.Digest() {
+		t.Errorf("Expecting equal digests, got %v and %v", a.Digest(), b.Digest())
+	}
+}
+
+// The rolling book hash agrees with one computed by walking the whole book
+func TestDigestFold(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	m := &MatchTrees{}
+	var resting []*Order
+	for i := 0; i < 5000; i++ {
+		switch n := r.Intn(10); {
+		case n < 5 || len(resting) == 0:
+			kind := BUY
+			if r.Intn(2) == 0 {
+				kind = SELL
+			}
+			o := NewOrderFromData(&OrderData{Price: int64(r.Intn(10) + 1), Guid: int64(i + 1), Amount: uint32(r.Intn(10) + 2), StockId: 1, Kind: kind})
+			if kind == BUY {
+				m.PushBuy(o)
+			} else {
+				m.PushSell(o)
+			}
+			resting = append(resting, o)
+		case n < 7:
+			j := r.Intn(len(resting))
+			m.Cancel(resting[j])
+			resting = append(resting[:j], resting[j+1:]...)
+		case n < 8:
+			if o := resting[r.Intn(len(resting))]; o.Amount() > 1 {
+				m.Reduce(o, 1)
+			}
+		case n < 9:
+			if o := m.PopBuy(); o != nil {
+				resting = without(resting, o)
+			}
+		default:
+			if o := m.PopSell(); o != nil {
+				resting = without(resting, o)
+			}
+		}
+		d := Digest{}
+		m.buyTree.root.fold(&d)
+		m.sellTree.root.fold(&d)
+		if d != m.Digest() {
+			t.Fatalf("Expecting %v, got %v instead", d, m.Digest())
+		}
+	}
+}
+
+func without(orders []*Order, o *Order) []*Order {
+	for i := range orders {
+		if orders[i] == o {
+			return append(orders[:i], orders[i+1:]...)
+		}
+	}
+	return orders
+}
+
+// In order traversal adding every order into d, queues are walked from their oldest order
+func (n *node) fold(d *Digest) {
+	if n == nil {
+		return
+	}
+	n.left.fold(d)
+	var ahead *Order
+	q := n
+	for {
+		d.AddOrder(q.order, ahead)
+		ahead = q.order
+		q = q.prev
+		if q == n {
+			break
+		}
+	}
+	n.right.fold(d)
+}
+
+func TestDigestStream(t *testing.T) {
+	r1 := &Response{Kind: FULL, Price: 5, Amount: 1, TraderId: 1, TradeId: 2, CounterParty: 3}
+	r2 := &Response{Kind: PARTIAL, Price: -5, Amount: 1, TraderId: 3, TradeId: 4, CounterParty: 1}
+	a, b := &Digest{}, &Digest{}
+	a.AddResponse(r1)
+	a.AddResponse(r2)
+	b.AddResponse(r2)
+	b.AddResponse(r1)
+	if a.Stream == b.Stream {
+		t.Errorf("Expecting response order to change the stream digest")
+	}
+}
@@ -0,0 +1,85 @@
+package trade
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func TestLevels(t *testing.T) {
+	m := &MatchTrees{}
+	for i, price := range []int64{5, 3, 5, 9, 1, 3, 5} {
+		od := &OrderData{}
+		od.Write(CostData{Price: price, Amount: uint32(i + 1)}, TradeData{TraderId: 1, TradeId: uint32(i), StockId: 1}, BUY)
+		m.PushBuy(NewOrderFromData(od))
+		od.Kind = SELL
+		od.Guid += 100
+		m.PushSell(NewOrderFromData(od))
+	}
+	expectedBuys := []Level{{9, 1, 4}, {5, 3, 11}, {3, 2, 8}}
+	if buys := m.BuyLevels(nil, 3); !reflect.DeepEqual(buys, expectedBuys) {
+		t.Errorf("Expecting %v, got %v instead", expectedBuys, buys)
+	}
+	expectedSells := []Level{{1, 1, 5}, {3, 2, 8}, {5, 3, 11}, {9, 1, 4}}
+	if sells := m.SellLevels(nil, 10); !reflect.DeepEqual(sells, expectedSells) {
+		t.Errorf("Expecting %v, got %v instead", expectedSells, sells)
+	}
+	if count, size := m.BuyLevel(5); count != 3 || size != 11 {
+		t.Errorf("Expecting level (3, 11), got (%d, %d) instead", count, size)
+	}
+	if count, size := m.SellLevel(2); count != 0 || size != 0 {
+		t.Errorf("Expecting empty level, got (%d, %d) instead", count, size)
+	}
+}
+
+// Level totals kept on each queue's head match a walk of the queue through pushes, fills and cancels
+func TestLevelTotals(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	m := &MatchTrees{}
+	var resting []*Order
+	for i := 0; i < 2000; i++ {
+		switch {
+		case len(resting) == 0 || r.Intn(3) == 0:
+			od := &OrderData{}
+			od.Write(CostData{Price: r.Int63n(5) + 1, Amount: uint32(r.Intn(10) + 1)}, TradeData{TraderId: 1, TradeId: uint32(i), StockId: 1}, BUY)
+			o := NewOrderFromData(od)
+			m.PushBuy(o)
+			resting = append(resting, o)
+		case r.Intn(2) == 0:
+			j := r.Intn(len(resting))
+			m.Cancel(resting[j])
+			resting = append(resting[:j], resting[j+1:]...)
+		default:
+			b := m.PeekBuy()
+			if b.Amount() > 1 {
+				m.Reduce(b, 1)
+				break
+			}
+			m.PopBuy()
+			for j, o := range resting {
+				if o == b {
+					resting = append(resting[:j], resting[j+1:]...)
+					break
+				}
+			}
+		}
+		for _, l := range m.BuyLevels(nil, 10) {
+			count, size := walkQueue(m.buyTree.get(l.Price))
+			if l.Count != count || l.Size != size {
+				t.Fatalf("Expecting level (%d, %d), got (%d, %d) instead at %d", count, size, l.Count, l.Size, l.Price)
+			}
+		}
+	}
+}
+
+func walkQueue(h *node) (count uint32, size uint64) {
+	n := h
+	for {
+		count++
+		size += uint64(n.order.amount)
+		n = n.next
+		if n == h {
+			return count, size
+		}
+	}
+}
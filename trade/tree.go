@@ -16,6 +16,8 @@ func (b *tree) String() string {
 }
 
 func (b *tree) push(in *node) {
+	in.count = 1
+	in.size = uint64(in.order.amount)
 	if b.root == nil {
 		b.root = in
 		in.pp = &b.root
@@ -80,6 +82,70 @@ func (b *tree) Has(val int64) bool {
 	return b.get(val) != nil
 }
 
+// Returns the number of nodes queued at val and the total amount of their orders
+func (b *tree) level(val int64) (count uint32, size uint64) {
+	h := b.get(val)
+	if h == nil {
+		return 0, 0
+	}
+	return h.count, h.size
+}
+
+// In order traversal appending a Level for each queue, at most max levels are appended
+func (n *node) levels(levels []Level, max int, descending bool) []Level {
+	limit := len(levels) + max
+	var walk func(n *node)
+	walk = func(n *node) {
+		if n == nil || len(levels) == limit {
+			return
+		}
+		first, second := n.left, n.right
+		if descending {
+			first, second = n.right, n.left
+		}
+		walk(first)
+		if len(levels) == limit {
+			return
+		}
+		levels = append(levels, Level{Price: n.val, Count: n.count, Size: n.size})
+		walk(second)
+	}
+	walk(n)
+	return levels
+}
+
+// In order traversal counting the orders, in priority order, which an order for amount at price would trade
+// with. Queues are walked from their oldest order.
+func (n *node) trades(price int64, amount uint64, descending bool, trades int) (int, uint64) {
+	if n == nil || amount == 0 {
+		return trades, amount
+	}
+	first, second := n.left, n.right
+	if descending {
+		first, second = n.right, n.left
+	}
+	trades, amount = first.trades(price, amount, descending, trades)
+	if amount == 0 {
+		return trades, 0
+	}
+	if (descending && n.val < price) || (!descending && n.val > price) {
+		return trades, amount
+	}
+	q := n
+	for {
+		trades++
+		if uint64(q.order.amount) >= amount {
+			return trades, 0
+		}
+		amount -= uint64(q.order.amount)
+		q = q.prev
+		if q == n {
+			break
+		}
+	}
+	return second.trades(price, amount, descending, trades)
+}
+
 func (b *tree) get(val int64) *node {
 	n := b.root
 	for {
@@ -107,8 +173,10 @@ type node struct {
 	parent *node
 	pp     **node
 	// Limit queue fields
-	next *node
-	prev *node
+	next  *node
+	prev  *node
+	count uint32 // The number of nodes queued, kept on the queue's head
+	size  uint64 // The total amount of their orders, kept on the queue's head
 	// Order
 	order *Order
 	// This is the other node tying order to another tree
@@ -179,6 +247,23 @@ func (n *node) isHead() bool {
 	return n.pp != nil
 }
 
+// The node queued ahead of n, nil if n is at the head of its queue. Queues run from their head, the oldest
+// node, through prev to the newest, whose prev is the head again.
+func (n *node) ahead() *node {
+	if n.isHead() {
+		return nil
+	}
+	return n.next
+}
+
+// The node queued behind n, nil if n is the newest in its queue
+func (n *node) behind() *node {
+	if n.prev.isHead() {
+		return nil
+	}
+	return n.prev
+}
+
 func (n *node) getSibling() *node {
 	p := n.parent
 	if p == nil {
@@ -233,6 +318,8 @@ func (n *node) push(in *node) {
 		switch {
 		case in.val == n.val:
 			n.addLast(in)
+			n.count++
+			n.size += in.size
 			return
 		case in.val < n.val:
 			if n.left == nil {
@@ -370,6 +457,7 @@ func repairInsert(n *node) {
 	}
 }
 
+// A node which isn't its queue's head leaves the queue's count and size to the caller
 func (n *node) pop() {
 	switch {
 	case !n.isHead():
@@ -383,6 +471,8 @@ func (n *node) pop() {
 		n.prev.next = n.next
 		n.next.prev = n.prev
 		nn := n.prev
+		nn.count = n.count - 1
+		nn.size = n.size - uint64(n.order.amount)
 		n.givePosition(nn)
 	default:
 		n.detach()
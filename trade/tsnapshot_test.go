@@ -0,0 +1,75 @@
+package trade
+
+import (
+	"bytes"
+	"testing"
+)
+
+var tsnapshotOrderMaker = NewOrderMaker()
+
+func TestSnapshot(t *testing.T) {
+	testSnapshot(t, 1, 1, 1)
+	testSnapshot(t, 100, 1, 1)
+	testSnapshot(t, 100, 10, 20)
+	testSnapshot(t, 1000, 100, 10000)
+}
+
+func testSnapshot(t *testing.T, orderCount int, lowPrice, highPrice int64) {
+	m := &MatchTrees{}
+	buys := tsnapshotOrderMaker.MkBuys(tsnapshotOrderMaker.ValRangeFlat(orderCount, lowPrice, highPrice))
+	sells := tsnapshotOrderMaker.MkSells(tsnapshotOrderMaker.ValRangeFlat(orderCount, lowPrice, highPrice))
+	for i := range buys {
+		m.PushBuy(NewOrderFromData(&buys[i]))
+		sells[i].Guid += int64(orderCount)
+		m.PushSell(NewOrderFromData(&sells[i]))
+	}
+	// Cancel some orders to leave gaps in the queues
+	for i := 0; i < orderCount; i += 3 {
+		m.Cancel(NewOrderFromData(&buys[i]))
+		m.Cancel(NewOrderFromData(&sells[i]))
+	}
+	var buf bytes.Buffer
+	if err := m.WriteSnapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	r := &MatchTrees{}
+	if err := r.ReadSnapshot(&buf, NewSlab(orderCount)); err != nil {
+		t.Fatal(err)
+	}
+	if r.Size() != m.Size() {
+		t.Errorf("Expecting %d orders, got %d instead", m.Size(), r.Size())
+	}
+	if err := validateRBT(&r.buyTree); err != nil {
+		t.Error(err)
+	}
+	for i := 1; i < orderCount; i += 3 {
+		if r.Get(buys[i].Guid) == nil {
+			t.Errorf("Guid %d not found in restored book", buys[i].Guid)
+		}
+	}
+	for m.Size() > 0 {
+		var o, ro *Order
+		if m.PeekBuy() != nil {
+			o, ro = m.PopBuy(), r.PopBuy()
+		} else {
+			o, ro = m.PopSell(), r.PopSell()
+		}
+		if ro == nil || o.Guid() != ro.Guid() || o.Price() != ro.Price() || o.Amount() != ro.Amount() {
+			t.Errorf("Expecting %v, got %v instead", o, ro)
+			return
+		}
+	}
+}
+
+func TestSnapshotNotEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	m := &MatchTrees{}
+	m.WriteSnapshot(&buf)
+	m.PushBuy(tsnapshotOrderMaker.MkPricedBuy(1))
+	if err := m.ReadSnapshot(&buf, NewSlab(1)); err == nil {
+		t.Errorf("Expecting error restoring into a non-empty book")
+	}
+	if err := (&MatchTrees{}).ReadSnapshot(bytes.NewBufferString("NOTASNAPSHOT0000000000000000"), NewSlab(1)); err != ErrSnapshot {
+		t.Errorf("Expecting snapshot error, got %v instead", err)
+	}
+}
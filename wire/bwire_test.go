@@ -0,0 +1,24 @@
+package wire
+
+import (
+	"github.com/fmstephe/matching_engine/trade"
+	"testing"
+)
+
+func BenchmarkOrderData(b *testing.B) {
+	buf := make([]byte, OrderDataLen)
+	od := trade.OrderData{}
+	for i := 0; i < b.N; i++ {
+		EncodeOrderData(buf, MAX_VERSION, &testOrder)
+		DecodeOrderData(buf, &od)
+	}
+}
+
+func BenchmarkResponse(b *testing.B) {
+	buf := make([]byte, ResponseLen)
+	r := trade.Response{}
+	for i := 0; i < b.N; i++ {
+		EncodeResponse(buf, MAX_VERSION, &testResponse)
+		DecodeResponse(buf, &r)
+	}
+}
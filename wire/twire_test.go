@@ -0,0 +1,179 @@
+package wire
+
+import (
+	"encoding/binary"
+	"github.com/fmstephe/matching_engine/trade"
+	"testing"
+)
+
+var (
+	testOrder    = trade.OrderData{Seq: 7, RecvTime: -1, Price: 1234, Guid: 1<<32 | 5, Amount: 10, StockId: 3, Kind: trade.SELL}
+	testResponse = trade.Response{Seq: 7, RecvTime: 99, Kind: trade.PARTIAL, Price: -1234, Amount: 4, TraderId: 1, TradeId: 5, CounterParty: 2}
+)
+
+func TestOrderData(t *testing.T) {
+	b := make([]byte, OrderDataLen)
+	n, err := EncodeOrderData(b, MAX_VERSION, &testOrder)
+	if err != nil || n != OrderDataLen {
+		t.Fatalf("Expecting %d, got %d (%v) instead", OrderDataLen, n, err)
+	}
+	expected := Header{BlockLength: 41, TemplateId: ORDER_DATA_TEMPLATE, SchemaId: SCHEMA_ID, Version: MAX_VERSION}
+	if h, _ := ReadHeader(b); h != expected {
+		t.Errorf("Expecting %v, got %v instead", expected, h)
+	}
+	if price := int64(binary.LittleEndian.Uint64(b[HeaderLen+16:])); price != testOrder.Price {
+		t.Errorf("Expecting %d, got %d instead", testOrder.Price, price)
+	}
+	od := trade.OrderData{}
+	if n, err := DecodeOrderData(b, &od); err != nil || n != OrderDataLen || od != testOrder {
+		t.Errorf("Expecting %v, got %v (%v) instead", testOrder, od, err)
+	}
+	if _, err := EncodeOrderData(b[:OrderDataLen-1], MAX_VERSION, &testOrder); err != ErrShort {
+		t.Errorf("Expecting %v, got %v instead", ErrShort, err)
+	}
+	if _, err := DecodeOrderData(b[:OrderDataLen-1], &od); err != ErrShort {
+		t.Errorf("Expecting %v, got %v instead", ErrShort, err)
+	}
+	if _, err := DecodeResponse(b, &trade.Response{}); err != ErrTemplate {
+		t.Errorf("Expecting %v, got %v instead", ErrTemplate, err)
+	}
+	b[HeaderLen+40] = 9
+	if _, err := DecodeOrderData(b, &od); err != ErrMalformed {
+		t.Errorf("Expecting %v, got %v instead", ErrMalformed, err)
+	}
+}
+
+func TestResponse(t *testing.T) {
+	b := make([]byte, ResponseLen)
+	if n, err := EncodeResponse(b, MAX_VERSION, &testResponse); err != nil || n != ResponseLen {
+		t.Fatalf("Expecting %d, got %d (%v) instead", ResponseLen, n, err)
+	}
+	r := trade.Response{}
+	if n, err := DecodeResponse(b, &r); err != nil || n != ResponseLen || r != testResponse {
+		t.Errorf("Expecting %v, got %v (%v) instead", testResponse, r, err)
+	}
+	if _, err := EncodeResponse(b, MAX_VERSION+1, &testResponse); err != ErrVersion {
+		t.Errorf("Expecting %v, got %v instead", ErrVersion, err)
+	}
+}
+
+// A newer version's block is read as far as this version knows it
+func TestNewerVersion(t *testing.T) {
+	b := make([]byte, ResponseLen+4)
+	EncodeResponse(b, MAX_VERSION, &testResponse)
+	(&Header{BlockLength: 45, TemplateId: RESPONSE_TEMPLATE, SchemaId: SCHEMA_ID, Version: MAX_VERSION + 1}).encode(b)
+	r := trade.Response{}
+	if n, err := DecodeResponse(b, &r); err != nil || n != ResponseLen+4 || r != testResponse {
+		t.Errorf("Expecting %v, got %v (%d, %v) instead", testResponse, r, n, err)
+	}
+	(&Header{BlockLength: 40, TemplateId: RESPONSE_TEMPLATE, SchemaId: SCHEMA_ID, Version: MAX_VERSION + 1}).encode(b)
+	if _, err := DecodeResponse(b, &r); err != ErrMalformed {
+		t.Errorf("Expecting %v, got %v instead", ErrMalformed, err)
+	}
+	(&Header{BlockLength: 41, TemplateId: RESPONSE_TEMPLATE, SchemaId: SCHEMA_ID, Version: 0}).encode(b)
+	if _, err := DecodeResponse(b, &r); err != ErrVersion {
+		t.Errorf("Expecting %v, got %v instead", ErrVersion, err)
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	b := make([]byte, HelloLen)
+	EncodeHello(b, &Hello{MinVersion: 1, MaxVersion: 5})
+	hello := Hello{}
+	if n, err := DecodeHello(b, &hello); err != nil || n != HelloLen {
+		t.Fatalf("Expecting %d, got %d (%v) instead", HelloLen, n, err)
+	}
+	if v, err := Negotiate(hello.MinVersion, hello.MaxVersion); err != nil || v != MAX_VERSION {
+		t.Errorf("Expecting %d, got %d (%v) instead", MAX_VERSION, v, err)
+	}
+	if v, err := Negotiate(0, MIN_VERSION); err != nil || v != MIN_VERSION {
+		t.Errorf("Expecting %d, got %d (%v) instead", MIN_VERSION, v, err)
+	}
+	if _, err := Negotiate(MAX_VERSION+1, MAX_VERSION+2); err != ErrVersion {
+		t.Errorf("Expecting %v, got %v instead", ErrVersion, err)
+	}
+	if _, err := Negotiate(0, MIN_VERSION-1); err != ErrVersion {
+		t.Errorf("Expecting %v, got %v instead", ErrVersion, err)
+	}
+}
+
+func TestZeroAlloc(t *testing.T) {
+	b := make([]byte, OrderDataLen)
+	od := trade.OrderData{}
+	r := trade.Response{}
+	allocs := testing.AllocsPerRun(100, func() {
+		EncodeOrderData(b, MAX_VERSION, &testOrder)
+		DecodeOrderData(b, &od)
+		EncodeResponse(b, MAX_VERSION, &testResponse)
+		DecodeResponse(b, &r)
+	})
+	if allocs != 0 {
+		t.Errorf("Expecting %d, got %v instead", 0, allocs)
+	}
+}
+
+// Anything which decodes must encode back to the bytes it was read from, less any newer fields
+func FuzzOrderData(f *testing.F) {
+	b := make([]byte, OrderDataLen)
+	EncodeOrderData(b, MAX_VERSION, &testOrder)
+	f.Add(b)
+	f.Add(b[:HeaderLen])
+	f.Fuzz(func(t *testing.T, in []byte) {
+		od := trade.OrderData{}
+		n, err := DecodeOrderData(in, &od)
+		if err != nil {
+			return
+		}
+		out := make([]byte, OrderDataLen)
+		if _, err := EncodeOrderData(out, MAX_VERSION, &od); err != nil {
+			t.Fatal(err)
+		}
+		if n < OrderDataLen || string(out[HeaderLen:]) != string(in[HeaderLen:OrderDataLen]) {
+			t.Errorf("Expecting %v, got %v instead", in[:n], out)
+		}
+		decoded := trade.OrderData{}
+		if _, err := DecodeOrderData(out, &decoded); err != nil || decoded != od {
+			t.Errorf("Expecting %v, got %v (%v) instead", od, decoded, err)
+		}
+	})
+}
+
+func FuzzResponse(f *testing.F) {
+	b := make([]byte, ResponseLen)
+	EncodeResponse(b, MAX_VERSION, &testResponse)
+	f.Add(b)
+	f.Add(b[:HeaderLen])
+	f.Fuzz(func(t *testing.T, in []byte) {
+		r := trade.Response{}
+		n, err := DecodeResponse(in, &r)
+		if err != nil {
+			return
+		}
+		out := make([]byte, ResponseLen)
+		if _, err := EncodeResponse(out, MAX_VERSION, &r); err != nil {
+			t.Fatal(err)
+		}
+		if n < ResponseLen || string(out[HeaderLen:]) != string(in[HeaderLen:ResponseLen]) {
+			t.Errorf("Expecting %v, got %v instead", in[:n], out)
+		}
+		decoded := trade.Response{}
+		if _, err := DecodeResponse(out, &decoded); err != nil || decoded != r {
+			t.Errorf("Expecting %v, got %v (%v) instead", r, decoded, err)
+		}
+	})
+}
+
+func FuzzHello(f *testing.F) {
+	b := make([]byte, HelloLen)
+	EncodeHello(b, &Hello{MinVersion: 1, MaxVersion: 2})
+	f.Add(b)
+	f.Fuzz(func(t *testing.T, in []byte) {
+		hello := Hello{}
+		if _, err := DecodeHello(in, &hello); err != nil {
+			return
+		}
+		if v, err := Negotiate(hello.MinVersion, hello.MaxVersion); err == nil && (v < MIN_VERSION || v > MAX_VERSION || v < hello.MinVersion || v > hello.MaxVersion) {
+			t.Errorf("Expecting a version in [%d, %d], got %d instead", hello.MinVersion, hello.MaxVersion, v)
+		}
+	})
+}
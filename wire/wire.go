@@ -0,0 +1,239 @@
+package wire
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/fmstephe/matching_engine/trade"
+)
+
+// A fixed layout, little endian, encoding of the engine's core structs in the style of Simple Binary Encoding.
+// Every message is a header followed by a block of fixed size fields. A version only ever appends fields to a
+// block, so a reader skips the fields of a newer version using the header's block length.
+
+const SCHEMA_ID = 1
+
+// The schema versions this package can read and write
+const (
+	MIN_VERSION = 1
+	MAX_VERSION = 1
+)
+
+// Template ids
+const (
+	HELLO_TEMPLATE      = 1
+	ORDER_DATA_TEMPLATE = 2
+	RESPONSE_TEMPLATE   = 3
+)
+
+const HeaderLen = 8
+
+// Block lengths, by version
+var (
+	helloBlock     = [MAX_VERSION + 1]int{1: 4}
+	orderDataBlock = [MAX_VERSION + 1]int{1: 41}
+	responseBlock  = [MAX_VERSION + 1]int{1: 41}
+)
+
+// The size of each message at MAX_VERSION
+const (
+	HelloLen     = HeaderLen + 4
+	OrderDataLen = HeaderLen + 41
+	ResponseLen  = HeaderLen + 41
+)
+
+var (
+	ErrShort     = errors.New("Buffer too short for message")
+	ErrMalformed = errors.New("Malformed message")
+	ErrTemplate  = errors.New("Unexpected template or schema")
+	ErrVersion   = errors.New("Unsupported schema version")
+)
+
+type Header struct {
+	BlockLength uint16
+	TemplateId  uint16
+	SchemaId    uint16
+	Version     uint16
+}
+
+func (h *Header) encode(b []byte) {
+	binary.LittleEndian.PutUint16(b[0:], h.BlockLength)
+	binary.LittleEndian.PutUint16(b[2:], h.TemplateId)
+	binary.LittleEndian.PutUint16(b[4:], h.SchemaId)
+	binary.LittleEndian.PutUint16(b[6:], h.Version)
+}
+
+// Reads the header at the start of b
+func ReadHeader(b []byte) (Header, error) {
+	if len(b) < HeaderLen {
+		return Header{}, ErrShort
+	}
+	h := Header{}
+	h.BlockLength = binary.LittleEndian.Uint16(b[0:])
+	h.TemplateId = binary.LittleEndian.Uint16(b[2:])
+	h.SchemaId = binary.LittleEndian.Uint16(b[4:])
+	h.Version = binary.LittleEndian.Uint16(b[6:])
+	return h, nil
+}
+
+// Returns the highest version in both [MIN_VERSION, MAX_VERSION] and [low, high]
+func Negotiate(low, high uint16) (uint16, error) {
+	if high > MAX_VERSION {
+		high = MAX_VERSION
+	}
+	if low < MIN_VERSION {
+		low = MIN_VERSION
+	}
+	if low > high {
+		return 0, ErrVersion
+	}
+	return high, nil
+}
+
+// Sent by each side of a connection before anything else, both then use the version Negotiate picks from
+// the other side's range. Always written at MIN_VERSION so any peer can read it.
+type Hello struct {
+	MinVersion uint16
+	MaxVersion uint16
+}
+
+func EncodeHello(b []byte, hello *Hello) (int, error) {
+	block, err := start(b, HELLO_TEMPLATE, MIN_VERSION, helloBlock[:])
+	if err != nil {
+		return 0, err
+	}
+	binary.LittleEndian.PutUint16(block[0:], hello.MinVersion)
+	binary.LittleEndian.PutUint16(block[2:], hello.MaxVersion)
+	return HeaderLen + len(block), nil
+}
+
+// Reads a Hello from any version of the schema
+func DecodeHello(b []byte, hello *Hello) (int, error) {
+	h, err := ReadHeader(b)
+	if err != nil {
+		return 0, err
+	}
+	if h.TemplateId != HELLO_TEMPLATE || h.SchemaId != SCHEMA_ID {
+		return 0, ErrTemplate
+	}
+	if int(h.BlockLength) < helloBlock[MIN_VERSION] {
+		return 0, ErrMalformed
+	}
+	n := HeaderLen + int(h.BlockLength)
+	if len(b) < n {
+		return 0, ErrShort
+	}
+	hello.MinVersion = binary.LittleEndian.Uint16(b[HeaderLen:])
+	hello.MaxVersion = binary.LittleEndian.Uint16(b[HeaderLen+2:])
+	return n, nil
+}
+
+// Writes od into b at version, returning the number of bytes written
+func EncodeOrderData(b []byte, version uint16, od *trade.OrderData) (int, error) {
+	block, err := start(b, ORDER_DATA_TEMPLATE, version, orderDataBlock[:])
+	if err != nil {
+		return 0, err
+	}
+	binary.LittleEndian.PutUint64(block[0:], od.Seq)
+	binary.LittleEndian.PutUint64(block[8:], uint64(od.RecvTime))
+	binary.LittleEndian.PutUint64(block[16:], uint64(od.Price))
+	binary.LittleEndian.PutUint64(block[24:], uint64(od.Guid))
+	binary.LittleEndian.PutUint32(block[32:], od.Amount)
+	binary.LittleEndian.PutUint32(block[36:], od.StockId)
+	block[40] = byte(od.Kind)
+	return HeaderLen + len(block), nil
+}
+
+// Reads an OrderData from the start of b, returning the number of bytes read
+func DecodeOrderData(b []byte, od *trade.OrderData) (int, error) {
+	block, err := open(b, ORDER_DATA_TEMPLATE, orderDataBlock[:])
+	if err != nil {
+		return 0, err
+	}
+	kind := trade.OrderKind(block[40])
+	if kind != trade.BUY && kind != trade.SELL && kind != trade.CANCEL {
+		return 0, ErrMalformed
+	}
+	od.Seq = binary.LittleEndian.Uint64(block[0:])
+	od.RecvTime = int64(binary.LittleEndian.Uint64(block[8:]))
+	od.Price = int64(binary.LittleEndian.Uint64(block[16:]))
+	od.Guid = int64(binary.LittleEndian.Uint64(block[24:]))
+	od.Amount = binary.LittleEndian.Uint32(block[32:])
+	od.StockId = binary.LittleEndian.Uint32(block[36:])
+	od.Kind = kind
+	return HeaderLen + len(block), nil
+}
+
+// Writes r into b at version, returning the number of bytes written
+func EncodeResponse(b []byte, version uint16, r *trade.Response) (int, error) {
+	block, err := start(b, RESPONSE_TEMPLATE, version, responseBlock[:])
+	if err != nil {
+		return 0, err
+	}
+	binary.LittleEndian.PutUint64(block[0:], r.Seq)
+	binary.LittleEndian.PutUint64(block[8:], uint64(r.RecvTime))
+	binary.LittleEndian.PutUint64(block[16:], uint64(r.Price))
+	binary.LittleEndian.PutUint32(block[24:], r.Amount)
+	binary.LittleEndian.PutUint32(block[28:], r.TraderId)
+	binary.LittleEndian.PutUint32(block[32:], r.TradeId)
+	binary.LittleEndian.PutUint32(block[36:], r.CounterParty)
+	block[40] = byte(r.Kind)
+	return HeaderLen + len(block), nil
+}
+
+// Reads a Response from the start of b, returning the number of bytes read
+func DecodeResponse(b []byte, r *trade.Response) (int, error) {
+	block, err := open(b, RESPONSE_TEMPLATE, responseBlock[:])
+	if err != nil {
+		return 0, err
+	}
+	kind := trade.ResponseKind(block[40])
+	if kind != trade.PARTIAL && kind != trade.FULL && kind != trade.CANCELLED && kind != trade.NOT_CANCELLED {
+		return 0, ErrMalformed
+	}
+	r.Seq = binary.LittleEndian.Uint64(block[0:])
+	r.RecvTime = int64(binary.LittleEndian.Uint64(block[8:]))
+	r.Price = int64(binary.LittleEndian.Uint64(block[16:]))
+	r.Amount = binary.LittleEndian.Uint32(block[24:])
+	r.TraderId = binary.LittleEndian.Uint32(block[28:])
+	r.TradeId = binary.LittleEndian.Uint32(block[32:])
+	r.CounterParty = binary.LittleEndian.Uint32(block[36:])
+	r.Kind = kind
+	return HeaderLen + len(block), nil
+}
+
+// Writes the header for template at version, returning the block to write its fields into
+func start(b []byte, template, version uint16, blocks []int) ([]byte, error) {
+	if version < MIN_VERSION || version > MAX_VERSION {
+		return nil, ErrVersion
+	}
+	n := blocks[version]
+	if len(b) < HeaderLen+n {
+		return nil, ErrShort
+	}
+	h := Header{BlockLength: uint16(n), TemplateId: template, SchemaId: SCHEMA_ID, Version: version}
+	h.encode(b)
+	return b[HeaderLen : HeaderLen+n], nil
+}
+
+// Reads the header for template, returning its whole block. A block from a newer version than MAX_VERSION
+// is read as MAX_VERSION.
+func open(b []byte, template uint16, blocks []int) ([]byte, error) {
+	h, err := ReadHeader(b)
+	if err != nil {
+		return nil, err
+	}
+	if h.TemplateId != template || h.SchemaId != SCHEMA_ID {
+		return nil, ErrTemplate
+	}
+	if h.Version < MIN_VERSION {
+		return nil, ErrVersion
+	}
+	if int(h.BlockLength) < blocks[min(h.Version, MAX_VERSION)] {
+		return nil, ErrMalformed
+	}
+	n := HeaderLen + int(h.BlockLength)
+	if len(b) < n {
+		return nil, ErrShort
+	}
+	return b[HeaderLen:n], nil
+}